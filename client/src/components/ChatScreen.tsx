@@ -49,7 +49,7 @@ interface ChatScreenProps {
 }
 
 const ChatScreen: React.FC<ChatScreenProps> = ({ backendHost, setError }) => {
-  const { messages, initialText, initialAudio, language, subtitleLanguage, isIntroDone, conversationId, conversationSecret, hasEnded, addMessage, setIsIntroDone, setHasEnded, resetStore } = useConversationStore();
+  const { messages, initialText, initialAudio, language, subtitleLanguage, isIntroDone, hasEnded, addMessage, setIsIntroDone, setHasEnded, setTokens, resetStore } = useConversationStore();
 
   const [isRecording, setIsRecording] = useState(false);
   const [isProcessing, setIsProcessing] = useState(false);
@@ -117,20 +117,49 @@ const ChatScreen: React.FC<ChatScreenProps> = ({ backendHost, setError }) => {
     }
   };
 
+  // Sends a request with the conversation's access token, refreshing the token
+  // once if it has expired
+  const authorizedFetch = async (path: string, init: RequestInit) => {
+    const send = () => fetch(`${backendHost}${path}`, {
+      ...init,
+      headers: {
+        ...init.headers,
+        'Authorization': `Bearer ${useConversationStore.getState().accessToken}`,
+      },
+    });
+
+    const response = await send();
+    if (response.status !== 401) {
+      return response;
+    }
+
+    const refreshResponse = await fetch(`${backendHost}/auth/refresh`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify({ refreshToken: useConversationStore.getState().refreshToken }),
+    });
+
+    const refreshData = await refreshResponse.json();
+    if (!refreshResponse.ok || !refreshData.data) {
+      return response;
+    }
+
+    setTokens(refreshData.data.accessToken, refreshData.data.refreshToken);
+
+    return send();
+  };
+
   const sendAudioToServer = async (audioBlob: Blob) => {
     const formData = new FormData();
     formData.append('file', audioBlob, 'audio.wav');
 
-    const authString = btoa(`${conversationId}:${conversationSecret}`);
-
     setIsProcessing(true);
 
     try {
-      const response = await fetch(`${backendHost}/chat/answer`, {
+      const response = await authorizedFetch('/chat/answer', {
         method: 'POST',
-        headers: {
-          'Authorization': `Basic ${authString}`,
-        },
         body: formData,
       });
 
@@ -210,16 +239,11 @@ const ChatScreen: React.FC<ChatScreenProps> = ({ backendHost, setError }) => {
   }
 
   const endConversation = async () => {
-    const authString = btoa(`${conversationId}:${conversationSecret}`);
-
     setIsProcessing(true);
 
     try {
-      const response = await fetch(`${backendHost}/chat/end`, {
+      const response = await authorizedFetch('/chat/end', {
         method: 'GET',
-        headers: {
-          'Authorization': `Basic ${authString}`,
-        },
       });
 
       const data = await response.json();
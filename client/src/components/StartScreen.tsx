@@ -30,7 +30,7 @@ const StartScreen: React.FC<StartScreenProps> = ({ backendHost, setError }) => {
   const {
     role, topic, language, subtitleLanguage, messages,
     setIsIntroDone, setMessages, setRole, setTopic, setLanguage,
-    setSubtitleLanguage, setConversationId, setConversationSecret,
+    setSubtitleLanguage, setConversationId, setConversationSecret, setTokens,
     setInitialAudio, setInitialText, setInitialSubtitle,
     setHasEnded,
   } = useConversationStore();
@@ -61,6 +61,7 @@ const StartScreen: React.FC<StartScreenProps> = ({ backendHost, setError }) => {
       if (response.ok && data.data) {
         setConversationId(data.data?.id);
         setConversationSecret(data.data?.secret);
+        setTokens(data.data?.accessToken, data.data?.refreshToken);
         setInitialAudio(data.data?.audio);
         setInitialText(data.data?.text);
         setInitialSubtitle(data.data?.subtitle || '');
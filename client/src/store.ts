@@ -9,6 +9,8 @@ const initialState = {
   subtitleLanguage: "",
   conversationId: "",
   conversationSecret: "",
+  accessToken: "",
+  refreshToken: "",
   initialAudio: "",
   initialText: "",
   initialSubtitle: "",
@@ -30,6 +32,8 @@ interface ConversationState {
   subtitleLanguage: string;
   conversationId: string;
   conversationSecret: string;
+  accessToken: string;
+  refreshToken: string;
   initialAudio: string;
   initialText: string;
   initialSubtitle: string;
@@ -43,6 +47,7 @@ interface ConversationState {
   setSubtitleLanguage: (subtitleLanguage: string) => void;
   setConversationId: (id: string) => void;
   setConversationSecret: (secret: string) => void;
+  setTokens: (accessToken: string, refreshToken: string) => void;
   setInitialAudio: (audio: string) => void;
   setInitialText: (text: string) => void;
   setInitialSubtitle: (subtitle: string) => void;
@@ -63,6 +68,7 @@ export const useConversationStore = create<ConversationState>((set) => ({
   setSubtitleLanguage: (subtitleLanguage) => set({ subtitleLanguage }),
   setConversationId: (id) => set({ conversationId: id }),
   setConversationSecret: (secret) => set({ conversationSecret: secret }),
+  setTokens: (accessToken, refreshToken) => set({ accessToken, refreshToken }),
   setInitialAudio: (audio) => set({ initialAudio: audio }),
   setInitialText: (text) => set({ initialText: text }),
   setInitialSubtitle: (subtitle) => set({ initialSubtitle: subtitle }),
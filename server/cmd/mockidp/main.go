@@ -0,0 +1,292 @@
+// Command mockidp is a minimal OpenID Connect provider for trying out single
+// sign-on locally. It signs in whoever asks, under any username they type, so
+// it must never be used outside development.
+//
+//	go run ./cmd/mockidp -addr :9000 -client-id mock-conversation
+//
+// and point the server at it with OIDC_ISSUER=http://localhost:9000.
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	keyID        = "mock-key"
+	codeLifetime = time.Minute
+	tokenTTL     = 5 * time.Minute
+)
+
+type authorization struct {
+	clientID    string
+	redirectURI string
+	challenge   string
+	nonce       string
+	username    string
+	expiresAt   time.Time
+}
+
+type provider struct {
+	issuer   string
+	clientID string
+	key      *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<body>
+	<h1>Mock identity provider</h1>
+	<form method="post" action="/authorize">
+		{{range $name, $value := .}}<input type="hidden" name="{{$name}}" value="{{index $value 0}}">
+		{{end}}<label>Username <input name="login_hint" autofocus></label>
+		<button type="submit">Sign in</button>
+	</form>
+</body>
+</html>`))
+
+func main() {
+	addr := flag.String("addr", ":9000", "address to listen on")
+	issuer := flag.String("issuer", "http://localhost:9000", "issuer URL the provider is reached at")
+	clientID := flag.String("client-id", "mock-conversation", "client ID to accept")
+	flag.Parse()
+
+	p, err := newProvider(*issuer, *clientID)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Mock identity provider %s listening on %s", p.issuer, *addr)
+	if err := http.ListenAndServe(*addr, p.routes()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// newProvider creates a provider with a fresh signing key, issuing tokens for
+// the given client.
+func newProvider(issuer, clientID string) (*provider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	return &provider{
+		issuer:   strings.TrimSuffix(issuer, "/"),
+		clientID: clientID,
+		key:      key,
+		codes:    make(map[string]authorization),
+	}, nil
+}
+
+func (p *provider) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("GET /jwks", p.jwks)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("POST /token", p.token)
+
+	return mux
+}
+
+func (p *provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *provider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kid": keyID,
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+// authorize shows a login form, or signs the user in straight away when the
+// username is given as login_hint.
+func (p *provider) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	params := r.Form
+	if params.Get("client_id") != p.clientID || params.Get("response_type") != "code" {
+		http.Error(w, "unknown client or unsupported response type", http.StatusBadRequest)
+		return
+	}
+
+	if params.Get("code_challenge") == "" || params.Get("code_challenge_method") != "S256" {
+		http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+		return
+	}
+
+	redirectURI, err := url.Parse(params.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	username := strings.TrimSpace(params.Get("login_hint"))
+	if username == "" {
+		form := url.Values{}
+		for name, value := range params {
+			if name != "login_hint" {
+				form[name] = value
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		loginPage.Execute(w, form)
+		return
+	}
+
+	code := randomString()
+
+	p.mu.Lock()
+	p.codes[code] = authorization{
+		clientID:    params.Get("client_id"),
+		redirectURI: params.Get("redirect_uri"),
+		challenge:   params.Get("code_challenge"),
+		nonce:       params.Get("nonce"),
+		username:    username,
+		expiresAt:   time.Now().Add(codeLifetime),
+	}
+	p.mu.Unlock()
+
+	query := redirectURI.Query()
+	query.Set("code", code)
+	query.Set("state", params.Get("state"))
+	redirectURI.RawQuery = query.Encode()
+
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (p *provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type")
+		return
+	}
+
+	code := r.PostForm.Get("code")
+
+	// Codes are single use, whether or not the exchange succeeds
+	p.mu.Lock()
+	auth, ok := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+
+	clientID := r.PostForm.Get("client_id")
+	if basicID, _, ok := r.BasicAuth(); ok {
+		clientID, _ = url.QueryUnescape(basicID)
+	}
+
+	switch {
+	case !ok || time.Now().After(auth.expiresAt):
+		tokenError(w, "invalid_grant")
+	case clientID != auth.clientID || r.PostForm.Get("redirect_uri") != auth.redirectURI:
+		tokenError(w, "invalid_grant")
+	case challenge(r.PostForm.Get("code_verifier")) != auth.challenge:
+		tokenError(w, "invalid_grant")
+	default:
+		idToken, err := p.sign(auth)
+		if err != nil {
+			log.Printf("failed to sign ID token: %v", err)
+			tokenError(w, "server_error")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]any{
+			"access_token": randomString(),
+			"token_type":   "Bearer",
+			"expires_in":   int(tokenTTL.Seconds()),
+			"id_token":     idToken,
+		})
+	}
+}
+
+func (p *provider) sign(auth authorization) (string, error) {
+	now := time.Now()
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(map[string]any{
+		"iss":                p.issuer,
+		"sub":                "mock|" + strings.ToLower(auth.username),
+		"aud":                auth.clientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(tokenTTL).Unix(),
+		"nonce":              auth.nonce,
+		"preferred_username": auth.username,
+		"email":              auth.username + "@example.com",
+		"email_verified":     true,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to read random bytes: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/madeindra/mock-conversation/server/internal/config"
+	"github.com/madeindra/mock-conversation/server/internal/handler"
+	"github.com/madeindra/mock-conversation/server/internal/model"
+)
+
+const testClientID = "mock-conversation"
+
+// startServers runs the mock provider and the server configured to sign in
+// through it, and returns the server's URL.
+func startServers(t *testing.T) string {
+	t.Helper()
+
+	// Each server is routed once its URL is known, as the other needs it
+	idpMux := http.NewServeMux()
+	idp := httptest.NewServer(idpMux)
+	t.Cleanup(idp.Close)
+
+	p, err := newProvider(idp.URL, testClientID)
+	if err != nil {
+		t.Fatalf("failed to create provider: %v", err)
+	}
+	idpMux.Handle("/", p.routes())
+
+	appMux := http.NewServeMux()
+	app := httptest.NewServer(appMux)
+	t.Cleanup(app.Close)
+
+	appMux.Handle("/", handler.NewHandler(config.AppConfig{
+		APIKey: "test-key",
+		DBPath: filepath.Join(t.TempDir(), "app.db"),
+		Auth: config.Auth{
+			TokenSecret:     []byte("test-secret"),
+			AccessTokenTTL:  time.Minute,
+			RefreshTokenTTL: time.Hour,
+		},
+		OIDC: config.OIDC{
+			Issuer:      idp.URL,
+			ClientID:    testClientID,
+			RedirectURL: app.URL + "/auth/oidc/callback",
+		},
+	}))
+
+	return app.URL
+}
+
+// startLogin starts single sign-on as a browser would and signs in at the
+// provider, returning the callback the provider sends the browser to and the
+// state cookie the server set.
+func startLogin(t *testing.T, client *http.Client, appURL, username string) (string, *http.Cookie) {
+	t.Helper()
+
+	resp, err := client.Get(appURL + "/auth/oidc/login")
+	if err != nil {
+		t.Fatalf("failed to start login: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("login status = %d, want %d", resp.StatusCode, http.StatusFound)
+	}
+
+	var stateCookie *http.Cookie
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "oidc_state" {
+			stateCookie = cookie
+		}
+	}
+	if stateCookie == nil {
+		t.Fatal("login set no state cookie")
+	}
+	if !stateCookie.HttpOnly || !stateCookie.Secure || stateCookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("state cookie = %+v, want HttpOnly, Secure and SameSite=Lax", stateCookie)
+	}
+
+	authURL, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid authorization URL: %v", err)
+	}
+
+	query := authURL.Query()
+	query.Set("login_hint", username)
+	authURL.RawQuery = query.Encode()
+
+	resp, err = client.Get(authURL.String())
+	if err != nil {
+		t.Fatalf("failed to sign in at the provider: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize status = %d, want %d", resp.StatusCode, http.StatusFound)
+	}
+
+	return resp.Header.Get("Location"), stateCookie
+}
+
+func callback(t *testing.T, client *http.Client, callbackURL string, cookie *http.Cookie) (int, model.LoginResponse) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, callbackURL, nil)
+	if err != nil {
+		t.Fatalf("failed to build callback: %v", err)
+	}
+	if cookie != nil {
+		req.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("failed to call back: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Data model.LoginResponse `json:"data"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+
+	return resp.StatusCode, body.Data
+}
+
+func TestLogin(t *testing.T) {
+	appURL := startServers(t)
+
+	// Redirects are followed by hand, as the browser would between the
+	// two servers
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	tests := []struct {
+		name   string
+		login  func(t *testing.T) (string, *http.Cookie)
+		status int
+	}{
+		{
+			name: "same browser",
+			login: func(t *testing.T) (string, *http.Cookie) {
+				return startLogin(t, client, appURL, "ana")
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "without the state cookie",
+			login: func(t *testing.T) (string, *http.Cookie) {
+				callbackURL, _ := startLogin(t, client, appURL, "ana")
+				return callbackURL, nil
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			// An attacker's finished login planted in the victim's
+			// browser, which holds the cookie of its own login
+			name: "state cookie of another login",
+			login: func(t *testing.T) (string, *http.Cookie) {
+				_, victimCookie := startLogin(t, client, appURL, "ana")
+				attackerCallback, _ := startLogin(t, client, appURL, "mallory")
+				return attackerCallback, victimCookie
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "replayed callback",
+			login: func(t *testing.T) (string, *http.Cookie) {
+				callbackURL, cookie := startLogin(t, client, appURL, "ana")
+				if status, _ := callback(t, client, callbackURL, cookie); status != http.StatusOK {
+					t.Fatalf("first callback status = %d, want %d", status, http.StatusOK)
+				}
+				return callbackURL, cookie
+			},
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			callbackURL, cookie := tt.login(t)
+
+			status, login := callback(t, client, callbackURL, cookie)
+			if status != tt.status {
+				t.Fatalf("callback status = %d, want %d", status, tt.status)
+			}
+
+			if status == http.StatusOK && (login.Username != "ana" || login.AccessToken == "") {
+				t.Errorf("callback = %+v, want a session for ana", login)
+			}
+		})
+	}
+}
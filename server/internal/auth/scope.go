@@ -0,0 +1,61 @@
+package auth
+
+import "fmt"
+
+// APIKeyPrefix starts every API key, telling keys apart from access tokens.
+const APIKeyPrefix = "mck_"
+
+// Scope is a permission granted to an API key.
+type Scope string
+
+const (
+	// ScopeConversationsWrite allows starting and taking part in the
+	// account's conversations
+	ScopeConversationsWrite Scope = "conversations:write"
+
+	// ScopeReportsRead allows listing the account's conversations and reading
+	// their transcripts
+	ScopeReportsRead Scope = "reports:read"
+
+	// ScopeAdmin allows everything, including managing API keys
+	ScopeAdmin Scope = "admin"
+)
+
+var scopes = []Scope{ScopeConversationsWrite, ScopeReportsRead, ScopeAdmin}
+
+// ParseScopes validates the given scope names.
+func ParseScopes(names []string) ([]Scope, error) {
+	parsed := make([]Scope, 0, len(names))
+	for _, name := range names {
+		scope, ok := lookupScope(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown scope %q", name)
+		}
+		parsed = append(parsed, scope)
+	}
+	return parsed, nil
+}
+
+func lookupScope(name string) (Scope, bool) {
+	for _, scope := range scopes {
+		if string(scope) == name {
+			return scope, true
+		}
+	}
+	return "", false
+}
+
+// Allows reports whether the claims grant the scope. Scopes only restrict API
+// keys; a session may do anything its kind allows except administration.
+func (c Claims) Allows(scope Scope) bool {
+	if c.KeyID == "" {
+		return scope != ScopeAdmin
+	}
+
+	for _, granted := range c.Scopes {
+		if granted == scope || granted == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("test-secret")
+
+// resign replaces the claims of a token while keeping its signature, as a
+// client tampering with its own token would.
+func resign(t *testing.T, token string, edit func(*Claims)) string {
+	t.Helper()
+
+	encoded, signature, _ := strings.Cut(token, ".")
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("failed to decode token: %v", err)
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatalf("failed to read claims: %v", err)
+	}
+
+	edit(&claims)
+
+	payload, err = json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("failed to write claims: %v", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + signature
+}
+
+func TestVerify(t *testing.T) {
+	signer := NewSigner(testSecret, time.Minute)
+
+	chatToken, _, err := signer.Sign(KindChat, "chat-1", "session-1")
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	accountToken, _, err := signer.Sign(KindAccount, "account-1", "session-2")
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	expiredToken, _, err := NewSigner(testSecret, -time.Minute).Sign(KindChat, "chat-1", "session-1")
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	otherToken, _, err := NewSigner([]byte("other-secret"), time.Minute).Sign(KindChat, "chat-1", "session-1")
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	encoded, signature, _ := strings.Cut(chatToken, ".")
+
+	tests := []struct {
+		name    string
+		token   string
+		kind    Kind
+		subject string
+		err     error
+	}{
+		{name: "chat token", token: chatToken, kind: KindChat, subject: "chat-1"},
+		{name: "account token", token: accountToken, kind: KindAccount, subject: "account-1"},
+		{name: "expired", token: expiredToken, err: ErrExpiredToken},
+		{name: "signed with another secret", token: otherToken, err: ErrInvalidToken},
+		{name: "kind changed to account", token: resign(t, chatToken, func(c *Claims) { c.Kind = KindAccount }), err: ErrInvalidToken},
+		{name: "subject changed", token: resign(t, chatToken, func(c *Claims) { c.Subject = "chat-2" }), err: ErrInvalidToken},
+		{name: "expiry extended", token: resign(t, expiredToken, func(c *Claims) { c.ExpiresAt += 3600 }), err: ErrInvalidToken},
+		{name: "scopes added", token: resign(t, accountToken, func(c *Claims) { c.Scopes = []Scope{ScopeAdmin} }), err: ErrInvalidToken},
+		{name: "signature changed", token: encoded + "." + strings.Repeat("A", len(signature)), err: ErrInvalidToken},
+		{name: "signature missing", token: encoded, err: ErrInvalidToken},
+		{name: "signature of another payload", token: base64.RawURLEncoding.EncodeToString([]byte("{}")) + "." + signature, err: ErrInvalidToken},
+		{name: "empty", token: "", err: ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := signer.Verify(tt.token)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+
+			if claims.Kind != tt.kind {
+				t.Errorf("Verify() kind = %q, want %q", claims.Kind, tt.kind)
+			}
+			if claims.Subject != tt.subject {
+				t.Errorf("Verify() subject = %q, want %q", claims.Subject, tt.subject)
+			}
+		})
+	}
+}
+
+func TestAllows(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims Claims
+		scope  Scope
+		want   bool
+	}{
+		{name: "session writes", claims: Claims{Kind: KindAccount}, scope: ScopeConversationsWrite, want: true},
+		{name: "session is not admin", claims: Claims{Kind: KindAccount}, scope: ScopeAdmin, want: false},
+		{name: "key with the scope", claims: Claims{Kind: KindAccount, KeyID: "key-1", Scopes: []Scope{ScopeReportsRead}}, scope: ScopeReportsRead, want: true},
+		{name: "key without the scope", claims: Claims{Kind: KindAccount, KeyID: "key-1", Scopes: []Scope{ScopeReportsRead}}, scope: ScopeConversationsWrite, want: false},
+		{name: "admin key", claims: Claims{Kind: KindAccount, KeyID: "key-1", Scopes: []Scope{ScopeAdmin}}, scope: ScopeConversationsWrite, want: true},
+		{name: "key without scopes", claims: Claims{Kind: KindAccount, KeyID: "key-1"}, scope: ScopeReportsRead, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.claims.Allows(tt.scope); got != tt.want {
+				t.Errorf("Allows(%q) = %v, want %v", tt.scope, got, tt.want)
+			}
+		})
+	}
+}
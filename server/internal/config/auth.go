@@ -0,0 +1,16 @@
+package config
+
+import "time"
+
+// Auth controls the access and refresh tokens issued to clients.
+type Auth struct {
+	// TokenSecret signs access tokens. Tokens signed with another secret,
+	// including one generated by an earlier run, are rejected.
+	TokenSecret []byte
+
+	// AccessTokenTTL is how long an access token is accepted.
+	AccessTokenTTL time.Duration
+
+	// RefreshTokenTTL is how long a session may go without being refreshed.
+	RefreshTokenTTL time.Duration
+}
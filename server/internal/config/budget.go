@@ -0,0 +1,35 @@
+package config
+
+// Budget caps spending, in millionths of a US dollar, and the turns taken per
+// day and per month. A zero limit means unlimited.
+type Budget struct {
+	DailyCost    int64
+	MonthlyCost  int64
+	DailyTurns   int
+	MonthlyTurns int
+}
+
+// CostLimited reports whether the budget caps spending.
+func (b Budget) CostLimited() bool {
+	return b.DailyCost > 0 || b.MonthlyCost > 0
+}
+
+// TurnLimited reports whether the budget caps the turns taken.
+func (b Budget) TurnLimited() bool {
+	return b.DailyTurns > 0 || b.MonthlyTurns > 0
+}
+
+// Budgets holds the default budgets of accounts and organizations, which
+// admins may override one account or organization at a time.
+type Budgets struct {
+	Account      Budget
+	Organization Budget
+
+	// WarnPercent is the share of a limit past which responses carry a
+	// warning
+	WarnPercent int
+
+	// Downgrade switches to cheaper models once a spending cap is exceeded
+	// instead of rejecting requests. Exceeded turn quotas always reject.
+	Downgrade bool
+}
@@ -2,7 +2,9 @@ package config
 
 import (
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type AppConfig struct {
@@ -10,6 +12,30 @@ type AppConfig struct {
 	APIKey string
 	DBPath string
 
+	// AbandonAfter is how long a conversation may stay idle before it is
+	// marked as abandoned. Zero disables the check.
+	AbandonAfter time.Duration
+
+	// EndingTimeout is how long a conversation may stay ending, waiting for
+	// its farewell, before it is put back to active. Zero disables the check.
+	EndingTimeout time.Duration
+
+	// Limits applies to every conversation; scenarios may only tighten it
+	Limits Limits
+
+	Memory Memory
+
+	RateLimit    RateLimit
+	Budgets      Budgets
+	ProviderKeys ProviderKeys
+
+	Auth Auth
+	OIDC OIDC
+
+	// AdminAccountIDs lists the accounts allowed to act as admins. Accounts are
+	// named by ID, as usernames can be claimed by anyone who registers first.
+	AdminAccountIDs []string
+
 	CORSOrigins []string
 	CORSMethods []string
 	CORSHeaders []string
@@ -30,3 +56,27 @@ func GetStrings(envName string, defaultValue []string) []string {
 
 	return defaultValue
 }
+
+func GetBool(envName string, defaultValue bool) bool {
+	if value, err := strconv.ParseBool(GetString(envName, "")); err == nil {
+		return value
+	}
+
+	return defaultValue
+}
+
+func GetFloat(envName string, defaultValue float64) float64 {
+	if value, err := strconv.ParseFloat(GetString(envName, ""), 64); err == nil {
+		return value
+	}
+
+	return defaultValue
+}
+
+func GetInt(envName string, defaultValue int) int {
+	if value, err := strconv.Atoi(GetString(envName, "")); err == nil {
+		return value
+	}
+
+	return defaultValue
+}
@@ -1,96 +1,205 @@
 package config
 
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Language is the ISO 639-1 code a conversation is stored with, e.g. "ja".
 type Language = string
 
-const (
-	LangEnglish    Language = "en"
-	LangIndonesian Language = "id"
-	LangSpanish    Language = "es"
-	LangFrench     Language = "fr"
-	LangGerman     Language = "de"
-	LangPortuguese Language = "pt"
-	LangItalian    Language = "it"
-	LangJapanese   Language = "ja"
-	LangKorean     Language = "ko"
-	LangChinese    Language = "zh"
-	LangArabic     Language = "ar"
-	LangHindi      Language = "hi"
-	LangRussian    Language = "ru"
-	LangDutch      Language = "nl"
-	LangTurkish    Language = "tr"
-)
+// DefaultLanguage is used when a conversation does not pick a language. It
+// must be present in every registry.
+const DefaultLanguage Language = "en"
+
+// AutoLanguage is the conversation language that follows whatever language
+// the user speaks.
+const AutoLanguage Language = "auto"
+
+// LanguageInfo describes one supported language. Clients refer to languages
+// by their BCP-47 tag, while conversations store the short code.
+type LanguageInfo struct {
+	Code       Language `json:"code"`
+	Tag        string   `json:"tag"`
+	Name       string   `json:"name"`
+	NativeName string   `json:"nativeName"`
+	Script     string   `json:"script"`
+	Direction  string   `json:"direction"`
+
+	// Transliteration names the romanization shown alongside languages that
+	// are not written in the Latin script
+	Transliteration string `json:"transliteration,omitempty"`
+
+	// Aliases are other names the language is reported under, such as the
+	// English names returned by speech recognition
+	Aliases []string `json:"aliases,omitempty"`
 
-var CodeToLanguage = map[string]Language{
-	"en-US": LangEnglish,
-	"id-ID": LangIndonesian,
-	"es-ES": LangSpanish,
-	"fr-FR": LangFrench,
-	"de-DE": LangGerman,
-	"pt-BR": LangPortuguese,
-	"it-IT": LangItalian,
-	"ja-JP": LangJapanese,
-	"ko-KR": LangKorean,
-	"zh-CN": LangChinese,
-	"ar-SA": LangArabic,
-	"hi-IN": LangHindi,
-	"ru-RU": LangRussian,
-	"nl-NL": LangDutch,
-	"tr-TR": LangTurkish,
+	// Voices lists preferred TTS voices; any voice is used when empty
+	Voices []string `json:"voices,omitempty"`
+
+	// STT and TTS report whether speech can be transcribed and synthesized
+	STT bool `json:"stt"`
+	TTS bool `json:"tts"`
 }
 
-var LanguageToCode = map[Language]string{
-	LangEnglish:    "en-US",
-	LangIndonesian: "id-ID",
-	LangSpanish:    "es-ES",
-	LangFrench:     "fr-FR",
-	LangGerman:     "de-DE",
-	LangPortuguese: "pt-BR",
-	LangItalian:    "it-IT",
-	LangJapanese:   "ja-JP",
-	LangKorean:     "ko-KR",
-	LangChinese:    "zh-CN",
-	LangArabic:     "ar-SA",
-	LangHindi:      "hi-IN",
-	LangRussian:    "ru-RU",
-	LangDutch:      "nl-NL",
-	LangTurkish:    "tr-TR",
+//go:embed languages.json
+var embeddedLanguages []byte
+
+type languageRegistry struct {
+	languages []LanguageInfo
+	byCode    map[Language]LanguageInfo
+	byTag     map[string]LanguageInfo
+	byAlias   map[string]LanguageInfo
 }
 
-var LanguageNames = map[string]string{
-	"en-US": "English",
-	"id-ID": "Bahasa Indonesia",
-	"es-ES": "Spanish",
-	"fr-FR": "French",
-	"de-DE": "German",
-	"pt-BR": "Portuguese",
-	"it-IT": "Italian",
-	"ja-JP": "Japanese",
-	"ko-KR": "Korean",
-	"zh-CN": "Chinese (Mandarin)",
-	"ar-SA": "Arabic",
-	"hi-IN": "Hindi",
-	"ru-RU": "Russian",
-	"nl-NL": "Dutch",
-	"tr-TR": "Turkish",
+var registry = mustParseLanguages(embeddedLanguages)
+
+// LoadLanguages replaces the embedded language registry with the one in the
+// JSON file at path. It is meant to be called once at startup.
+func LoadLanguages(path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	loaded, err := parseLanguages(raw)
+	if err != nil {
+		return fmt.Errorf("invalid language registry %s: %w", path, err)
+	}
+
+	registry = loaded
+
+	return nil
 }
 
-func GetLanguage(code string) Language {
-	if lang, ok := CodeToLanguage[code]; ok {
-		return lang
+func mustParseLanguages(raw []byte) *languageRegistry {
+	loaded, err := parseLanguages(raw)
+	if err != nil {
+		panic(fmt.Sprintf("invalid embedded language registry: %v", err))
 	}
-	return LangEnglish
+
+	return loaded
 }
 
-func GetCode(language Language) string {
-	if code, ok := LanguageToCode[language]; ok {
-		return code
+func parseLanguages(raw []byte) (*languageRegistry, error) {
+	var languages []LanguageInfo
+	if err := json.Unmarshal(raw, &languages); err != nil {
+		return nil, err
+	}
+
+	loaded := &languageRegistry{
+		languages: languages,
+		byCode:    make(map[Language]LanguageInfo, len(languages)),
+		byTag:     make(map[string]LanguageInfo, len(languages)),
+		byAlias:   make(map[string]LanguageInfo),
+	}
+
+	for _, language := range languages {
+		if language.Code == "" || language.Code == AutoLanguage || language.Tag == "" || language.Name == "" {
+			return nil, fmt.Errorf("language %q needs a code, tag and name", language.Tag)
+		}
+
+		if language.Direction != "ltr" && language.Direction != "rtl" {
+			return nil, fmt.Errorf("language %s has invalid direction %q", language.Tag, language.Direction)
+		}
+
+		if _, ok := loaded.byCode[language.Code]; ok {
+			return nil, fmt.Errorf("duplicate language code %s", language.Code)
+		}
+
+		tag := strings.ToLower(language.Tag)
+		if _, ok := loaded.byTag[tag]; ok {
+			return nil, fmt.Errorf("duplicate language tag %s", language.Tag)
+		}
+
+		loaded.byCode[language.Code] = language
+		loaded.byTag[tag] = language
+
+		for _, alias := range language.Aliases {
+			loaded.byAlias[strings.ToLower(alias)] = language
+		}
 	}
-	return "en-US"
+
+	if _, ok := loaded.byCode[DefaultLanguage]; !ok {
+		return nil, fmt.Errorf("default language %s is missing", DefaultLanguage)
+	}
+
+	return loaded, nil
+}
+
+// Languages returns every supported language in registry order.
+func Languages() []LanguageInfo {
+	languages := make([]LanguageInfo, len(registry.languages))
+	copy(languages, registry.languages)
+	return languages
+}
+
+// ConversationLanguages returns the languages a conversation can be held in,
+// which are the ones whose speech can be transcribed.
+func ConversationLanguages() []LanguageInfo {
+	var languages []LanguageInfo
+	for _, language := range registry.languages {
+		if language.STT {
+			languages = append(languages, language)
+		}
+	}
+	return languages
+}
+
+// LookupLanguage finds a language by its BCP-47 tag, ignoring case.
+func LookupLanguage(tag string) (LanguageInfo, bool) {
+	language, ok := registry.byTag[strings.ToLower(tag)]
+	return language, ok
+}
+
+// DetectLanguage resolves a language reported by speech recognition, given as
+// a code, tag or alias, to a supported language.
+func DetectLanguage(name string) (Language, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if language, ok := registry.byCode[name]; ok {
+		return language.Code, true
+	}
+	if language, ok := registry.byTag[name]; ok {
+		return language.Code, true
+	}
+	if language, ok := registry.byAlias[name]; ok {
+		return language.Code, true
+	}
+	return "", false
 }
 
-func GetLanguageName(code string) string {
-	if name, ok := LanguageNames[code]; ok {
-		return name
+// GetLanguageInfo returns the language with the given code, or the default
+// language when the code is unknown.
+func GetLanguageInfo(language Language) LanguageInfo {
+	if info, ok := registry.byCode[language]; ok {
+		return info
 	}
-	return "English"
+	return registry.byCode[DefaultLanguage]
+}
+
+func GetLanguage(tag string) Language {
+	if info, ok := LookupLanguage(tag); ok {
+		return info.Code
+	}
+	return DefaultLanguage
+}
+
+func GetCode(language Language) string {
+	return GetLanguageInfo(language).Tag
+}
+
+func GetLanguageName(tag string) string {
+	if info, ok := LookupLanguage(tag); ok {
+		return info.Name
+	}
+	return GetLanguageInfo(DefaultLanguage).Name
+}
+
+// GetTransliteration returns the romanization scheme for the language, or an
+// empty string when the language is written in the Latin script.
+func GetTransliteration(language Language) string {
+	return GetLanguageInfo(language).Transliteration
 }
@@ -0,0 +1,28 @@
+package config
+
+// Limits caps how long a conversation may run. A zero value means unlimited.
+type Limits struct {
+	MaxTurns        int
+	MaxMinutes      int
+	MaxAudioSeconds int
+}
+
+// Narrow returns the stricter of the two limits for every field, so that a
+// scenario can tighten the global limits but never loosen them.
+func (l Limits) Narrow(other Limits) Limits {
+	return Limits{
+		MaxTurns:        stricter(l.MaxTurns, other.MaxTurns),
+		MaxMinutes:      stricter(l.MaxMinutes, other.MaxMinutes),
+		MaxAudioSeconds: stricter(l.MaxAudioSeconds, other.MaxAudioSeconds),
+	}
+}
+
+func stricter(a, b int) int {
+	if a <= 0 {
+		return max(b, 0)
+	}
+	if b <= 0 || a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,11 @@
+package config
+
+// Memory controls how much of a conversation is sent to the chat model.
+type Memory struct {
+	// TokenBudget is the approximate number of tokens the history may take
+	// before older turns are folded into a summary. Zero disables folding.
+	TokenBudget int
+
+	// RecentTurns is how many of the latest turns are always kept verbatim.
+	RecentTurns int
+}
@@ -0,0 +1,21 @@
+package config
+
+// OIDC configures single sign-on with an OpenID Connect identity provider.
+// It is turned off while Issuer is empty.
+type OIDC struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+
+	// RedirectURL is the server's callback URL registered with the provider
+	RedirectURL string
+
+	// ClientRedirectURL is where the browser is sent after logging in, with
+	// the tokens in the URL fragment. When empty the callback responds with
+	// the tokens as JSON instead.
+	ClientRedirectURL string
+}
+
+func (o OIDC) Enabled() bool {
+	return o.Issuer != ""
+}
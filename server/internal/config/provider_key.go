@@ -0,0 +1,19 @@
+package config
+
+// ProviderKeys lets accounts bring their own key for the AI provider, stored
+// encrypted under the server's master keys. It is turned off while MasterKeys
+// is empty.
+type ProviderKeys struct {
+	// MasterKeys encrypt the stored provider keys. The first one encrypts new
+	// keys; the others are earlier master keys, kept to decrypt keys stored
+	// before a rotation until they are re-encrypted.
+	MasterKeys [][]byte
+
+	// Fallback lets requests of accounts without a key of their own, and of
+	// conversations without an account, use the server's key
+	Fallback bool
+}
+
+func (p ProviderKeys) Enabled() bool {
+	return len(p.MasterKeys) > 0
+}
@@ -0,0 +1,33 @@
+package config
+
+import "github.com/madeindra/mock-conversation/server/internal/ratelimit"
+
+// RateLimit caps how often clients may call the server and how many calls to
+// the upstream AI provider may be in flight at once. A zero limit means
+// unlimited.
+type RateLimit struct {
+	IP           ratelimit.Limit
+	Account      ratelimit.Limit
+	Conversation ratelimit.Limit
+
+	// OIDCLogin applies per client IP to starting single sign-on, which
+	// stores an attempt on every call
+	OIDCLogin ratelimit.Limit
+
+	// StartChat applies per client IP to starting conversations, which need
+	// no account and call the AI provider on every call
+	StartChat ratelimit.Limit
+
+	// MaxUpstreamCalls caps the requests calling the AI provider at once
+	MaxUpstreamCalls int
+
+	// TrustProxy takes the client IP from the X-Forwarded-For or X-Real-IP
+	// headers, for servers behind a reverse proxy. The headers are believed
+	// whoever sent them, so this is only safe when every request comes
+	// through a proxy that overwrites them; otherwise clients pick their own
+	// IP and escape every per-IP limit
+	TrustProxy bool
+
+	// Store keeps the buckets, in memory when nil
+	Store ratelimit.Store
+}
@@ -0,0 +1,72 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrUsernameTaken is returned when registering a username that already
+// belongs to another account.
+var ErrUsernameTaken = errors.New("username is taken")
+
+// Account is a person who can own several conversations. Password holds the
+// bcrypt hash of the password, and is empty for accounts created through
+// single sign-on.
+type Account struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	Password  string    `json:"password"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const accountColumns = "id, username, password, created_at"
+
+// CreateAccount stores a new account. Usernames are unique regardless of case.
+func (d *Database) CreateAccount(tx *sql.Tx, username, password string) (*Account, error) {
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM accounts WHERE username = ?", username).Scan(&count); err != nil {
+		return nil, err
+	}
+
+	if count > 0 {
+		return nil, ErrUsernameTaken
+	}
+
+	account := Account{
+		ID:        uuid.New().String(),
+		Username:  username,
+		Password:  password,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	_, err := tx.Exec("INSERT INTO accounts ("+accountColumns+") VALUES (?, ?, ?, ?)", account.ID, account.Username, account.Password, account.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &account, nil
+}
+
+// GetAccountByUsername returns the account with the given username, ignoring
+// case, or sql.ErrNoRows when there is none.
+func (d *Database) GetAccountByUsername(username string) (*Account, error) {
+	return scanAccount(d.conn.QueryRow("SELECT "+accountColumns+" FROM accounts WHERE username = ?", username))
+}
+
+// GetAccount returns the account with the given ID, or sql.ErrNoRows.
+func (d *Database) GetAccount(id string) (*Account, error) {
+	return scanAccount(d.conn.QueryRow("SELECT "+accountColumns+" FROM accounts WHERE id = ?", id))
+}
+
+func scanAccount(row scanner) (*Account, error) {
+	var account Account
+	var createdAt sql.NullTime
+	if err := row.Scan(&account.ID, &account.Username, &account.Password, &createdAt); err != nil {
+		return nil, err
+	}
+	account.CreatedAt = createdAt.Time
+	return &account, nil
+}
@@ -0,0 +1,109 @@
+package data
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKey lets an account's tools call the API without a browser session.
+// KeyHash holds the hash of the key, which is only shown once; Prefix keeps
+// its first characters so that the owner can tell keys apart.
+type APIKey struct {
+	ID         string    `json:"id"`
+	AccountID  string    `json:"account_id"`
+	Name       string    `json:"name"`
+	Prefix     string    `json:"prefix"`
+	KeyHash    string    `json:"key_hash"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	RevokedAt  time.Time `json:"revoked_at"`
+}
+
+const apiKeyColumns = "id, account_id, name, prefix, key_hash, scopes, created_at, last_used_at, revoked_at"
+
+// CreateAPIKey stores a new API key for the account in key. The ID and
+// creation time are filled in.
+func (d *Database) CreateAPIKey(tx *sql.Tx, key APIKey) (*APIKey, error) {
+	key.ID = uuid.New().String()
+	key.CreatedAt = time.Now().UTC()
+
+	_, err := tx.Exec("INSERT INTO api_keys (id, account_id, name, prefix, key_hash, scopes, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		key.ID, key.AccountID, key.Name, key.Prefix, key.KeyHash, strings.Join(key.Scopes, " "), key.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// GetAPIKeysByAccountID returns the account's API keys, including revoked
+// ones, newest first.
+func (d *Database) GetAPIKeysByAccountID(accountID string) ([]APIKey, error) {
+	rows, err := d.conn.Query("SELECT "+apiKeyColumns+" FROM api_keys WHERE account_id = ? ORDER BY created_at DESC, id", accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// GetAPIKeyByHash returns the API key with the given hash, or sql.ErrNoRows.
+func (d *Database) GetAPIKeyByHash(hash string) (*APIKey, error) {
+	return scanAPIKey(d.conn.QueryRow("SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = ?", hash))
+}
+
+// RevokeAPIKey revokes one of the account's API keys. It returns
+// sql.ErrNoRows when the account has no such key that is still active.
+func (d *Database) RevokeAPIKey(tx *sql.Tx, accountID, id string) error {
+	result, err := tx.Exec("UPDATE api_keys SET revoked_at = ? WHERE id = ? AND account_id = ? AND revoked_at IS NULL", time.Now().UTC(), id, accountID)
+	if err != nil {
+		return err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// TouchAPIKey records that the key was used. The time is only written when
+// the stored one is older than staleAfter, so busy keys do not cause a write
+// on every request.
+func (d *Database) TouchAPIKey(id string, staleAfter time.Duration) error {
+	now := time.Now().UTC()
+	_, err := d.conn.Exec("UPDATE api_keys SET last_used_at = ? WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)", now, id, now.Add(-staleAfter))
+	return err
+}
+
+func scanAPIKey(row scanner) (*APIKey, error) {
+	var key APIKey
+	var scopes string
+	var createdAt, lastUsedAt, revokedAt sql.NullTime
+	if err := row.Scan(&key.ID, &key.AccountID, &key.Name, &key.Prefix, &key.KeyHash, &scopes, &createdAt, &lastUsedAt, &revokedAt); err != nil {
+		return nil, err
+	}
+	key.Scopes = strings.Fields(scopes)
+	key.CreatedAt = createdAt.Time
+	key.LastUsedAt = lastUsedAt.Time
+	key.RevokedAt = revokedAt.Time
+	return &key, nil
+}
@@ -0,0 +1,54 @@
+package data
+
+import (
+	"database/sql"
+	"time"
+)
+
+// BudgetSubject is what a budget applies to.
+type BudgetSubject string
+
+const (
+	BudgetAccount      BudgetSubject = "account"
+	BudgetOrganization BudgetSubject = "organization"
+)
+
+// Budget overrides the server's default limits for a single account or
+// organization. Costs are in millionths of a US dollar and zero means
+// unlimited.
+type Budget struct {
+	Subject      BudgetSubject `json:"subject"`
+	SubjectID    string        `json:"subject_id"`
+	DailyCost    int64         `json:"daily_cost"`
+	MonthlyCost  int64         `json:"monthly_cost"`
+	DailyTurns   int           `json:"daily_turns"`
+	MonthlyTurns int           `json:"monthly_turns"`
+	UpdatedAt    time.Time     `json:"updated_at"`
+}
+
+// SetBudget stores the budget, replacing any earlier one for its subject.
+func (d *Database) SetBudget(tx *sql.Tx, budget Budget) error {
+	_, err := tx.Exec(`INSERT INTO budgets (subject, subject_id, daily_cost, monthly_cost, daily_turns, monthly_turns, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (subject, subject_id) DO UPDATE SET daily_cost = excluded.daily_cost, monthly_cost = excluded.monthly_cost,
+			daily_turns = excluded.daily_turns, monthly_turns = excluded.monthly_turns, updated_at = excluded.updated_at`,
+		budget.Subject, budget.SubjectID, budget.DailyCost, budget.MonthlyCost, budget.DailyTurns, budget.MonthlyTurns, time.Now().UTC())
+
+	return err
+}
+
+// GetBudget returns the budget set for the subject, or sql.ErrNoRows when
+// the server's defaults apply.
+func (d *Database) GetBudget(subject BudgetSubject, subjectID string) (*Budget, error) {
+	var budget Budget
+	var updatedAt sql.NullTime
+
+	err := d.conn.QueryRow("SELECT subject, subject_id, daily_cost, monthly_cost, daily_turns, monthly_turns, updated_at FROM budgets WHERE subject = ? AND subject_id = ?", subject, subjectID).
+		Scan(&budget.Subject, &budget.SubjectID, &budget.DailyCost, &budget.MonthlyCost, &budget.DailyTurns, &budget.MonthlyTurns, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	budget.UpdatedAt = updatedAt.Time
+
+	return &budget, nil
+}
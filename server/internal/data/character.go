@@ -0,0 +1,63 @@
+package data
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+type Character struct {
+	ID         string `json:"id"`
+	ChatUserID string `json:"chat_user_id"`
+	Position   int    `json:"position"`
+	Name       string `json:"name"`
+	Role       string `json:"role"`
+	Voice      string `json:"voice"`
+}
+
+func (d *Database) CreateCharacters(tx *sql.Tx, chatUserID string, characters []Character) ([]Character, error) {
+	if len(characters) == 0 {
+		return nil, nil
+	}
+
+	query := "INSERT INTO characters (id, chat_user_id, position, name, role, voice) VALUES "
+	var values []interface{}
+	placeholders := make([]string, len(characters))
+
+	for i := range characters {
+		characters[i].ID = uuid.New().String()
+		characters[i].ChatUserID = chatUserID
+		characters[i].Position = i + 1
+
+		placeholders[i] = "(?, ?, ?, ?, ?, ?)"
+
+		values = append(values, characters[i].ID, characters[i].ChatUserID, characters[i].Position, characters[i].Name, characters[i].Role, characters[i].Voice)
+	}
+
+	query += strings.Join(placeholders, ",")
+
+	if _, err := tx.Exec(query, values...); err != nil {
+		return nil, err
+	}
+
+	return characters, nil
+}
+
+func (d *Database) GetCharactersByChatUserID(chatUserID string) ([]Character, error) {
+	rows, err := d.conn.Query("SELECT id, chat_user_id, position, name, role, voice FROM characters WHERE chat_user_id = ? ORDER BY position", chatUserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var characters []Character
+	for rows.Next() {
+		var character Character
+		if err := rows.Scan(&character.ID, &character.ChatUserID, &character.Position, &character.Name, &character.Role, &character.Voice); err != nil {
+			return nil, err
+		}
+		characters = append(characters, character)
+	}
+	return characters, rows.Err()
+}
@@ -3,6 +3,7 @@ package data
 import (
 	"database/sql"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -13,35 +14,50 @@ type Chat struct {
 }
 
 type Entry struct {
-	ID         string `json:"id"`
-	ChatUserID string `json:"chat_user_id"`
-	Role       string `json:"role"`
-	Text       string `json:"text"`
-	Audio      string `json:"audio"`
+	ID               string `json:"id"`
+	ChatUserID       string `json:"chat_user_id"`
+	Role             string `json:"role"`
+	Text             string `json:"text"`
+	Language         string `json:"language"`
+	Audio            string `json:"audio"`
+	Subtitle         string `json:"subtitle"`
+	SubtitleLanguage string `json:"subtitle_language"`
+	Transliteration  string `json:"transliteration"`
+	CharacterID      string `json:"character_id"`
+	Position         int    `json:"position"`
 }
 
-func (d *Database) CreateChat(tx *sql.Tx, chatUserID, role, text, audio string) (*Entry, error) {
-	id := uuid.New().String()
-	_, err := tx.Exec("INSERT INTO chats (id, chat_user_id, role, text, audio) VALUES (?, ?, ?, ?, ?)",
-		id, chatUserID, role, text, audio)
+const entryColumns = "id, chat_user_id, role, text, language, audio, subtitle, subtitle_language, transliteration, character_id, position"
+
+func (d *Database) CreateChat(tx *sql.Tx, chatUserID string, chat Entry) (*Entry, error) {
+	chats, err := d.CreateChats(tx, chatUserID, []Entry{chat})
 	if err != nil {
 		return nil, err
 	}
-	return &Entry{ID: id, ChatUserID: chatUserID, Role: role, Text: text, Audio: audio}, nil
+	return &chats[0], nil
 }
 
+// CreateChats appends the entries to the conversation in the given order.
+// Positions keep increasing past soft-deleted entries so they are never reused.
 func (d *Database) CreateChats(tx *sql.Tx, chatUserID string, chats []Entry) ([]Entry, error) {
-	query := "INSERT INTO chats (id, chat_user_id, role, text, audio) VALUES "
+	var lastPosition int
+	if err := tx.QueryRow("SELECT COALESCE(MAX(position), 0) FROM chats WHERE chat_user_id = ?", chatUserID).Scan(&lastPosition); err != nil {
+		return nil, err
+	}
+
+	query := "INSERT INTO chats (" + entryColumns + ", created_at) VALUES "
 	var values []interface{}
 	placeholders := make([]string, len(chats))
+	now := time.Now().UTC()
 
-	for i, chat := range chats {
-		chat.ID = uuid.New().String()
-		chat.ChatUserID = chatUserID
+	for i := range chats {
+		chats[i].ID = uuid.New().String()
+		chats[i].ChatUserID = chatUserID
+		chats[i].Position = lastPosition + i + 1
 
-		placeholders[i] = "(?, ?, ?, ?, ?)"
+		placeholders[i] = "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
 
-		values = append(values, chat.ID, chat.ChatUserID, chat.Role, chat.Text, chat.Audio)
+		values = append(values, chats[i].ID, chats[i].ChatUserID, chats[i].Role, chats[i].Text, chats[i].Language, chats[i].Audio, chats[i].Subtitle, chats[i].SubtitleLanguage, chats[i].Transliteration, chats[i].CharacterID, chats[i].Position, now)
 	}
 
 	query += strings.Join(placeholders, ",")
@@ -54,8 +70,10 @@ func (d *Database) CreateChats(tx *sql.Tx, chatUserID string, chats []Entry) ([]
 	return chats, nil
 }
 
+// GetChatsByChatUserID returns the conversation's entries in turn order,
+// leaving out the ones that were undone or regenerated.
 func (d *Database) GetChatsByChatUserID(chatUserID string) ([]Entry, error) {
-	rows, err := d.conn.Query("SELECT id, chat_user_id, role, text, audio FROM chats WHERE chat_user_id = ?", chatUserID)
+	rows, err := d.conn.Query("SELECT "+entryColumns+" FROM chats WHERE chat_user_id = ? AND deleted_at IS NULL ORDER BY position", chatUserID)
 	if err != nil {
 		return nil, err
 	}
@@ -63,12 +81,46 @@ func (d *Database) GetChatsByChatUserID(chatUserID string) ([]Entry, error) {
 
 	var chats []Entry
 	for rows.Next() {
-		var chat Entry
-		err := rows.Scan(&chat.ID, &chat.ChatUserID, &chat.Role, &chat.Text, &chat.Audio)
+		chat, err := scanEntry(rows)
 		if err != nil {
 			return nil, err
 		}
-		chats = append(chats, chat)
+		chats = append(chats, *chat)
 	}
 	return chats, nil
 }
+
+// GetChat returns a single entry of the conversation, or sql.ErrNoRows when it
+// does not exist or was undone.
+func (d *Database) GetChat(chatUserID, id string) (*Entry, error) {
+	return scanEntry(d.conn.QueryRow("SELECT "+entryColumns+" FROM chats WHERE chat_user_id = ? AND id = ? AND deleted_at IS NULL", chatUserID, id))
+}
+
+// UpdateChatSubtitles replaces the subtitles of the given entries.
+func (d *Database) UpdateChatSubtitles(tx *sql.Tx, chats []Entry) error {
+	for _, chat := range chats {
+		if _, err := tx.Exec("UPDATE chats SET subtitle = ?, subtitle_language = ? WHERE id = ?", chat.Subtitle, chat.SubtitleLanguage, chat.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanEntry(s scanner) (*Entry, error) {
+	var chat Entry
+	if err := s.Scan(&chat.ID, &chat.ChatUserID, &chat.Role, &chat.Text, &chat.Language, &chat.Audio, &chat.Subtitle, &chat.SubtitleLanguage, &chat.Transliteration, &chat.CharacterID, &chat.Position); err != nil {
+		return nil, err
+	}
+	return &chat, nil
+}
+
+// DeleteChats soft-deletes the given entries so they no longer show up in the conversation.
+func (d *Database) DeleteChats(tx *sql.Tx, ids []string) error {
+	now := time.Now().UTC()
+	for _, id := range ids {
+		if _, err := tx.Exec("UPDATE chats SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL", now, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
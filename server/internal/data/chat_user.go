@@ -2,33 +2,465 @@ package data
 
 import (
 	"database/sql"
+	"errors"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+type Status string
+
+const (
+	StatusActive    Status = "active"
+	StatusEnding    Status = "ending"
+	StatusEnded     Status = "ended"
+	StatusAbandoned Status = "abandoned"
+)
+
+// ErrInvalidTransition is returned when a conversation cannot move to the
+// requested status, either because the transition is not allowed or because
+// another request changed the status first.
+var ErrInvalidTransition = errors.New("invalid status transition")
+
+// transitions lists the statuses a conversation may move to from each status.
+// Ended and abandoned conversations are final.
+var transitions = map[Status][]Status{
+	StatusActive: {StatusEnding, StatusEnded, StatusAbandoned},
+	StatusEnding: {StatusEnded, StatusActive},
+}
+
+func (s Status) IsValid() bool {
+	return s == StatusActive || s == StatusEnding || s == StatusEnded || s == StatusAbandoned
+}
+
+func CanTransition(from, to Status) bool {
+	for _, allowed := range transitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
 type ChatUser struct {
-	ID               string `json:"id"`
-	Secret           string `json:"secret"`
-	Language         string `json:"language"`
-	SubtitleLanguage string `json:"subtitle_language"`
-	Voice            string `json:"voice"`
+	ID               string    `json:"id"`
+	Secret           string    `json:"secret"`
+	AccountID        string    `json:"account_id"`
+	Language         string    `json:"language"`
+	SubtitleLanguage string    `json:"subtitle_language"`
+	Voice            string    `json:"voice"`
+	ParentID         string    `json:"parent_id"`
+	ForkEntryID      string    `json:"fork_entry_id"`
+	CreatedAt        time.Time `json:"created_at"`
+	Status           Status    `json:"status"`
+	StatusUpdatedAt  time.Time `json:"status_updated_at"`
+	EndedAt          time.Time `json:"ended_at"`
+	ClassroomID      string    `json:"classroom_id"`
+	MaxTurns         int       `json:"max_turns"`
+	MaxMinutes       int       `json:"max_minutes"`
+	MaxAudioSeconds  int       `json:"max_audio_seconds"`
+
+	// Transliteration asks for a romanization of every turn, for languages
+	// not written in the Latin script
+	Transliteration bool `json:"transliteration"`
+
+	// Summary condenses every entry up to and including SummaryPosition
+	Summary         string `json:"summary"`
+	SummaryPosition int    `json:"summary_position"`
+}
+
+const chatUserColumns = "id, secret, account_id, language, subtitle_language, voice, parent_id, fork_entry_id, created_at, status, status_updated_at, ended_at, max_turns, max_minutes, max_audio_seconds, summary, summary_position, transliteration, classroom_id"
+
+// CreateChatUser stores a new active conversation with the settings in user.
+// The ID, creation time and status are filled in.
+func (d *Database) CreateChatUser(tx *sql.Tx, user ChatUser) (*ChatUser, error) {
+	return d.insertChatUser(tx, user)
+}
+
+// ForkChatUser creates a conversation that branches off parent at the given
+// entry, inheriting the parent's language settings and voice.
+func (d *Database) ForkChatUser(tx *sql.Tx, parent *ChatUser, secret, forkEntryID string) (*ChatUser, error) {
+	return d.insertChatUser(tx, ChatUser{
+		Secret:           secret,
+		AccountID:        parent.AccountID,
+		Language:         parent.Language,
+		SubtitleLanguage: parent.SubtitleLanguage,
+		Voice:            parent.Voice,
+		ParentID:         parent.ID,
+		ForkEntryID:      forkEntryID,
+		ClassroomID:      parent.ClassroomID,
+		MaxTurns:         parent.MaxTurns,
+		MaxMinutes:       parent.MaxMinutes,
+		MaxAudioSeconds:  parent.MaxAudioSeconds,
+		Transliteration:  parent.Transliteration,
+	})
 }
 
-func (d *Database) CreateChatUser(tx *sql.Tx, secret, language, subtitleLanguage, voice string) (*ChatUser, error) {
-	id := uuid.New().String()
-	_, err := tx.Exec("INSERT INTO chat_users (id, secret, language, subtitle_language, voice) VALUES (?, ?, ?, ?, ?)", id, secret, language, subtitleLanguage, voice)
+func (d *Database) insertChatUser(tx *sql.Tx, user ChatUser) (*ChatUser, error) {
+	user.ID = uuid.New().String()
+	user.CreatedAt = time.Now().UTC()
+	user.Status = StatusActive
+	user.StatusUpdatedAt = user.CreatedAt
+
+	_, err := tx.Exec("INSERT INTO chat_users (id, secret, account_id, language, subtitle_language, voice, parent_id, fork_entry_id, created_at, status, status_updated_at, max_turns, max_minutes, max_audio_seconds, transliteration, classroom_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		user.ID, user.Secret, user.AccountID, user.Language, user.SubtitleLanguage, user.Voice, user.ParentID, user.ForkEntryID, user.CreatedAt, user.Status, user.StatusUpdatedAt, user.MaxTurns, user.MaxMinutes, user.MaxAudioSeconds, user.Transliteration, user.ClassroomID)
 	if err != nil {
 		return nil, err
 	}
 
-	return &ChatUser{ID: id, Secret: secret, Language: language, SubtitleLanguage: subtitleLanguage, Voice: voice}, nil
+	return &user, nil
 }
 
 func (d *Database) GetChatUser(id string) (*ChatUser, error) {
+	return scanChatUser(d.conn.QueryRow("SELECT "+chatUserColumns+" FROM chat_users WHERE id = ?", id))
+}
+
+// GetChatUserTree returns the conversation with the given ID and every
+// conversation forked from it, directly or indirectly, oldest first.
+func (d *Database) GetChatUserTree(rootID string) ([]ChatUser, error) {
+	rows, err := d.conn.Query(`WITH RECURSIVE tree(id) AS (
+		SELECT id FROM chat_users WHERE id = ?
+		UNION ALL
+		SELECT chat_users.id FROM chat_users JOIN tree ON chat_users.parent_id = tree.id
+	)
+	SELECT `+chatUserColumns+` FROM chat_users WHERE id IN (SELECT id FROM tree) ORDER BY created_at`, rootID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []ChatUser
+	for rows.Next() {
+		user, err := scanChatUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	return users, rows.Err()
+}
+
+// GetChatUsersByAccountID returns one page of the account's conversations,
+// newest first, together with the total number of conversations it owns.
+func (d *Database) GetChatUsersByAccountID(accountID string, limit, offset int) ([]ChatUser, int, error) {
+	return d.getChatUsers("account_id = ?", []any{accountID}, limit, offset)
+}
+
+func (d *Database) getChatUsers(where string, args []any, limit, offset int) ([]ChatUser, int, error) {
+	var total int
+	if err := d.conn.QueryRow("SELECT COUNT(*) FROM chat_users WHERE "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := d.conn.Query("SELECT "+chatUserColumns+" FROM chat_users WHERE "+where+" ORDER BY created_at DESC, id LIMIT ? OFFSET ?", append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var users []ChatUser
+	for rows.Next() {
+		user, err := scanChatUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, *user)
+	}
+	return users, total, rows.Err()
+}
+
+// ChatUserFilter narrows down a search of conversations. Empty fields match
+// every conversation.
+type ChatUserFilter struct {
+	ID        string
+	AccountID string
+	Username  string
+	Language  string
+	Status    Status
+
+	// Text matches a conversation by its ID, or by a piece of the text of any
+	// of its entries, including the system prompt
+	Text string
+
+	// Since and Until bound the creation time, Until exclusive
+	Since time.Time
+	Until time.Time
+}
+
+func (f ChatUserFilter) where() (string, []any) {
+	conditions := []string{"1 = 1"}
+	var args []any
+
+	if f.ID != "" {
+		conditions = append(conditions, "id = ?")
+		args = append(args, f.ID)
+	}
+	if f.AccountID != "" {
+		conditions = append(conditions, "account_id = ?")
+		args = append(args, f.AccountID)
+	}
+	if f.Username != "" {
+		conditions = append(conditions, "account_id IN (SELECT id FROM accounts WHERE username = ?)")
+		args = append(args, f.Username)
+	}
+	if f.Language != "" {
+		conditions = append(conditions, "language = ?")
+		args = append(args, f.Language)
+	}
+	if f.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, f.Status)
+	}
+	if f.Text != "" {
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(f.Text) + "%"
+		conditions = append(conditions, `(id = ? OR EXISTS (SELECT 1 FROM chats WHERE chats.chat_user_id = chat_users.id AND chats.deleted_at IS NULL AND chats.text LIKE ? ESCAPE '\'))`)
+		args = append(args, f.Text, pattern)
+	}
+	if !f.Since.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, f.Until.UTC())
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// ChatUserOverview is a conversation together with the username of the
+// account owning it, if any, and the number of turns the learner took.
+type ChatUserOverview struct {
+	ChatUser
+	Username string `json:"username"`
+	Turns    int    `json:"turns"`
+}
+
+// SearchChatUsers returns one page of the conversations matching the filter,
+// newest first, together with the total number of matches. It is meant for
+// admins and does not check who owns the conversations.
+func (d *Database) SearchChatUsers(filter ChatUserFilter, limit, offset int) ([]ChatUserOverview, int, error) {
+	where, args := filter.where()
+
+	var total int
+	if err := d.conn.QueryRow("SELECT COUNT(*) FROM chat_users WHERE "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := d.conn.Query("SELECT "+chatUserColumns+`,
+			COALESCE((SELECT username FROM accounts WHERE accounts.id = chat_users.account_id), ''),
+			(SELECT COUNT(*) FROM chats WHERE chats.chat_user_id = chat_users.id AND chats.role = 'user' AND chats.deleted_at IS NULL)
+		FROM chat_users WHERE `+where+" ORDER BY created_at DESC, id LIMIT ? OFFSET ?", append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var overviews []ChatUserOverview
+	for rows.Next() {
+		var overview ChatUserOverview
+
+		user, err := scanChatUser(trailingScanner{row: rows, extra: []any{&overview.Username, &overview.Turns}})
+		if err != nil {
+			return nil, 0, err
+		}
+
+		overview.ChatUser = *user
+		overviews = append(overviews, overview)
+	}
+	return overviews, total, rows.Err()
+}
+
+// GetChatUserOverview returns a single conversation as SearchChatUsers lists
+// it, or sql.ErrNoRows when it does not exist.
+func (d *Database) GetChatUserOverview(id string) (*ChatUserOverview, error) {
+	overviews, _, err := d.SearchChatUsers(ChatUserFilter{ID: id}, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(overviews) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return &overviews[0], nil
+}
+
+// DeleteChatUser removes the conversation and everything stored for it: its
+// entries with their translations and glossaries, objectives, characters and
+// sessions. Conversations forked from it keep their own copy of the history
+// and become conversations of their own. It returns sql.ErrNoRows when the
+// conversation does not exist.
+func (d *Database) DeleteChatUser(tx *sql.Tx, id string) error {
+	statements := []string{
+		"DELETE FROM translations WHERE chat_id IN (SELECT id FROM chats WHERE chat_user_id = ?)",
+		"DELETE FROM glossaries WHERE chat_id IN (SELECT id FROM chats WHERE chat_user_id = ?)",
+		"DELETE FROM chats WHERE chat_user_id = ?",
+		"DELETE FROM objectives WHERE chat_user_id = ?",
+		"DELETE FROM characters WHERE chat_user_id = ?",
+		"DELETE FROM sessions WHERE subject_id = ?",
+		"UPDATE chat_users SET parent_id = '', fork_entry_id = '' WHERE parent_id = ?",
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, id); err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.Exec("DELETE FROM chat_users WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// trailingScanner scans the columns following those of a row's usual scan
+// into extra.
+type trailingScanner struct {
+	row   scanner
+	extra []any
+}
+
+func (s trailingScanner) Scan(dest ...any) error {
+	return s.row.Scan(append(dest, s.extra...)...)
+}
+
+func scanChatUser(row scanner) (*ChatUser, error) {
 	var user ChatUser
-	err := d.conn.QueryRow("SELECT id, secret, language, subtitle_language, voice FROM chat_users WHERE id = ?", id).Scan(&user.ID, &user.Secret, &user.Language, &user.SubtitleLanguage, &user.Voice)
+	var createdAt, statusUpdatedAt, endedAt sql.NullTime
+	err := row.Scan(&user.ID, &user.Secret, &user.AccountID, &user.Language, &user.SubtitleLanguage, &user.Voice, &user.ParentID, &user.ForkEntryID, &createdAt, &user.Status, &statusUpdatedAt, &endedAt, &user.MaxTurns, &user.MaxMinutes, &user.MaxAudioSeconds, &user.Summary, &user.SummaryPosition, &user.Transliteration, &user.ClassroomID)
 	if err != nil {
 		return nil, err
 	}
+	user.CreatedAt = createdAt.Time
+	user.StatusUpdatedAt = statusUpdatedAt.Time
+	user.EndedAt = endedAt.Time
 	return &user, nil
 }
+
+// UpdateChatUserSummary stores the running summary of the conversation, which
+// covers every entry up to and including the given position.
+func (d *Database) UpdateChatUserSummary(tx *sql.Tx, id, summary string, position int) error {
+	_, err := tx.Exec("UPDATE chat_users SET summary = ?, summary_position = ? WHERE id = ?", summary, position, id)
+	return err
+}
+
+// UpdateChatUserSubtitleLanguage changes the language new subtitles are
+// generated in; an empty language turns subtitles off.
+func (d *Database) UpdateChatUserSubtitleLanguage(tx *sql.Tx, id, language string) error {
+	_, err := tx.Exec("UPDATE chat_users SET subtitle_language = ? WHERE id = ?", language, id)
+	return err
+}
+
+// GetChatUserStatus reads the conversation's status within the transaction,
+// so that a write can be checked against the latest status before committing.
+func (d *Database) GetChatUserStatus(tx *sql.Tx, id string) (Status, error) {
+	var status Status
+	err := tx.QueryRow("SELECT status FROM chat_users WHERE id = ?", id).Scan(&status)
+	return status, err
+}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// UpdateChatUserStatus moves the conversation from one status to another
+// within the transaction. It fails with ErrInvalidTransition when the move is
+// not allowed or the conversation is no longer in the from status.
+func (d *Database) UpdateChatUserStatus(tx *sql.Tx, id string, from, to Status) error {
+	return updateChatUserStatus(tx, id, from, to)
+}
+
+// SetChatUserStatus is UpdateChatUserStatus outside of a transaction, used to
+// claim a status before a slow operation such as generating the farewell.
+func (d *Database) SetChatUserStatus(id string, from, to Status) error {
+	return updateChatUserStatus(d.conn, id, from, to)
+}
+
+func updateChatUserStatus(conn execer, id string, from, to Status) error {
+	if !CanTransition(from, to) {
+		return ErrInvalidTransition
+	}
+
+	now := time.Now().UTC()
+
+	var endedAt any
+	if to == StatusEnded || to == StatusAbandoned {
+		endedAt = now
+	}
+
+	result, err := conn.Exec("UPDATE chat_users SET status = ?, status_updated_at = ?, ended_at = ? WHERE id = ? AND status = ?", to, now, endedAt, id, from)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrInvalidTransition
+	}
+
+	return nil
+}
+
+// AbandonIdleChatUsers marks active conversations without any new entry since
+// the given time as abandoned, and returns how many were marked.
+func (d *Database) AbandonIdleChatUsers(idleSince time.Time) (int64, error) {
+	now := time.Now().UTC()
+
+	result, err := d.conn.Exec(`UPDATE chat_users SET status = ?, status_updated_at = ?, ended_at = ?
+		WHERE status = ? AND COALESCE((SELECT MAX(created_at) FROM chats WHERE chats.chat_user_id = chat_users.id), created_at) < ?`,
+		StatusAbandoned, now, now, StatusActive, idleSince.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// EndExpiredChatUsers marks active conversations that have run past their
+// time limit by the given time as ended, and returns how many were marked.
+func (d *Database) EndExpiredChatUsers(now time.Time) (int64, error) {
+	now = now.UTC()
+
+	// Only the seconds of the stored time are compared, which SQLite can read
+	result, err := d.conn.Exec(`UPDATE chat_users SET status = ?, status_updated_at = ?, ended_at = ?
+		WHERE status = ? AND max_minutes > 0 AND datetime(substr(created_at, 1, 19), '+' || max_minutes || ' minutes') <= ?`,
+		StatusEnded, now, now, StatusActive, now.Format(time.DateTime))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// ReleaseEndingChatUsers puts conversations that have been ending since
+// before the given time back to active, so that a farewell request which
+// never finished does not leave them stuck, and returns how many were moved.
+func (d *Database) ReleaseEndingChatUsers(endingSince time.Time) (int64, error) {
+	result, err := d.conn.Exec("UPDATE chat_users SET status = ?, status_updated_at = ? WHERE status = ? AND status_updated_at < ?",
+		StatusActive, time.Now().UTC(), StatusEnding, endingSince.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
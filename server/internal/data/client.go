@@ -2,6 +2,7 @@ package data
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
 
 	_ "modernc.org/sqlite"
@@ -23,6 +24,15 @@ func New(dbPath string) *Database {
 	return &Database{conn: db}
 }
 
+type column struct {
+	table      string
+	name       string
+	definition string
+
+	// backfill runs once, right after the column is added to an existing table
+	backfill string
+}
+
 func migrate(db *sql.DB) {
 	chatUserTable := `CREATE TABLE IF NOT EXISTS chat_users (
 		id VARCHAR PRIMARY KEY,
@@ -41,20 +51,238 @@ func migrate(db *sql.DB) {
 		FOREIGN KEY(chat_user_id) REFERENCES chat_users(id)
 	);`
 
+	objectiveTable := `CREATE TABLE IF NOT EXISTS objectives (
+		id VARCHAR PRIMARY KEY,
+		chat_user_id VARCHAR,
+		position INTEGER NOT NULL,
+		description VARCHAR NOT NULL,
+		completed_by VARCHAR NOT NULL DEFAULT '',
+		completed_at DATETIME,
+		FOREIGN KEY(chat_user_id) REFERENCES chat_users(id)
+	);`
+
+	characterTable := `CREATE TABLE IF NOT EXISTS characters (
+		id VARCHAR PRIMARY KEY,
+		chat_user_id VARCHAR,
+		position INTEGER NOT NULL,
+		name VARCHAR NOT NULL,
+		role VARCHAR NOT NULL,
+		voice VARCHAR NOT NULL,
+		FOREIGN KEY(chat_user_id) REFERENCES chat_users(id)
+	);`
+
+	translationTable := `CREATE TABLE IF NOT EXISTS translations (
+		id VARCHAR PRIMARY KEY,
+		chat_id VARCHAR NOT NULL,
+		language VARCHAR NOT NULL,
+		text VARCHAR NOT NULL,
+		created_at DATETIME,
+		UNIQUE(chat_id, language),
+		FOREIGN KEY(chat_id) REFERENCES chats(id)
+	);`
+
+	glossaryTable := `CREATE TABLE IF NOT EXISTS glossaries (
+		id VARCHAR PRIMARY KEY,
+		chat_id VARCHAR NOT NULL,
+		language VARCHAR NOT NULL,
+		tokens VARCHAR NOT NULL,
+		created_at DATETIME,
+		UNIQUE(chat_id, language),
+		FOREIGN KEY(chat_id) REFERENCES chats(id)
+	);`
+
+	accountTable := `CREATE TABLE IF NOT EXISTS accounts (
+		id VARCHAR PRIMARY KEY,
+		username VARCHAR NOT NULL UNIQUE COLLATE NOCASE,
+		password VARCHAR NOT NULL,
+		created_at DATETIME
+	);`
+
+	sessionTable := `CREATE TABLE IF NOT EXISTS sessions (
+		id VARCHAR PRIMARY KEY,
+		kind VARCHAR NOT NULL,
+		subject_id VARCHAR NOT NULL,
+		refresh_hash VARCHAR NOT NULL,
+		created_at DATETIME,
+		expires_at DATETIME,
+		revoked_at DATETIME
+	);`
+
+	apiKeyTable := `CREATE TABLE IF NOT EXISTS api_keys (
+		id VARCHAR PRIMARY KEY,
+		account_id VARCHAR NOT NULL,
+		name VARCHAR NOT NULL,
+		prefix VARCHAR NOT NULL,
+		key_hash VARCHAR NOT NULL UNIQUE,
+		scopes VARCHAR NOT NULL,
+		created_at DATETIME,
+		last_used_at DATETIME,
+		revoked_at DATETIME
+	);`
+
+	oidcLoginTable := `CREATE TABLE IF NOT EXISTS oidc_logins (
+		state VARCHAR PRIMARY KEY,
+		verifier VARCHAR NOT NULL,
+		nonce VARCHAR NOT NULL,
+		created_at DATETIME
+	);`
+
+	accountIdentityTable := `CREATE TABLE IF NOT EXISTS account_identities (
+		issuer VARCHAR NOT NULL,
+		subject VARCHAR NOT NULL,
+		account_id VARCHAR NOT NULL,
+		created_at DATETIME,
+		PRIMARY KEY (issuer, subject)
+	);`
+
+	organizationTable := `CREATE TABLE IF NOT EXISTS organizations (
+		id VARCHAR PRIMARY KEY,
+		name VARCHAR NOT NULL,
+		owner_id VARCHAR NOT NULL,
+		created_at DATETIME
+	);`
+
+	organizationMemberTable := `CREATE TABLE IF NOT EXISTS organization_members (
+		organization_id VARCHAR NOT NULL,
+		account_id VARCHAR NOT NULL,
+		role VARCHAR NOT NULL,
+		created_at DATETIME,
+		PRIMARY KEY (organization_id, account_id),
+		FOREIGN KEY(organization_id) REFERENCES organizations(id)
+	);`
+
+	organizationInviteTable := `CREATE TABLE IF NOT EXISTS organization_invites (
+		organization_id VARCHAR NOT NULL,
+		account_id VARCHAR NOT NULL,
+		role VARCHAR NOT NULL,
+		invited_by VARCHAR NOT NULL,
+		created_at DATETIME,
+		PRIMARY KEY (organization_id, account_id),
+		FOREIGN KEY(organization_id) REFERENCES organizations(id)
+	);`
+
+	classroomTable := `CREATE TABLE IF NOT EXISTS classrooms (
+		id VARCHAR PRIMARY KEY,
+		organization_id VARCHAR NOT NULL,
+		name VARCHAR NOT NULL,
+		created_at DATETIME,
+		FOREIGN KEY(organization_id) REFERENCES organizations(id)
+	);`
+
+	classroomMemberTable := `CREATE TABLE IF NOT EXISTS classroom_members (
+		classroom_id VARCHAR NOT NULL,
+		account_id VARCHAR NOT NULL,
+		role VARCHAR NOT NULL,
+		created_at DATETIME,
+		PRIMARY KEY (classroom_id, account_id),
+		FOREIGN KEY(classroom_id) REFERENCES classrooms(id)
+	);`
+
+	usageTable := `CREATE TABLE IF NOT EXISTS usage_records (
+		id VARCHAR PRIMARY KEY,
+		account_id VARCHAR NOT NULL DEFAULT '',
+		operation VARCHAR NOT NULL,
+		model VARCHAR NOT NULL DEFAULT '',
+		input_tokens INTEGER NOT NULL DEFAULT 0,
+		output_tokens INTEGER NOT NULL DEFAULT 0,
+		audio_seconds REAL NOT NULL DEFAULT 0,
+		characters INTEGER NOT NULL DEFAULT 0,
+		cost INTEGER NOT NULL DEFAULT 0,
+		turns INTEGER NOT NULL DEFAULT 0,
+		own_key BOOLEAN NOT NULL DEFAULT 0,
+		created_at DATETIME
+	);`
+
+	budgetTable := `CREATE TABLE IF NOT EXISTS budgets (
+		subject VARCHAR NOT NULL,
+		subject_id VARCHAR NOT NULL,
+		daily_cost INTEGER NOT NULL DEFAULT 0,
+		monthly_cost INTEGER NOT NULL DEFAULT 0,
+		daily_turns INTEGER NOT NULL DEFAULT 0,
+		monthly_turns INTEGER NOT NULL DEFAULT 0,
+		updated_at DATETIME,
+		PRIMARY KEY (subject, subject_id)
+	);`
+
+	providerKeyTable := `CREATE TABLE IF NOT EXISTS provider_keys (
+		account_id VARCHAR PRIMARY KEY,
+		master_key_id VARCHAR NOT NULL,
+		sealed_key BLOB NOT NULL,
+		hint VARCHAR NOT NULL DEFAULT '',
+		created_at DATETIME,
+		updated_at DATETIME,
+		FOREIGN KEY(account_id) REFERENCES accounts(id)
+	);`
+
+	requestOutcomeTable := `CREATE TABLE IF NOT EXISTS request_outcomes (
+		day VARCHAR NOT NULL,
+		route VARCHAR NOT NULL,
+		requests INTEGER NOT NULL DEFAULT 0,
+		client_errors INTEGER NOT NULL DEFAULT 0,
+		server_errors INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (day, route)
+	);`
+
+	// Columns added after a table was first released are listed here so that
+	// existing databases pick them up as well.
+	columns := []column{
+		{table: "chats", name: "character_id", definition: "VARCHAR NOT NULL DEFAULT ''"},
+		{table: "chats", name: "position", definition: "INTEGER NOT NULL DEFAULT 0", backfill: "UPDATE chats SET position = rowid"},
+		{table: "chats", name: "created_at", definition: "DATETIME"},
+		{table: "chats", name: "deleted_at", definition: "DATETIME"},
+		{table: "chats", name: "subtitle", definition: "VARCHAR NOT NULL DEFAULT ''"},
+		{table: "chats", name: "subtitle_language", definition: "VARCHAR NOT NULL DEFAULT ''", backfill: "UPDATE chats SET subtitle_language = (SELECT subtitle_language FROM chat_users WHERE chat_users.id = chats.chat_user_id) WHERE subtitle != ''"},
+		{table: "chats", name: "transliteration", definition: "VARCHAR NOT NULL DEFAULT ''"},
+		{table: "chats", name: "language", definition: "VARCHAR NOT NULL DEFAULT ''", backfill: "UPDATE chats SET language = (SELECT language FROM chat_users WHERE chat_users.id = chats.chat_user_id) WHERE role != 'system'"},
+		{table: "chat_users", name: "parent_id", definition: "VARCHAR NOT NULL DEFAULT ''"},
+		{table: "chat_users", name: "fork_entry_id", definition: "VARCHAR NOT NULL DEFAULT ''"},
+		{table: "chat_users", name: "created_at", definition: "DATETIME"},
+		{table: "chat_users", name: "status", definition: "VARCHAR NOT NULL DEFAULT 'active'"},
+		{table: "chat_users", name: "status_updated_at", definition: "DATETIME"},
+		{table: "chat_users", name: "ended_at", definition: "DATETIME"},
+		{table: "chat_users", name: "max_turns", definition: "INTEGER NOT NULL DEFAULT 0"},
+		{table: "chat_users", name: "max_minutes", definition: "INTEGER NOT NULL DEFAULT 0"},
+		{table: "chat_users", name: "max_audio_seconds", definition: "INTEGER NOT NULL DEFAULT 0"},
+		{table: "chat_users", name: "summary", definition: "VARCHAR NOT NULL DEFAULT ''"},
+		{table: "chat_users", name: "summary_position", definition: "INTEGER NOT NULL DEFAULT 0"},
+		{table: "chat_users", name: "transliteration", definition: "BOOLEAN NOT NULL DEFAULT 0"},
+		{table: "chat_users", name: "account_id", definition: "VARCHAR NOT NULL DEFAULT ''"},
+		{table: "chat_users", name: "classroom_id", definition: "VARCHAR NOT NULL DEFAULT ''"},
+	}
+
+	indexes := []string{
+		"CREATE INDEX IF NOT EXISTS chat_users_account_id ON chat_users(account_id, created_at)",
+		"CREATE INDEX IF NOT EXISTS api_keys_account_id ON api_keys(account_id, created_at)",
+		"CREATE INDEX IF NOT EXISTS organization_members_account_id ON organization_members(account_id)",
+		"CREATE INDEX IF NOT EXISTS classroom_members_account_id ON classroom_members(account_id, role)",
+		"CREATE INDEX IF NOT EXISTS usage_records_account_id ON usage_records(account_id, created_at)",
+		"CREATE INDEX IF NOT EXISTS chat_users_created_at ON chat_users(created_at)",
+		"CREATE INDEX IF NOT EXISTS chat_users_classroom_id ON chat_users(classroom_id, account_id, created_at)",
+		"CREATE INDEX IF NOT EXISTS organization_invites_account_id ON organization_invites(account_id)",
+	}
+
 	tx, err := db.Begin()
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer tx.Rollback()
 
-	_, err = tx.Exec(chatUserTable)
-	if err != nil {
-		log.Fatal(err)
+	for _, table := range []string{chatUserTable, chatTable, objectiveTable, characterTable, translationTable, glossaryTable, accountTable, sessionTable, apiKeyTable, oidcLoginTable, accountIdentityTable, organizationTable, organizationMemberTable, organizationInviteTable, classroomTable, classroomMemberTable, usageTable, budgetTable, providerKeyTable, requestOutcomeTable} {
+		if _, err := tx.Exec(table); err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	_, err = tx.Exec(chatTable)
-	if err != nil {
-		log.Fatal(err)
+	for _, c := range columns {
+		if err := addColumn(tx, c); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	for _, index := range indexes {
+		if _, err := tx.Exec(index); err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -62,6 +290,28 @@ func migrate(db *sql.DB) {
 	}
 }
 
+func addColumn(tx *sql.Tx, c column) error {
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", c.table, c.name).Scan(&count); err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return nil
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.name, c.definition)); err != nil {
+		return err
+	}
+
+	if c.backfill == "" {
+		return nil
+	}
+
+	_, err := tx.Exec(c.backfill)
+	return err
+}
+
 func (d *Database) BeginTx() (*sql.Tx, error) {
 	return d.conn.Begin()
 }
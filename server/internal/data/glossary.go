@@ -0,0 +1,45 @@
+package data
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Glossary is a cached word-by-word breakdown of a single entry, explained in
+// one language. Tokens holds the breakdown as JSON.
+type Glossary struct {
+	ID       string `json:"id"`
+	ChatID   string `json:"chat_id"`
+	Language string `json:"language"`
+	Tokens   string `json:"tokens"`
+}
+
+// SaveGlossary stores the glossary of an entry, replacing an earlier one in
+// the same language.
+func (d *Database) SaveGlossary(tx *sql.Tx, glossary Glossary) (*Glossary, error) {
+	glossary.ID = uuid.New().String()
+
+	_, err := tx.Exec(`INSERT INTO glossaries (id, chat_id, language, tokens, created_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(chat_id, language) DO UPDATE SET tokens = excluded.tokens, created_at = excluded.created_at`,
+		glossary.ID, glossary.ChatID, glossary.Language, glossary.Tokens, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	return &glossary, nil
+}
+
+// GetGlossary returns the cached glossary of an entry, or sql.ErrNoRows when
+// none was generated in that language yet.
+func (d *Database) GetGlossary(chatID, language string) (*Glossary, error) {
+	var glossary Glossary
+	err := d.conn.QueryRow("SELECT id, chat_id, language, tokens FROM glossaries WHERE chat_id = ? AND language = ?", chatID, language).
+		Scan(&glossary.ID, &glossary.ChatID, &glossary.Language, &glossary.Tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	return &glossary, nil
+}
@@ -0,0 +1,60 @@
+package data
+
+import (
+	"database/sql"
+	"time"
+)
+
+// OIDCLogin is a single sign-on attempt waiting for the identity provider to
+// send the user back. It is looked up by State and used only once.
+type OIDCLogin struct {
+	State     string    `json:"state"`
+	Verifier  string    `json:"verifier"`
+	Nonce     string    `json:"nonce"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateOIDCLogin stores a sign-on attempt, clearing out attempts started
+// before staleBefore that were never completed.
+func (d *Database) CreateOIDCLogin(tx *sql.Tx, login OIDCLogin, staleBefore time.Time) error {
+	if _, err := tx.Exec("DELETE FROM oidc_logins WHERE created_at < ?", staleBefore.UTC()); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec("INSERT INTO oidc_logins (state, verifier, nonce, created_at) VALUES (?, ?, ?, ?)",
+		login.State, login.Verifier, login.Nonce, time.Now().UTC())
+	return err
+}
+
+// TakeOIDCLogin returns the sign-on attempt with the given state and deletes
+// it, so that the state cannot be replayed. It returns sql.ErrNoRows when
+// there is no such attempt.
+func (d *Database) TakeOIDCLogin(tx *sql.Tx, state string) (*OIDCLogin, error) {
+	var login OIDCLogin
+	var createdAt sql.NullTime
+	err := tx.QueryRow("SELECT state, verifier, nonce, created_at FROM oidc_logins WHERE state = ?", state).
+		Scan(&login.State, &login.Verifier, &login.Nonce, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	login.CreatedAt = createdAt.Time
+
+	if _, err := tx.Exec("DELETE FROM oidc_logins WHERE state = ?", state); err != nil {
+		return nil, err
+	}
+
+	return &login, nil
+}
+
+// GetAccountByIdentity returns the account linked to the identity provider's
+// subject, or sql.ErrNoRows when the identity has not been seen before.
+func (d *Database) GetAccountByIdentity(issuer, subject string) (*Account, error) {
+	return scanAccount(d.conn.QueryRow("SELECT "+accountColumns+" FROM accounts WHERE id = (SELECT account_id FROM account_identities WHERE issuer = ? AND subject = ?)", issuer, subject))
+}
+
+// LinkAccountIdentity links an identity provider's subject to the account.
+func (d *Database) LinkAccountIdentity(tx *sql.Tx, accountID, issuer, subject string) error {
+	_, err := tx.Exec("INSERT INTO account_identities (issuer, subject, account_id, created_at) VALUES (?, ?, ?, ?)",
+		issuer, subject, accountID, time.Now().UTC())
+	return err
+}
@@ -0,0 +1,98 @@
+package data
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Objective struct {
+	ID          string     `json:"id"`
+	ChatUserID  string     `json:"chat_user_id"`
+	Position    int        `json:"position"`
+	Description string     `json:"description"`
+	CompletedBy string     `json:"completed_by"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+func (o Objective) IsCompleted() bool {
+	return o.CompletedAt != nil
+}
+
+func (d *Database) CreateObjectives(tx *sql.Tx, chatUserID string, descriptions []string) ([]Objective, error) {
+	if len(descriptions) == 0 {
+		return nil, nil
+	}
+
+	query := "INSERT INTO objectives (id, chat_user_id, position, description) VALUES "
+	var values []interface{}
+	placeholders := make([]string, len(descriptions))
+	objectives := make([]Objective, len(descriptions))
+
+	for i, description := range descriptions {
+		objectives[i] = Objective{
+			ID:          uuid.New().String(),
+			ChatUserID:  chatUserID,
+			Position:    i + 1,
+			Description: description,
+		}
+
+		placeholders[i] = "(?, ?, ?, ?)"
+
+		values = append(values, objectives[i].ID, objectives[i].ChatUserID, objectives[i].Position, objectives[i].Description)
+	}
+
+	query += strings.Join(placeholders, ",")
+
+	if _, err := tx.Exec(query, values...); err != nil {
+		return nil, err
+	}
+
+	return objectives, nil
+}
+
+func (d *Database) GetObjectivesByChatUserID(chatUserID string) ([]Objective, error) {
+	rows, err := d.conn.Query("SELECT id, chat_user_id, position, description, completed_by, completed_at FROM objectives WHERE chat_user_id = ? ORDER BY position", chatUserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var objectives []Objective
+	for rows.Next() {
+		var objective Objective
+		var completedAt sql.NullTime
+		if err := rows.Scan(&objective.ID, &objective.ChatUserID, &objective.Position, &objective.Description, &objective.CompletedBy, &completedAt); err != nil {
+			return nil, err
+		}
+		if completedAt.Valid {
+			objective.CompletedAt = &completedAt.Time
+		}
+		objectives = append(objectives, objective)
+	}
+	return objectives, rows.Err()
+}
+
+// CompleteObjectives marks the given objectives as completed by the user entry
+// with the given ID. Objectives that are already completed are left untouched.
+func (d *Database) CompleteObjectives(tx *sql.Tx, ids []string, entryID string) error {
+	for _, id := range ids {
+		if _, err := tx.Exec("UPDATE objectives SET completed_by = ?, completed_at = ? WHERE id = ? AND completed_at IS NULL", entryID, time.Now().UTC(), id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReopenObjectives marks the objectives completed by the given user entries as
+// pending again, e.g. after those entries were undone.
+func (d *Database) ReopenObjectives(tx *sql.Tx, entryIDs []string) error {
+	for _, entryID := range entryIDs {
+		if _, err := tx.Exec("UPDATE objectives SET completed_by = '', completed_at = NULL WHERE completed_by = ?", entryID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,537 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Role is what a member may do within an organization or classroom.
+type Role string
+
+const (
+	RoleTeacher Role = "teacher"
+	RoleStudent Role = "student"
+)
+
+// ErrForbidden is returned when the account acting on an organization or
+// classroom lacks the role needed for it.
+var ErrForbidden = errors.New("forbidden")
+
+func (r Role) IsValid() bool {
+	return r == RoleTeacher || r == RoleStudent
+}
+
+// Organization is a school or company whose members are grouped into
+// classrooms. Role is the role of the account the organization was read for.
+// The owner is the teacher who created it, the only one who may change the
+// role of existing members.
+type Organization struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	OwnerID   string    `json:"owner_id"`
+	CreatedAt time.Time `json:"created_at"`
+	Role      Role      `json:"role"`
+}
+
+// Invite asks an account to join an organization with a role. The account
+// only becomes a member once it accepts.
+type Invite struct {
+	OrganizationID   string    `json:"organization_id"`
+	OrganizationName string    `json:"organization_name"`
+	Role             Role      `json:"role"`
+	InvitedBy        string    `json:"invited_by"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+type Classroom struct {
+	ID             string    `json:"id"`
+	OrganizationID string    `json:"organization_id"`
+	Name           string    `json:"name"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Member is an account's membership of an organization or classroom.
+type Member struct {
+	AccountID string    `json:"account_id"`
+	Username  string    `json:"username"`
+	Role      Role      `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// StudentProgress sums up a student's conversations.
+type StudentProgress struct {
+	AccountID           string    `json:"account_id"`
+	Username            string    `json:"username"`
+	Conversations       int       `json:"conversations"`
+	Ended               int       `json:"ended"`
+	ObjectivesCompleted int       `json:"objectives_completed"`
+	ObjectivesTotal     int       `json:"objectives_total"`
+	LastActiveAt        time.Time `json:"last_active_at"`
+}
+
+// CreateOrganization stores a new organization with its creator as its owner
+// and first teacher.
+func (d *Database) CreateOrganization(tx *sql.Tx, name, creatorID string) (*Organization, error) {
+	organization := Organization{
+		ID:        uuid.New().String(),
+		Name:      name,
+		OwnerID:   creatorID,
+		CreatedAt: time.Now().UTC(),
+		Role:      RoleTeacher,
+	}
+
+	if _, err := tx.Exec("INSERT INTO organizations (id, name, owner_id, created_at) VALUES (?, ?, ?, ?)", organization.ID, organization.Name, organization.OwnerID, organization.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec("INSERT INTO organization_members (organization_id, account_id, role, created_at) VALUES (?, ?, ?, ?)", organization.ID, creatorID, RoleTeacher, organization.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	return &organization, nil
+}
+
+// GetOrganizationsByAccountID returns the organizations the account belongs
+// to, with its role in each.
+func (d *Database) GetOrganizationsByAccountID(accountID string) ([]Organization, error) {
+	rows, err := d.conn.Query(`SELECT organizations.id, organizations.name, organizations.owner_id, organizations.created_at, organization_members.role
+		FROM organizations JOIN organization_members ON organization_members.organization_id = organizations.id
+		WHERE organization_members.account_id = ? ORDER BY organizations.name, organizations.id`, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var organizations []Organization
+	for rows.Next() {
+		var organization Organization
+		var createdAt sql.NullTime
+		if err := rows.Scan(&organization.ID, &organization.Name, &organization.OwnerID, &createdAt, &organization.Role); err != nil {
+			return nil, err
+		}
+		organization.CreatedAt = createdAt.Time
+		organizations = append(organizations, organization)
+	}
+	return organizations, rows.Err()
+}
+
+// GetOrganizationRole returns the account's role in the organization, or
+// sql.ErrNoRows when it is not a member.
+func (d *Database) GetOrganizationRole(organizationID, accountID string) (Role, error) {
+	var role Role
+	err := d.conn.QueryRow("SELECT role FROM organization_members WHERE organization_id = ? AND account_id = ?", organizationID, accountID).Scan(&role)
+	return role, err
+}
+
+// AddOrganizationMember invites the account to the organization, replacing
+// any earlier invite, and reports whether it did. Accounts that are already
+// members have their role changed instead, which only the owner may do and
+// never for itself; a teacher made a student stops teaching the
+// organization's classrooms and studies in them instead. Only teachers of the organization may invite; others get
+// ErrForbidden.
+func (d *Database) AddOrganizationMember(tx *sql.Tx, actorID, organizationID, accountID string, role Role) (bool, error) {
+	if err := requireOrganizationTeacher(tx, organizationID, actorID); err != nil {
+		return false, err
+	}
+
+	var current Role
+	err := tx.QueryRow("SELECT role FROM organization_members WHERE organization_id = ? AND account_id = ?", organizationID, accountID).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err := tx.Exec(`INSERT INTO organization_invites (organization_id, account_id, role, invited_by, created_at) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(organization_id, account_id) DO UPDATE SET role = excluded.role, invited_by = excluded.invited_by, created_at = excluded.created_at`,
+			organizationID, accountID, role, actorID, time.Now().UTC())
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if current == role {
+		return false, nil
+	}
+
+	if err := requireOrganizationOwner(tx, organizationID, actorID); err != nil {
+		return false, err
+	}
+
+	if accountID == actorID {
+		return false, ErrForbidden
+	}
+
+	if _, err := tx.Exec("UPDATE organization_members SET role = ? WHERE organization_id = ? AND account_id = ?", role, organizationID, accountID); err != nil {
+		return false, err
+	}
+
+	if role != RoleStudent {
+		return false, nil
+	}
+
+	_, err = tx.Exec(`UPDATE classroom_members SET role = 'student' WHERE account_id = ? AND role = 'teacher'
+		AND classroom_id IN (SELECT id FROM classrooms WHERE organization_id = ?)`, accountID, organizationID)
+	return false, err
+}
+
+// GetInvitesByAccountID returns the invites waiting for the account to accept
+// them, newest first.
+func (d *Database) GetInvitesByAccountID(accountID string) ([]Invite, error) {
+	rows, err := d.conn.Query(`SELECT organizations.id, organizations.name, organization_invites.role, COALESCE(accounts.username, ''), organization_invites.created_at
+		FROM organization_invites
+		JOIN organizations ON organizations.id = organization_invites.organization_id
+		LEFT JOIN accounts ON accounts.id = organization_invites.invited_by
+		WHERE organization_invites.account_id = ? ORDER BY organization_invites.created_at DESC, organizations.id`, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invites []Invite
+	for rows.Next() {
+		var invite Invite
+		var createdAt sql.NullTime
+		if err := rows.Scan(&invite.OrganizationID, &invite.OrganizationName, &invite.Role, &invite.InvitedBy, &createdAt); err != nil {
+			return nil, err
+		}
+		invite.CreatedAt = createdAt.Time
+		invites = append(invites, invite)
+	}
+	return invites, rows.Err()
+}
+
+// AcceptInvite makes the account a member of the organization with the role
+// it was invited with. It returns sql.ErrNoRows when there is no such invite.
+func (d *Database) AcceptInvite(tx *sql.Tx, accountID, organizationID string) error {
+	var role Role
+	err := tx.QueryRow("DELETE FROM organization_invites WHERE organization_id = ? AND account_id = ? RETURNING role", organizationID, accountID).Scan(&role)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO organization_members (organization_id, account_id, role, created_at) VALUES (?, ?, ?, ?) ON CONFLICT(organization_id, account_id) DO NOTHING",
+		organizationID, accountID, role, time.Now().UTC())
+	return err
+}
+
+// DeclineInvite removes the account's invite to the organization. It returns
+// sql.ErrNoRows when there is no such invite.
+func (d *Database) DeclineInvite(tx *sql.Tx, accountID, organizationID string) error {
+	result, err := tx.Exec("DELETE FROM organization_invites WHERE organization_id = ? AND account_id = ?", organizationID, accountID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// GetOrganizationMembers lists the organization's members. Only teachers of
+// the organization may do so; others get ErrForbidden.
+func (d *Database) GetOrganizationMembers(actorID, organizationID string) ([]Member, error) {
+	if err := requireOrganizationTeacher(d.conn, organizationID, actorID); err != nil {
+		return nil, err
+	}
+
+	return queryMembers(d.conn, `SELECT accounts.id, accounts.username, organization_members.role, organization_members.created_at
+		FROM organization_members JOIN accounts ON accounts.id = organization_members.account_id
+		WHERE organization_members.organization_id = ? ORDER BY organization_members.role, accounts.username`, organizationID)
+}
+
+// CreateClassroom stores a new classroom in the organization with its creator
+// as the first teacher. Only teachers of the organization may do so; others
+// get ErrForbidden.
+func (d *Database) CreateClassroom(tx *sql.Tx, actorID, organizationID, name string) (*Classroom, error) {
+	if err := requireOrganizationTeacher(tx, organizationID, actorID); err != nil {
+		return nil, err
+	}
+
+	classroom := Classroom{
+		ID:             uuid.New().String(),
+		OrganizationID: organizationID,
+		Name:           name,
+		CreatedAt:      time.Now().UTC(),
+	}
+
+	_, err := tx.Exec("INSERT INTO classrooms (id, organization_id, name, created_at) VALUES (?, ?, ?, ?)",
+		classroom.ID, classroom.OrganizationID, classroom.Name, classroom.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec("INSERT INTO classroom_members (classroom_id, account_id, role, created_at) VALUES (?, ?, ?, ?)", classroom.ID, actorID, RoleTeacher, classroom.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	return &classroom, nil
+}
+
+// GetClassrooms returns the organization's classrooms the account can see:
+// every classroom for teachers of the organization, and the classrooms they
+// belong to for students.
+func (d *Database) GetClassrooms(actorID, organizationID string) ([]Classroom, error) {
+	rows, err := d.conn.Query(`SELECT id, organization_id, name, created_at FROM classrooms
+		WHERE organization_id = ? AND (
+			EXISTS (SELECT 1 FROM organization_members WHERE organization_id = classrooms.organization_id AND account_id = ? AND role = 'teacher')
+			OR EXISTS (SELECT 1 FROM classroom_members WHERE classroom_id = classrooms.id AND account_id = ?)
+		) ORDER BY name, id`, organizationID, actorID, actorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var classrooms []Classroom
+	for rows.Next() {
+		var classroom Classroom
+		var createdAt sql.NullTime
+		if err := rows.Scan(&classroom.ID, &classroom.OrganizationID, &classroom.Name, &createdAt); err != nil {
+			return nil, err
+		}
+		classroom.CreatedAt = createdAt.Time
+		classrooms = append(classrooms, classroom)
+	}
+	return classrooms, rows.Err()
+}
+
+// AddClassroomMember adds a member of the classroom's organization to the
+// classroom, or changes its role there. Only teachers of the organization may
+// add members, only its owner may change the role of existing ones, and only
+// its teachers can teach a classroom; otherwise ErrForbidden is returned. It
+// returns sql.ErrNoRows when the classroom does not exist or the account is
+// not a member of its organization.
+func (d *Database) AddClassroomMember(tx *sql.Tx, actorID, classroomID, accountID string, role Role) error {
+	var organizationID string
+	if err := tx.QueryRow("SELECT organization_id FROM classrooms WHERE id = ?", classroomID).Scan(&organizationID); err != nil {
+		return err
+	}
+
+	if err := requireOrganizationTeacher(tx, organizationID, actorID); err != nil {
+		return err
+	}
+
+	var organizationRole Role
+	if err := tx.QueryRow("SELECT role FROM organization_members WHERE organization_id = ? AND account_id = ?", organizationID, accountID).Scan(&organizationRole); err != nil {
+		return err
+	}
+
+	if role == RoleTeacher && organizationRole != RoleTeacher {
+		return ErrForbidden
+	}
+
+	var current Role
+	err := tx.QueryRow("SELECT role FROM classroom_members WHERE classroom_id = ? AND account_id = ?", classroomID, accountID).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err := tx.Exec("INSERT INTO classroom_members (classroom_id, account_id, role, created_at) VALUES (?, ?, ?, ?)",
+			classroomID, accountID, role, time.Now().UTC())
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if current == role {
+		return nil
+	}
+
+	if err := requireOrganizationOwner(tx, organizationID, actorID); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE classroom_members SET role = ? WHERE classroom_id = ? AND account_id = ?", role, classroomID, accountID)
+	return err
+}
+
+// GetClassroomRole returns the account's role in the classroom, or
+// sql.ErrNoRows when it is not a member.
+func (d *Database) GetClassroomRole(classroomID, accountID string) (Role, error) {
+	var role Role
+	err := d.conn.QueryRow("SELECT role FROM classroom_members WHERE classroom_id = ? AND account_id = ?", classroomID, accountID).Scan(&role)
+	return role, err
+}
+
+// GetClassroomMembers lists the classroom's teachers and students. Only
+// teachers of the classroom may do so; others get ErrForbidden.
+func (d *Database) GetClassroomMembers(actorID, classroomID string) ([]Member, error) {
+	if err := requireClassroomTeacher(d.conn, classroomID, actorID); err != nil {
+		return nil, err
+	}
+
+	return queryMembers(d.conn, `SELECT accounts.id, accounts.username, classroom_members.role, classroom_members.created_at
+		FROM classroom_members JOIN accounts ON accounts.id = classroom_members.account_id
+		WHERE classroom_members.classroom_id = ? ORDER BY classroom_members.role, accounts.username`, classroomID)
+}
+
+// GetClassroomProgress sums up the conversations each student started in the
+// classroom. Only teachers of the classroom may see it; others get
+// ErrForbidden.
+func (d *Database) GetClassroomProgress(actorID, classroomID string) ([]StudentProgress, error) {
+	if err := requireClassroomTeacher(d.conn, classroomID, actorID); err != nil {
+		return nil, err
+	}
+
+	rows, err := d.conn.Query(`SELECT accounts.id, accounts.username,
+			COUNT(chat_users.id),
+			COUNT(CASE WHEN chat_users.status = 'ended' THEN 1 END),
+			COALESCE(SUM((SELECT COUNT(*) FROM objectives WHERE objectives.chat_user_id = chat_users.id AND objectives.completed_at IS NOT NULL)), 0),
+			COALESCE(SUM((SELECT COUNT(*) FROM objectives WHERE objectives.chat_user_id = chat_users.id)), 0),
+			MAX(chat_users.status_updated_at)
+		FROM classroom_members
+		JOIN accounts ON accounts.id = classroom_members.account_id
+		LEFT JOIN chat_users ON chat_users.account_id = accounts.id AND chat_users.classroom_id = classroom_members.classroom_id
+		WHERE classroom_members.classroom_id = ? AND classroom_members.role = 'student'
+		GROUP BY accounts.id, accounts.username ORDER BY accounts.username`, classroomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var progress []StudentProgress
+	for rows.Next() {
+		var student StudentProgress
+		var lastActiveAt sql.NullString
+		if err := rows.Scan(&student.AccountID, &student.Username, &student.Conversations, &student.Ended, &student.ObjectivesCompleted, &student.ObjectivesTotal, &lastActiveAt); err != nil {
+			return nil, err
+		}
+		student.LastActiveAt = aggregateTime(lastActiveAt)
+		progress = append(progress, student)
+	}
+	return progress, rows.Err()
+}
+
+// GetStudentChatUsers returns one page of the conversations a student started
+// in the classroom, newest first, together with the total number of them.
+// Only teachers of the classroom may see them; others get ErrForbidden.
+func (d *Database) GetStudentChatUsers(actorID, classroomID, studentID string, limit, offset int) ([]ChatUser, int, error) {
+	if err := requireClassroomTeacher(d.conn, classroomID, actorID); err != nil {
+		return nil, 0, err
+	}
+
+	var student int
+	if err := d.conn.QueryRow("SELECT COUNT(*) FROM classroom_members WHERE classroom_id = ? AND account_id = ? AND role = 'student'", classroomID, studentID).Scan(&student); err != nil {
+		return nil, 0, err
+	}
+
+	if student == 0 {
+		return nil, 0, ErrForbidden
+	}
+
+	return d.getChatUsers("account_id = ? AND classroom_id = ?", []any{studentID, classroomID}, limit, offset)
+}
+
+// GetChatUserForAccount returns the conversation with the given ID when the
+// actor may access it: its own conversations, and when readOnly is set, those
+// started in a classroom it teaches by a student of that classroom. Others get
+// ErrForbidden, and unknown conversations sql.ErrNoRows.
+func (d *Database) GetChatUserForAccount(actorID, id string, readOnly bool) (*ChatUser, error) {
+	user, err := d.GetChatUser(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.AccountID == actorID {
+		return user, nil
+	}
+
+	if !readOnly || user.AccountID == "" || user.ClassroomID == "" {
+		return nil, ErrForbidden
+	}
+
+	var count int
+	err = d.conn.QueryRow(`SELECT COUNT(*) FROM classroom_members AS teachers
+		JOIN classroom_members AS students ON students.classroom_id = teachers.classroom_id
+		WHERE teachers.classroom_id = ? AND teachers.account_id = ? AND teachers.role = 'teacher' AND students.account_id = ? AND students.role = 'student'`,
+		user.ClassroomID, actorID, user.AccountID).Scan(&count)
+	if err != nil {
+		return nil, err
+	}
+
+	if count == 0 {
+		return nil, ErrForbidden
+	}
+
+	return user, nil
+}
+
+// storedTimeLayout is the text times are stored as, which is what aggregates
+// such as MAX return since they lose the column's DATETIME type.
+const storedTimeLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+func aggregateTime(value sql.NullString) time.Time {
+	parsed, _ := time.Parse(storedTimeLayout, value.String)
+	return parsed.UTC()
+}
+
+type queryer interface {
+	QueryRow(query string, args ...any) *sql.Row
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func requireOrganizationTeacher(conn queryer, organizationID, accountID string) error {
+	var count int
+	err := conn.QueryRow("SELECT COUNT(*) FROM organization_members WHERE organization_id = ? AND account_id = ? AND role = 'teacher'", organizationID, accountID).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return ErrForbidden
+	}
+
+	return nil
+}
+
+func requireOrganizationOwner(conn queryer, organizationID, accountID string) error {
+	var count int
+	err := conn.QueryRow("SELECT COUNT(*) FROM organizations WHERE id = ? AND owner_id = ?", organizationID, accountID).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return ErrForbidden
+	}
+
+	return nil
+}
+
+func requireClassroomTeacher(conn queryer, classroomID, accountID string) error {
+	var count int
+	err := conn.QueryRow("SELECT COUNT(*) FROM classroom_members WHERE classroom_id = ? AND account_id = ? AND role = 'teacher'", classroomID, accountID).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return ErrForbidden
+	}
+
+	return nil
+}
+
+func queryMembers(conn queryer, query string, args ...any) ([]Member, error) {
+	rows, err := conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []Member
+	for rows.Next() {
+		var member Member
+		var createdAt sql.NullTime
+		if err := rows.Scan(&member.AccountID, &member.Username, &member.Role, &createdAt); err != nil {
+			return nil, err
+		}
+		member.CreatedAt = createdAt.Time
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
@@ -0,0 +1,285 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// inTx runs fn in a transaction that is committed when fn succeeds.
+func inTx(t *testing.T, d *Database, fn func(tx *sql.Tx) error) error {
+	t.Helper()
+
+	tx, err := d.BeginTx()
+	if err != nil {
+		t.Fatalf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+type school struct {
+	db        *Database
+	owner     *Account
+	teacher   *Account
+	student   *Account
+	outsider  *Account
+	org       *Organization
+	classroom *Classroom
+}
+
+// newSchool sets up an organization whose owner invited a teacher and a
+// student who both accepted, and a classroom with the student in it.
+func newSchool(t *testing.T) *school {
+	t.Helper()
+
+	s := &school{db: New(filepath.Join(t.TempDir(), "test.db"))}
+
+	err := inTx(t, s.db, func(tx *sql.Tx) error {
+		var err error
+		for _, account := range []struct {
+			target **Account
+			name   string
+		}{{&s.owner, "owner"}, {&s.teacher, "teacher"}, {&s.student, "student"}, {&s.outsider, "outsider"}} {
+			if *account.target, err = s.db.CreateAccount(tx, account.name, ""); err != nil {
+				return err
+			}
+		}
+
+		if s.org, err = s.db.CreateOrganization(tx, "School", s.owner.ID); err != nil {
+			return err
+		}
+
+		if _, err := s.db.AddOrganizationMember(tx, s.owner.ID, s.org.ID, s.teacher.ID, RoleTeacher); err != nil {
+			return err
+		}
+		if _, err := s.db.AddOrganizationMember(tx, s.owner.ID, s.org.ID, s.student.ID, RoleStudent); err != nil {
+			return err
+		}
+		if err := s.db.AcceptInvite(tx, s.teacher.ID, s.org.ID); err != nil {
+			return err
+		}
+		if err := s.db.AcceptInvite(tx, s.student.ID, s.org.ID); err != nil {
+			return err
+		}
+
+		if s.classroom, err = s.db.CreateClassroom(tx, s.teacher.ID, s.org.ID, "Class"); err != nil {
+			return err
+		}
+
+		return s.db.AddClassroomMember(tx, s.teacher.ID, s.classroom.ID, s.student.ID, RoleStudent)
+	})
+	if err != nil {
+		t.Fatalf("failed to set up organization: %v", err)
+	}
+
+	return s
+}
+
+func (s *school) startChat(t *testing.T, accountID, classroomID string) *ChatUser {
+	t.Helper()
+
+	var user *ChatUser
+	err := inTx(t, s.db, func(tx *sql.Tx) error {
+		var err error
+		user, err = s.db.CreateChatUser(tx, ChatUser{Secret: "secret", AccountID: accountID, Language: "en", ClassroomID: classroomID})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to start chat: %v", err)
+	}
+
+	return user
+}
+
+func TestInviteNeedsAcceptance(t *testing.T) {
+	s := newSchool(t)
+
+	err := inTx(t, s.db, func(tx *sql.Tx) error {
+		invited, err := s.db.AddOrganizationMember(tx, s.teacher.ID, s.org.ID, s.outsider.ID, RoleStudent)
+		if err == nil && !invited {
+			t.Error("AddOrganizationMember() did not invite a non-member")
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("AddOrganizationMember() error = %v", err)
+	}
+
+	if _, err := s.db.GetOrganizationRole(s.org.ID, s.outsider.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("invited account is a member before accepting, error = %v", err)
+	}
+
+	// Not yet a member, so the invited account cannot be put in a classroom
+	err = inTx(t, s.db, func(tx *sql.Tx) error {
+		return s.db.AddClassroomMember(tx, s.teacher.ID, s.classroom.ID, s.outsider.ID, RoleStudent)
+	})
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("AddClassroomMember() error = %v, want %v", err, sql.ErrNoRows)
+	}
+
+	invites, err := s.db.GetInvitesByAccountID(s.outsider.ID)
+	if err != nil || len(invites) != 1 || invites[0].InvitedBy != "teacher" {
+		t.Fatalf("GetInvitesByAccountID() = %+v, %v, want one invite from teacher", invites, err)
+	}
+
+	if err := inTx(t, s.db, func(tx *sql.Tx) error { return s.db.DeclineInvite(tx, s.outsider.ID, s.org.ID) }); err != nil {
+		t.Fatalf("DeclineInvite() error = %v", err)
+	}
+
+	if err := inTx(t, s.db, func(tx *sql.Tx) error { return s.db.AcceptInvite(tx, s.outsider.ID, s.org.ID) }); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("AcceptInvite() after declining error = %v, want %v", err, sql.ErrNoRows)
+	}
+}
+
+func TestRoleChanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		actor   func(s *school) string
+		account func(s *school) string
+		role    Role
+		err     error
+	}{
+		{name: "teacher demotes the owner", actor: func(s *school) string { return s.teacher.ID }, account: func(s *school) string { return s.owner.ID }, role: RoleStudent, err: ErrForbidden},
+		{name: "teacher promotes a student", actor: func(s *school) string { return s.teacher.ID }, account: func(s *school) string { return s.student.ID }, role: RoleTeacher, err: ErrForbidden},
+		{name: "owner demotes itself", actor: func(s *school) string { return s.owner.ID }, account: func(s *school) string { return s.owner.ID }, role: RoleStudent, err: ErrForbidden},
+		{name: "owner demotes a teacher", actor: func(s *school) string { return s.owner.ID }, account: func(s *school) string { return s.teacher.ID }, role: RoleStudent},
+		{name: "teacher keeps a role unchanged", actor: func(s *school) string { return s.teacher.ID }, account: func(s *school) string { return s.student.ID }, role: RoleStudent},
+		{name: "student invites", actor: func(s *school) string { return s.student.ID }, account: func(s *school) string { return s.outsider.ID }, role: RoleTeacher, err: ErrForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSchool(t)
+			account := tt.account(s)
+
+			before, _ := s.db.GetOrganizationRole(s.org.ID, account)
+
+			err := inTx(t, s.db, func(tx *sql.Tx) error {
+				_, err := s.db.AddOrganizationMember(tx, tt.actor(s), s.org.ID, account, tt.role)
+				return err
+			})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("AddOrganizationMember() error = %v, want %v", err, tt.err)
+			}
+
+			after, _ := s.db.GetOrganizationRole(s.org.ID, account)
+			if tt.err != nil && after != before {
+				t.Errorf("role changed from %s to %s despite the error", before, after)
+			}
+			if tt.err == nil && before != "" && after != tt.role {
+				t.Errorf("role = %s, want %s", after, tt.role)
+			}
+		})
+	}
+}
+
+func TestClassroomRoleChanges(t *testing.T) {
+	s := newSchool(t)
+
+	// The student is made a teacher of the organization by its owner, but
+	// only the owner may then make it teach the classroom it studies in
+	err := inTx(t, s.db, func(tx *sql.Tx) error {
+		_, err := s.db.AddOrganizationMember(tx, s.owner.ID, s.org.ID, s.student.ID, RoleTeacher)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("AddOrganizationMember() error = %v", err)
+	}
+
+	err = inTx(t, s.db, func(tx *sql.Tx) error {
+		return s.db.AddClassroomMember(tx, s.teacher.ID, s.classroom.ID, s.student.ID, RoleTeacher)
+	})
+	if !errors.Is(err, ErrForbidden) {
+		t.Fatalf("AddClassroomMember() by a teacher error = %v, want %v", err, ErrForbidden)
+	}
+
+	err = inTx(t, s.db, func(tx *sql.Tx) error {
+		return s.db.AddClassroomMember(tx, s.owner.ID, s.classroom.ID, s.student.ID, RoleTeacher)
+	})
+	if err != nil {
+		t.Fatalf("AddClassroomMember() by the owner error = %v", err)
+	}
+}
+
+func TestDemotedTeacher(t *testing.T) {
+	s := newSchool(t)
+	classwork := s.startChat(t, s.student.ID, s.classroom.ID)
+
+	err := inTx(t, s.db, func(tx *sql.Tx) error {
+		_, err := s.db.AddOrganizationMember(tx, s.owner.ID, s.org.ID, s.teacher.ID, RoleStudent)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("AddOrganizationMember() error = %v", err)
+	}
+
+	if role, err := s.db.GetClassroomRole(s.classroom.ID, s.teacher.ID); err != nil || role != RoleStudent {
+		t.Errorf("GetClassroomRole() = %s, %v, want %s", role, err, RoleStudent)
+	}
+
+	if _, err := s.db.GetClassroomProgress(s.teacher.ID, s.classroom.ID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("GetClassroomProgress() error = %v, want %v", err, ErrForbidden)
+	}
+
+	if _, _, err := s.db.GetStudentChatUsers(s.teacher.ID, s.classroom.ID, s.student.ID, 10, 0); !errors.Is(err, ErrForbidden) {
+		t.Errorf("GetStudentChatUsers() error = %v, want %v", err, ErrForbidden)
+	}
+
+	if _, err := s.db.GetChatUserForAccount(s.teacher.ID, classwork.ID, true); !errors.Is(err, ErrForbidden) {
+		t.Errorf("GetChatUserForAccount() error = %v, want %v", err, ErrForbidden)
+	}
+}
+
+func TestTeacherVisibility(t *testing.T) {
+	s := newSchool(t)
+
+	classwork := s.startChat(t, s.student.ID, s.classroom.ID)
+	private := s.startChat(t, s.student.ID, "")
+
+	tests := []struct {
+		name     string
+		actor    string
+		chat     *ChatUser
+		readOnly bool
+		err      error
+	}{
+		{name: "teacher reads classwork", actor: s.teacher.ID, chat: classwork, readOnly: true},
+		{name: "teacher writes classwork", actor: s.teacher.ID, chat: classwork, err: ErrForbidden},
+		{name: "teacher reads a private conversation", actor: s.teacher.ID, chat: private, readOnly: true, err: ErrForbidden},
+		{name: "owner outside the classroom reads classwork", actor: s.owner.ID, chat: classwork, readOnly: true, err: ErrForbidden},
+		{name: "outsider reads classwork", actor: s.outsider.ID, chat: classwork, readOnly: true, err: ErrForbidden},
+		{name: "student reads its private conversation", actor: s.student.ID, chat: private, readOnly: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.db.GetChatUserForAccount(tt.actor, tt.chat.ID, tt.readOnly)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("GetChatUserForAccount() error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	users, total, err := s.db.GetStudentChatUsers(s.teacher.ID, s.classroom.ID, s.student.ID, 10, 0)
+	if err != nil {
+		t.Fatalf("GetStudentChatUsers() error = %v", err)
+	}
+	if total != 1 || len(users) != 1 || users[0].ID != classwork.ID {
+		t.Errorf("GetStudentChatUsers() = %d conversations of %d, want only the classwork", len(users), total)
+	}
+
+	progress, err := s.db.GetClassroomProgress(s.teacher.ID, s.classroom.ID)
+	if err != nil {
+		t.Fatalf("GetClassroomProgress() error = %v", err)
+	}
+	if len(progress) != 1 || progress[0].Conversations != 1 {
+		t.Errorf("GetClassroomProgress() = %+v, want one conversation for the student", progress)
+	}
+}
@@ -0,0 +1,110 @@
+package data
+
+import (
+	"database/sql"
+	"time"
+)
+
+// ProviderKey is an account's own key for the AI provider. SealedKey holds the
+// key encrypted under the master key named by MasterKeyID; Hint keeps its last
+// characters so that the owner can tell which key is stored.
+type ProviderKey struct {
+	AccountID   string    `json:"account_id"`
+	MasterKeyID string    `json:"master_key_id"`
+	SealedKey   []byte    `json:"sealed_key"`
+	Hint        string    `json:"hint"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+const providerKeyColumns = "account_id, master_key_id, sealed_key, hint, created_at, updated_at"
+
+// SetProviderKey stores the account's provider key, replacing any earlier
+// one.
+func (d *Database) SetProviderKey(tx *sql.Tx, key ProviderKey) error {
+	now := time.Now().UTC()
+
+	_, err := tx.Exec(`INSERT INTO provider_keys (account_id, master_key_id, sealed_key, hint, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (account_id) DO UPDATE SET master_key_id = excluded.master_key_id, sealed_key = excluded.sealed_key,
+			hint = excluded.hint, updated_at = excluded.updated_at`,
+		key.AccountID, key.MasterKeyID, key.SealedKey, key.Hint, now, now)
+
+	return err
+}
+
+// GetProviderKey returns the account's provider key, or sql.ErrNoRows when it
+// has none.
+func (d *Database) GetProviderKey(accountID string) (*ProviderKey, error) {
+	return scanProviderKey(d.conn.QueryRow("SELECT "+providerKeyColumns+" FROM provider_keys WHERE account_id = ?", accountID))
+}
+
+// DeleteProviderKey removes the account's provider key. It returns
+// sql.ErrNoRows when the account has none.
+func (d *Database) DeleteProviderKey(tx *sql.Tx, accountID string) error {
+	result, err := tx.Exec("DELETE FROM provider_keys WHERE account_id = ?", accountID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// GetProviderKeysSealedWithout returns the provider keys sealed under any
+// master key other than masterKeyID.
+func (d *Database) GetProviderKeysSealedWithout(masterKeyID string) ([]ProviderKey, error) {
+	rows, err := d.conn.Query("SELECT "+providerKeyColumns+" FROM provider_keys WHERE master_key_id != ? ORDER BY account_id", masterKeyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []ProviderKey
+	for rows.Next() {
+		key, err := scanProviderKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// ResealProviderKey replaces the sealed form of the account's provider key,
+// unless the key was replaced since it was read under previousMasterKeyID. It
+// reports whether the key was updated.
+func (d *Database) ResealProviderKey(tx *sql.Tx, accountID, previousMasterKeyID, masterKeyID string, sealedKey []byte) (bool, error) {
+	result, err := tx.Exec("UPDATE provider_keys SET master_key_id = ?, sealed_key = ? WHERE account_id = ? AND master_key_id = ?",
+		masterKeyID, sealedKey, accountID, previousMasterKeyID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func scanProviderKey(row scanner) (*ProviderKey, error) {
+	var key ProviderKey
+	var createdAt, updatedAt sql.NullTime
+
+	if err := row.Scan(&key.AccountID, &key.MasterKeyID, &key.SealedKey, &key.Hint, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+
+	key.CreatedAt = createdAt.Time
+	key.UpdatedAt = updatedAt.Time
+
+	return &key, nil
+}
@@ -0,0 +1,96 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidSession is returned when a session cannot be refreshed, either
+// because it is unknown, expired or revoked, or because its refresh token was
+// already used.
+var ErrInvalidSession = errors.New("invalid session")
+
+// Session is a login to a conversation or an account. RefreshHash holds the
+// hash of the current refresh token, which changes on every refresh.
+type Session struct {
+	ID          string    `json:"id"`
+	Kind        string    `json:"kind"`
+	SubjectID   string    `json:"subject_id"`
+	RefreshHash string    `json:"refresh_hash"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	RevokedAt   time.Time `json:"revoked_at"`
+}
+
+const sessionColumns = "id, kind, subject_id, refresh_hash, created_at, expires_at, revoked_at"
+
+// IsActive reports whether the session can still be used.
+func (s *Session) IsActive(now time.Time) bool {
+	return s.RevokedAt.IsZero() && now.Before(s.ExpiresAt)
+}
+
+// NewSessionID returns the ID for a session about to be created, so that its
+// refresh token can carry the ID before the session is stored.
+func NewSessionID() string {
+	return uuid.New().String()
+}
+
+// CreateSession stores a new session. The ID comes from NewSessionID; the
+// creation time is filled in.
+func (d *Database) CreateSession(tx *sql.Tx, session Session) (*Session, error) {
+	session.CreatedAt = time.Now().UTC()
+	session.ExpiresAt = session.ExpiresAt.UTC()
+
+	_, err := tx.Exec("INSERT INTO sessions (id, kind, subject_id, refresh_hash, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		session.ID, session.Kind, session.SubjectID, session.RefreshHash, session.CreatedAt, session.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// GetSession returns the session with the given ID, or sql.ErrNoRows.
+func (d *Database) GetSession(id string) (*Session, error) {
+	var session Session
+	var createdAt, expiresAt, revokedAt sql.NullTime
+	err := d.conn.QueryRow("SELECT "+sessionColumns+" FROM sessions WHERE id = ?", id).
+		Scan(&session.ID, &session.Kind, &session.SubjectID, &session.RefreshHash, &createdAt, &expiresAt, &revokedAt)
+	if err != nil {
+		return nil, err
+	}
+	session.CreatedAt = createdAt.Time
+	session.ExpiresAt = expiresAt.Time
+	session.RevokedAt = revokedAt.Time
+	return &session, nil
+}
+
+// RotateSession swaps the session's refresh token hash and extends it. It only
+// succeeds while the old hash is current, so a refresh token works once.
+func (d *Database) RotateSession(tx *sql.Tx, id, oldHash, newHash string, expiresAt time.Time) error {
+	result, err := tx.Exec("UPDATE sessions SET refresh_hash = ?, expires_at = ? WHERE id = ? AND refresh_hash = ? AND revoked_at IS NULL AND expires_at > ?",
+		newHash, expiresAt.UTC(), id, oldHash, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return ErrInvalidSession
+	}
+
+	return nil
+}
+
+// RevokeSession ends the session, invalidating its access and refresh tokens.
+func (d *Database) RevokeSession(tx *sql.Tx, id string) error {
+	_, err := tx.Exec("UPDATE sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", time.Now().UTC(), id)
+	return err
+}
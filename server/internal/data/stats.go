@@ -0,0 +1,135 @@
+package data
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+)
+
+// Count is the number of conversations sharing a key such as a day, a
+// language or a status.
+type Count struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// ConversationStats sums up the conversations started within a period.
+// AverageTurns counts the turns the learner took, undone ones excluded.
+type ConversationStats struct {
+	Conversations int     `json:"conversations"`
+	AverageTurns  float64 `json:"average_turns"`
+	PerDay        []Count `json:"per_day"`
+	Languages     []Count `json:"languages"`
+	Statuses      []Count `json:"statuses"`
+}
+
+// RequestOutcome counts the requests served by a route and those that failed,
+// split into the client's errors and the server's.
+type RequestOutcome struct {
+	Route        string `json:"route"`
+	Requests     int    `json:"requests"`
+	ClientErrors int    `json:"client_errors"`
+	ServerErrors int    `json:"server_errors"`
+}
+
+// DailyRequestOutcome counts the requests a route served on a single UTC day.
+type DailyRequestOutcome struct {
+	Day time.Time
+	RequestOutcome
+}
+
+// Add counts a request answered with the given status.
+func (o *RequestOutcome) Add(status int) {
+	o.Requests++
+
+	switch {
+	case status >= http.StatusInternalServerError:
+		o.ServerErrors++
+	case status >= http.StatusBadRequest:
+		o.ClientErrors++
+	}
+}
+
+// GetConversationStats sums up the conversations started since the given
+// time. Days are UTC days.
+func (d *Database) GetConversationStats(since time.Time) (*ConversationStats, error) {
+	since = since.UTC()
+
+	var stats ConversationStats
+	err := d.conn.QueryRow(`SELECT COUNT(*), COALESCE(AVG(turns), 0) FROM (
+			SELECT (SELECT COUNT(*) FROM chats WHERE chats.chat_user_id = chat_users.id AND chats.role = 'user' AND chats.deleted_at IS NULL) AS turns
+			FROM chat_users WHERE created_at >= ?
+		)`, since).Scan(&stats.Conversations, &stats.AverageTurns)
+	if err != nil {
+		return nil, err
+	}
+
+	if stats.PerDay, err = d.countChatUsers("substr(created_at, 1, 10)", "key", since); err != nil {
+		return nil, err
+	}
+
+	if stats.Languages, err = d.countChatUsers("language", "COUNT(*) DESC, key", since); err != nil {
+		return nil, err
+	}
+
+	if stats.Statuses, err = d.countChatUsers("status", "COUNT(*) DESC, key", since); err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+func (d *Database) countChatUsers(key, order string, since time.Time) ([]Count, error) {
+	rows, err := d.conn.Query("SELECT "+key+" AS key, COUNT(*) FROM chat_users WHERE created_at >= ? GROUP BY key ORDER BY "+order, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []Count
+	for rows.Next() {
+		var count Count
+		if err := rows.Scan(&count.Key, &count.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
+
+// RecordRequestOutcomes adds the counted requests to the stored outcomes of
+// their day and route.
+func (d *Database) RecordRequestOutcomes(tx *sql.Tx, outcomes []DailyRequestOutcome) error {
+	for _, outcome := range outcomes {
+		_, err := tx.Exec(`INSERT INTO request_outcomes (day, route, requests, client_errors, server_errors) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (day, route) DO UPDATE SET requests = requests + excluded.requests, client_errors = client_errors + excluded.client_errors,
+				server_errors = server_errors + excluded.server_errors`,
+			outcome.Day.UTC().Format(time.DateOnly), outcome.Route, outcome.Requests, outcome.ClientErrors, outcome.ServerErrors)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetRequestOutcomes sums up the outcomes of every route since the UTC day of
+// the given time, busiest route first.
+func (d *Database) GetRequestOutcomes(since time.Time) ([]RequestOutcome, error) {
+	rows, err := d.conn.Query(`SELECT route, SUM(requests), SUM(client_errors), SUM(server_errors) FROM request_outcomes
+		WHERE day >= ? GROUP BY route ORDER BY SUM(requests) DESC, route`, since.UTC().Format(time.DateOnly))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var outcomes []RequestOutcome
+	for rows.Next() {
+		var outcome RequestOutcome
+		if err := rows.Scan(&outcome.Route, &outcome.Requests, &outcome.ClientErrors, &outcome.ServerErrors); err != nil {
+			return nil, err
+		}
+		outcomes = append(outcomes, outcome)
+	}
+	return outcomes, rows.Err()
+}
@@ -0,0 +1,67 @@
+package data
+
+import (
+	"database/sql"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordRequestOutcomes(t *testing.T) {
+	d := New(filepath.Join(t.TempDir(), "test.db"))
+
+	today := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	yesterday := today.AddDate(0, 0, -1)
+
+	count := func(day time.Time, route string, statuses ...int) DailyRequestOutcome {
+		outcome := DailyRequestOutcome{Day: day, RequestOutcome: RequestOutcome{Route: route}}
+		for _, status := range statuses {
+			outcome.Add(status)
+		}
+		return outcome
+	}
+
+	// Two flushes of the same day and route add up
+	flushes := [][]DailyRequestOutcome{
+		{count(yesterday, "GET /chat/status", http.StatusOK), count(today, "POST /chat/answer", http.StatusOK, http.StatusBadRequest)},
+		{count(today, "POST /chat/answer", http.StatusInternalServerError), count(today, "GET /chat/status", http.StatusOK)},
+	}
+	for _, outcomes := range flushes {
+		if err := inTx(t, d, func(tx *sql.Tx) error { return d.RecordRequestOutcomes(tx, outcomes) }); err != nil {
+			t.Fatalf("RecordRequestOutcomes() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		since time.Time
+		want  []RequestOutcome
+	}{
+		{name: "today", since: today.Add(time.Hour), want: []RequestOutcome{
+			{Route: "POST /chat/answer", Requests: 3, ClientErrors: 1, ServerErrors: 1},
+			{Route: "GET /chat/status", Requests: 1},
+		}},
+		{name: "since yesterday", since: yesterday, want: []RequestOutcome{
+			{Route: "POST /chat/answer", Requests: 3, ClientErrors: 1, ServerErrors: 1},
+			{Route: "GET /chat/status", Requests: 2},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := d.GetRequestOutcomes(tt.since)
+			if err != nil {
+				t.Fatalf("GetRequestOutcomes() error = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("GetRequestOutcomes() = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("GetRequestOutcomes()[%d] = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
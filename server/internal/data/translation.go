@@ -0,0 +1,44 @@
+package data
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Translation is a cached translation of a single entry into one language.
+type Translation struct {
+	ID       string `json:"id"`
+	ChatID   string `json:"chat_id"`
+	Language string `json:"language"`
+	Text     string `json:"text"`
+}
+
+// SaveTranslation stores the translation of an entry, replacing an earlier
+// one in the same language.
+func (d *Database) SaveTranslation(tx *sql.Tx, translation Translation) (*Translation, error) {
+	translation.ID = uuid.New().String()
+
+	_, err := tx.Exec(`INSERT INTO translations (id, chat_id, language, text, created_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(chat_id, language) DO UPDATE SET text = excluded.text, created_at = excluded.created_at`,
+		translation.ID, translation.ChatID, translation.Language, translation.Text, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	return &translation, nil
+}
+
+// GetTranslation returns the cached translation of an entry, or
+// sql.ErrNoRows when it has not been translated into that language yet.
+func (d *Database) GetTranslation(chatID, language string) (*Translation, error) {
+	var translation Translation
+	err := d.conn.QueryRow("SELECT id, chat_id, language, text FROM translations WHERE chat_id = ? AND language = ?", chatID, language).
+		Scan(&translation.ID, &translation.ChatID, &translation.Language, &translation.Text)
+	if err != nil {
+		return nil, err
+	}
+
+	return &translation, nil
+}
@@ -0,0 +1,75 @@
+package data
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OperationTurn records a turn taken by the learner, counted against turn
+// quotas. Calls to the AI provider are recorded under the provider's
+// operation names.
+const OperationTurn = "turn"
+
+// Usage is a metered call to the AI provider or a turn taken. Cost is in
+// millionths of a US dollar. OwnKey marks calls billed to the account's own
+// provider key, which do not count towards spending caps.
+type Usage struct {
+	ID           string    `json:"id"`
+	AccountID    string    `json:"account_id"`
+	Operation    string    `json:"operation"`
+	Model        string    `json:"model"`
+	InputTokens  int       `json:"input_tokens"`
+	OutputTokens int       `json:"output_tokens"`
+	AudioSeconds float64   `json:"audio_seconds"`
+	Characters   int       `json:"characters"`
+	Cost         int64     `json:"cost"`
+	Turns        int       `json:"turns"`
+	OwnKey       bool      `json:"own_key"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// UsageTotals sums up spending on the server's provider key and turns since
+// the start of the day and of the month.
+type UsageTotals struct {
+	DailyCost    int64
+	MonthlyCost  int64
+	DailyTurns   int
+	MonthlyTurns int
+}
+
+// RecordUsage stores a usage record. Usage is recorded outside of the
+// request's transaction, as the provider bills the call either way.
+func (d *Database) RecordUsage(usage Usage) error {
+	_, err := d.conn.Exec(`INSERT INTO usage_records (id, account_id, operation, model, input_tokens, output_tokens, audio_seconds, characters, cost, turns, own_key, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		uuid.New().String(), usage.AccountID, usage.Operation, usage.Model, usage.InputTokens, usage.OutputTokens, usage.AudioSeconds, usage.Characters, usage.Cost, usage.Turns, usage.OwnKey, time.Now().UTC())
+
+	return err
+}
+
+// GetAccountUsage sums up the account's usage since the start of day and of
+// month.
+func (d *Database) GetAccountUsage(accountID string, day, month time.Time) (UsageTotals, error) {
+	return d.sumUsage("account_id = ?", accountID, day, month)
+}
+
+// GetOrganizationUsage sums up the usage of every member of the organization
+// since the start of day and of month. Members of several organizations count
+// towards each of them.
+func (d *Database) GetOrganizationUsage(organizationID string, day, month time.Time) (UsageTotals, error) {
+	return d.sumUsage("account_id IN (SELECT account_id FROM organization_members WHERE organization_id = ?)", organizationID, day, month)
+}
+
+func (d *Database) sumUsage(filter, id string, day, month time.Time) (UsageTotals, error) {
+	var totals UsageTotals
+	err := d.conn.QueryRow(`SELECT
+			COALESCE(SUM(CASE WHEN created_at >= ? AND NOT own_key THEN cost END), 0),
+			COALESCE(SUM(CASE WHEN NOT own_key THEN cost END), 0),
+			COALESCE(SUM(CASE WHEN created_at >= ? THEN turns END), 0),
+			COALESCE(SUM(turns), 0)
+		FROM usage_records WHERE `+filter+` AND created_at >= ?`,
+		day.UTC(), day.UTC(), id, month.UTC()).Scan(&totals.DailyCost, &totals.MonthlyCost, &totals.DailyTurns, &totals.MonthlyTurns)
+
+	return totals, err
+}
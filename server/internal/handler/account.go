@@ -0,0 +1,316 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/madeindra/mock-conversation/server/internal/auth"
+	"github.com/madeindra/mock-conversation/server/internal/data"
+	"github.com/madeindra/mock-conversation/server/internal/middleware"
+	"github.com/madeindra/mock-conversation/server/internal/model"
+	"github.com/madeindra/mock-conversation/server/internal/util"
+)
+
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 64
+	minPasswordLength = 8
+
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// Register creates an account that conversations can be linked to.
+func (h *handler) Register(w http.ResponseWriter, req *http.Request) {
+	var accountRequest model.AccountRequest
+	if err := json.NewDecoder(req.Body).Decode(&accountRequest); err != nil {
+		log.Printf("failed to read register request body: %v", err)
+		util.SendResponse(w, nil, "failed to read request", http.StatusBadRequest)
+
+		return
+	}
+
+	username := strings.TrimSpace(accountRequest.Username)
+	if err := validateCredentials(username, accountRequest.Password); err != nil {
+		log.Printf("invalid credentials: %v", err)
+		util.SendResponse(w, nil, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	hashed, err := util.CreateHash(accountRequest.Password)
+	if err != nil {
+		log.Printf("failed to create hash: %v", err)
+		util.SendResponse(w, nil, "failed to create account", http.StatusInternalServerError)
+
+		return
+	}
+
+	tx, err := h.db.BeginTx()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+		util.SendResponse(w, nil, "failed to create account", http.StatusInternalServerError)
+
+		return
+	}
+	defer tx.Rollback()
+
+	account, err := h.db.CreateAccount(tx, username, hashed)
+	if errors.Is(err, data.ErrUsernameTaken) {
+		log.Printf("username %s is taken", username)
+		util.SendResponse(w, nil, "username is already taken", http.StatusConflict)
+
+		return
+	}
+	if err != nil {
+		log.Printf("failed to create account: %v", err)
+		util.SendResponse(w, nil, "failed to create account", http.StatusInternalServerError)
+
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+		util.SendResponse(w, nil, "failed to create account", http.StatusInternalServerError)
+
+		return
+	}
+
+	util.SendResponse(w, util.ConvertToAccount(account), "account created", http.StatusOK)
+}
+
+// Login checks an account's username and password and opens a session for
+// the account.
+func (h *handler) Login(w http.ResponseWriter, req *http.Request) {
+	var accountRequest model.AccountRequest
+	if err := json.NewDecoder(req.Body).Decode(&accountRequest); err != nil {
+		log.Printf("failed to read login request body: %v", err)
+		util.SendResponse(w, nil, "failed to read request", http.StatusBadRequest)
+
+		return
+	}
+
+	account, ok := h.login(w, strings.TrimSpace(accountRequest.Username), accountRequest.Password)
+	if !ok {
+		return
+	}
+
+	tokens, ok := h.startSession(w, auth.KindAccount, account.ID)
+	if !ok {
+		return
+	}
+
+	response := model.LoginResponse{
+		AccountResponse: util.ConvertToAccount(account),
+		TokenResponse:   tokens,
+	}
+
+	util.SendResponse(w, response, "success", http.StatusOK)
+}
+
+// ListChats returns the account's own conversations, newest first, one page
+// at a time with ?page= and ?pageSize=.
+func (h *handler) ListChats(w http.ResponseWriter, req *http.Request) {
+	account, ok := h.authenticateAccount(w, req)
+	if !ok {
+		return
+	}
+
+	page, pageSize, err := pagination(req)
+	if err != nil {
+		log.Printf("invalid pagination: %v", err)
+		util.SendResponse(w, nil, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	users, total, err := h.db.GetChatUsersByAccountID(account.ID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		log.Printf("failed to get chats: %v", err)
+		util.SendResponse(w, nil, "failed to get chats", http.StatusInternalServerError)
+
+		return
+	}
+
+	util.SendResponse(w, conversationList(users, total, page, pageSize), "success", http.StatusOK)
+}
+
+// conversationList builds one page of a conversation list.
+func conversationList(users []data.ChatUser, total, page, pageSize int) model.ConversationListResponse {
+	response := model.ConversationListResponse{
+		Conversations: make([]model.ConversationSummary, 0, len(users)),
+		Page:          page,
+		PageSize:      pageSize,
+		Total:         total,
+	}
+
+	for _, user := range users {
+		response.Conversations = append(response.Conversations, conversationSummary(&user))
+	}
+
+	return response
+}
+
+func conversationSummary(user *data.ChatUser) model.ConversationSummary {
+	summary := model.ConversationSummary{
+		ID:          user.ID,
+		ParentID:    user.ParentID,
+		ClassroomID: user.ClassroomID,
+		Language:    languageCode(user.Language),
+		Status:      string(user.Status),
+		CreatedAt:   user.CreatedAt,
+	}
+	if user.SubtitleLanguage != "" {
+		summary.SubtitleLanguage = languageCode(user.SubtitleLanguage)
+	}
+	if !user.EndedAt.IsZero() {
+		summary.EndedAt = util.Pointer(user.EndedAt)
+	}
+
+	return summary
+}
+
+// authenticateAccount resolves the account from the access token verified by
+// the TokenAuth middleware. It writes the error response itself and reports
+// whether the handler may continue.
+func (h *handler) authenticateAccount(w http.ResponseWriter, req *http.Request) (*data.Account, bool) {
+	claims, ok := requireClaims(w, req, auth.KindAccount)
+	if !ok {
+		return nil, false
+	}
+
+	return h.getAccount(w, claims.Subject)
+}
+
+// optionalAccount resolves the account when the request carries an account
+// access token or API key, so that a new conversation can be linked to it. A
+// request without credentials continues without an account.
+func (h *handler) optionalAccount(w http.ResponseWriter, req *http.Request) (*data.Account, bool) {
+	var claims auth.Claims
+	var err error
+	if key, ok := middleware.APIKey(req); ok {
+		claims, err = h.verifyAPIKey(key)
+	} else if token, ok := middleware.BearerToken(req); ok {
+		claims, err = h.verifyToken(token)
+	} else {
+		return nil, true
+	}
+
+	if err != nil {
+		log.Printf("failed to verify credentials: %v", err)
+		util.SendResponse(w, nil, err.Error(), http.StatusUnauthorized)
+
+		return nil, false
+	}
+
+	if claims.Kind != auth.KindAccount {
+		log.Printf("%s token used where an account token is required", claims.Kind)
+		util.SendResponse(w, nil, "access token does not grant access to this resource", http.StatusForbidden)
+
+		return nil, false
+	}
+
+	if !claims.Allows(auth.ScopeConversationsWrite) {
+		log.Printf("credentials lack the %s scope", auth.ScopeConversationsWrite)
+		util.SendResponse(w, nil, fmt.Sprintf("the %s scope is required", auth.ScopeConversationsWrite), http.StatusForbidden)
+
+		return nil, false
+	}
+
+	return h.getAccount(w, claims.Subject)
+}
+
+func (h *handler) getAccount(w http.ResponseWriter, id string) (*data.Account, bool) {
+	account, err := h.db.GetAccount(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("account %s not found", id)
+		util.SendResponse(w, nil, "account not found", http.StatusUnauthorized)
+
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("failed to get account: %v", err)
+		util.SendResponse(w, nil, "failed to get account", http.StatusInternalServerError)
+
+		return nil, false
+	}
+
+	return account, true
+}
+
+func (h *handler) login(w http.ResponseWriter, username, password string) (*data.Account, bool) {
+	if username == "" || password == "" {
+		log.Println("username or password is missing")
+		util.SendResponse(w, nil, "missing required authentication", http.StatusUnauthorized)
+
+		return nil, false
+	}
+
+	account, err := h.db.GetAccountByUsername(username)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("account %s not found", username)
+		util.SendResponse(w, nil, "invalid username or password", http.StatusUnauthorized)
+
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("failed to get account: %v", err)
+		util.SendResponse(w, nil, "failed to get account", http.StatusInternalServerError)
+
+		return nil, false
+	}
+
+	if err := util.CompareHash(password, account.Password); err != nil {
+		log.Println("invalid account password")
+		util.SendResponse(w, nil, "invalid username or password", http.StatusUnauthorized)
+
+		return nil, false
+	}
+
+	return account, true
+}
+
+func validateCredentials(username, password string) error {
+	if len(username) < minUsernameLength || len(username) > maxUsernameLength {
+		return fmt.Errorf("username must be between %d and %d characters", minUsernameLength, maxUsernameLength)
+	}
+
+	if strings.ContainsAny(username, ": \t\r\n") {
+		return fmt.Errorf("username cannot contain spaces or colons")
+	}
+
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+
+	return nil
+}
+
+// pagination reads ?page= and ?pageSize=, defaulting to the first page.
+func pagination(req *http.Request) (int, int, error) {
+	page, pageSize := 1, defaultPageSize
+
+	if value := req.URL.Query().Get("page"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			return 0, 0, fmt.Errorf("page must be a positive number")
+		}
+		page = parsed
+	}
+
+	if value := req.URL.Query().Get("pageSize"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxPageSize {
+			return 0, 0, fmt.Errorf("pageSize must be between 1 and %d", maxPageSize)
+		}
+		pageSize = parsed
+	}
+
+	return page, pageSize, nil
+}
@@ -0,0 +1,460 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/madeindra/mock-conversation/server/internal/config"
+	"github.com/madeindra/mock-conversation/server/internal/data"
+	"github.com/madeindra/mock-conversation/server/internal/model"
+	"github.com/madeindra/mock-conversation/server/internal/util"
+)
+
+const (
+	// outcomeFlushInterval is how often request outcomes counted in memory
+	// are stored, and so how stale the request stats may be
+	outcomeFlushInterval = 10 * time.Second
+
+	defaultStatsDays = 30
+	maxStatsDays     = 366
+)
+
+// ListConversations searches every conversation on the server, newest first,
+// one page at a time. The search is narrowed with ?q= matching a
+// conversation's ID or the text of its entries, ?accountId=, ?username=,
+// ?language=, ?status=, and ?from= and ?to= as inclusive UTC dates. Only
+// admins may do so.
+func (h *handler) ListConversations(w http.ResponseWriter, req *http.Request) {
+	if _, ok := h.authenticateAdmin(w, req); !ok {
+		return
+	}
+
+	page, pageSize, err := pagination(req)
+	if err != nil {
+		log.Printf("invalid pagination: %v", err)
+		util.SendResponse(w, nil, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	filter, err := conversationFilter(req)
+	if err != nil {
+		log.Printf("invalid conversation filter: %v", err)
+		util.SendResponse(w, nil, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	overviews, total, err := h.db.SearchChatUsers(filter, pageSize, (page-1)*pageSize)
+	if err != nil {
+		log.Printf("failed to search chats: %v", err)
+		util.SendResponse(w, nil, "failed to get chats", http.StatusInternalServerError)
+
+		return
+	}
+
+	response := model.AdminConversationListResponse{
+		Conversations: make([]model.AdminConversationSummary, 0, len(overviews)),
+		Page:          page,
+		PageSize:      pageSize,
+		Total:         total,
+	}
+
+	for i := range overviews {
+		response.Conversations = append(response.Conversations, adminConversationSummary(&overviews[i]))
+	}
+
+	util.SendResponse(w, response, "success", http.StatusOK)
+}
+
+// ConversationTranscript returns the whole conversation given with ?id=,
+// including the system prompt and the summary of older turns. Audio is left
+// out unless requested with ?audio=true. Only admins may see it.
+func (h *handler) ConversationTranscript(w http.ResponseWriter, req *http.Request) {
+	if _, ok := h.authenticateAdmin(w, req); !ok {
+		return
+	}
+
+	query := req.URL.Query()
+	includeAudio, _ := strconv.ParseBool(query.Get("audio"))
+
+	overview, err := h.db.GetChatUserOverview(query.Get("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("chat %s not found", query.Get("id"))
+		util.SendResponse(w, nil, "chat not found", http.StatusNotFound)
+
+		return
+	}
+	if err != nil {
+		log.Printf("failed to get chat user: %v", err)
+		util.SendResponse(w, nil, "failed to get chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	entries, err := h.db.GetChatsByChatUserID(overview.ID)
+	if err != nil {
+		log.Printf("failed to get chat: %v", err)
+		util.SendResponse(w, nil, "failed to get chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	objectives, err := h.db.GetObjectivesByChatUserID(overview.ID)
+	if err != nil {
+		log.Printf("failed to get objectives: %v", err)
+		util.SendResponse(w, nil, "failed to get chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	characters, err := h.db.GetCharactersByChatUserID(overview.ID)
+	if err != nil {
+		log.Printf("failed to get characters: %v", err)
+		util.SendResponse(w, nil, "failed to get chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	transcript := make([]model.HistoryEntry, 0, len(entries))
+	for _, entry := range entries {
+		chat := model.Chat{
+			ID:              entry.ID,
+			Text:            entry.Text,
+			Subtitle:        entry.Subtitle,
+			Transliteration: entry.Transliteration,
+			Speaker:         util.CharacterName(util.CharacterByID(characters, entry.CharacterID)),
+		}
+		if includeAudio {
+			chat.Audio = entry.Audio
+		}
+
+		transcript = append(transcript, historyEntry(entry, chat))
+	}
+
+	response := model.TranscriptResponse{
+		AdminConversationSummary: adminConversationSummary(overview),
+		Transliteration:          overview.Transliteration,
+		Limits:                   util.ConvertToLimits(&overview.ChatUser),
+		Summary:                  overview.Summary,
+		Objectives:               util.ConvertToObjectives(objectives),
+		Characters:               util.ConvertToCharacters(characters),
+		Entries:                  transcript,
+	}
+
+	util.SendResponse(w, response, "success", http.StatusOK)
+}
+
+// DeleteConversation removes a conversation with its entries, objectives,
+// characters and sessions for good. Conversations forked from it keep their
+// own history and become conversations of their own. Only admins may do so.
+func (h *handler) DeleteConversation(w http.ResponseWriter, req *http.Request) {
+	account, ok := h.authenticateAdmin(w, req)
+	if !ok {
+		return
+	}
+
+	var deleteRequest model.DeleteConversationRequest
+	if err := json.NewDecoder(req.Body).Decode(&deleteRequest); err != nil {
+		log.Printf("failed to read delete request body: %v", err)
+		util.SendResponse(w, nil, "failed to read request", http.StatusBadRequest)
+
+		return
+	}
+
+	tx, err := h.db.BeginTx()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+		util.SendResponse(w, nil, "failed to delete chat", http.StatusInternalServerError)
+
+		return
+	}
+	defer tx.Rollback()
+
+	err = h.db.DeleteChatUser(tx, deleteRequest.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("chat %s not found", deleteRequest.ID)
+		util.SendResponse(w, nil, "chat not found", http.StatusNotFound)
+
+		return
+	}
+	if err != nil {
+		log.Printf("failed to delete chat: %v", err)
+		util.SendResponse(w, nil, "failed to delete chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+		util.SendResponse(w, nil, "failed to delete chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	log.Printf("admin %s deleted chat %s", account.Username, deleteRequest.ID)
+
+	util.SendResponse(w, nil, "chat deleted", http.StatusOK)
+}
+
+// Stats sums up the conversations started over the last ?days= UTC days,
+// today included, and the requests the server answered over them, with their
+// error rates. Only admins may see them.
+func (h *handler) Stats(w http.ResponseWriter, req *http.Request) {
+	if _, ok := h.authenticateAdmin(w, req); !ok {
+		return
+	}
+
+	days := defaultStatsDays
+	if value := req.URL.Query().Get("days"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxStatsDays {
+			log.Printf("invalid stats days %q", value)
+			util.SendResponse(w, nil, fmt.Sprintf("days must be between 1 and %d", maxStatsDays), http.StatusBadRequest)
+
+			return
+		}
+		days = parsed
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	since := today.AddDate(0, 0, 1-days)
+
+	conversations, err := h.db.GetConversationStats(since)
+	if err != nil {
+		log.Printf("failed to get conversation stats: %v", err)
+		util.SendResponse(w, nil, "failed to get stats", http.StatusInternalServerError)
+
+		return
+	}
+
+	outcomes, err := h.db.GetRequestOutcomes(since)
+	if err != nil {
+		log.Printf("failed to get request outcomes: %v", err)
+		util.SendResponse(w, nil, "failed to get stats", http.StatusInternalServerError)
+
+		return
+	}
+
+	response := model.StatsResponse{
+		Since:         since,
+		Conversations: conversations.Conversations,
+		AverageTurns:  conversations.AverageTurns,
+		PerDay:        make([]model.DailyCount, 0, days),
+		Languages:     make([]model.LanguageCount, 0, len(conversations.Languages)),
+		Statuses:      make([]model.StatusCount, 0, len(conversations.Statuses)),
+	}
+
+	// Every day of the period is listed, including those without any
+	// conversation
+	perDay := make(map[string]int, len(conversations.PerDay))
+	for _, count := range conversations.PerDay {
+		perDay[count.Key] = count.Count
+	}
+	for day := since; !day.After(today); day = day.AddDate(0, 0, 1) {
+		key := day.Format(time.DateOnly)
+		response.PerDay = append(response.PerDay, model.DailyCount{Day: key, Conversations: perDay[key]})
+	}
+
+	for _, count := range conversations.Languages {
+		response.Languages = append(response.Languages, model.LanguageCount{Language: languageCode(count.Key), Conversations: count.Count})
+	}
+
+	for _, count := range conversations.Statuses {
+		response.Statuses = append(response.Statuses, model.StatusCount{Status: count.Key, Conversations: count.Count})
+	}
+
+	for _, outcome := range outcomes {
+		route := requestOutcome(outcome)
+		response.Requests.Routes = append(response.Requests.Routes, route)
+		response.Requests.Requests += route.Requests
+		response.Requests.ClientErrors += route.ClientErrors
+		response.Requests.ServerErrors += route.ServerErrors
+	}
+	response.Requests.ErrorRate = errorRate(response.Requests.ServerErrors, response.Requests.Requests)
+
+	util.SendResponse(w, response, "success", http.StatusOK)
+}
+
+// outcomeKey names the counts of a route on a UTC day.
+type outcomeKey struct {
+	day   time.Time
+	route string
+}
+
+// outcomeCounter counts request outcomes in memory, so that answering a
+// request does not wait on a write. Counts not yet flushed are lost when the
+// server stops.
+type outcomeCounter struct {
+	mu     sync.Mutex
+	counts map[outcomeKey]*data.RequestOutcome
+}
+
+func newOutcomeCounter() *outcomeCounter {
+	return &outcomeCounter{counts: make(map[outcomeKey]*data.RequestOutcome)}
+}
+
+func (c *outcomeCounter) add(day time.Time, route string, status int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := outcomeKey{day: day, route: route}
+	outcome, ok := c.counts[key]
+	if !ok {
+		outcome = &data.RequestOutcome{Route: route}
+		c.counts[key] = outcome
+	}
+	outcome.Add(status)
+}
+
+// take returns the counts so far and starts counting afresh.
+func (c *outcomeCounter) take() []data.DailyRequestOutcome {
+	c.mu.Lock()
+	counts := c.counts
+	c.counts = make(map[outcomeKey]*data.RequestOutcome)
+	c.mu.Unlock()
+
+	outcomes := make([]data.DailyRequestOutcome, 0, len(counts))
+	for key, outcome := range counts {
+		outcomes = append(outcomes, data.DailyRequestOutcome{Day: key.day, RequestOutcome: *outcome})
+	}
+
+	return outcomes
+}
+
+// putBack returns counts that could not be stored, to be flushed again.
+func (c *outcomeCounter) putBack(outcomes []data.DailyRequestOutcome) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, outcome := range outcomes {
+		key := outcomeKey{day: outcome.Day, route: outcome.Route}
+		counted, ok := c.counts[key]
+		if !ok {
+			counted = &data.RequestOutcome{Route: outcome.Route}
+			c.counts[key] = counted
+		}
+		counted.Requests += outcome.Requests
+		counted.ClientErrors += outcome.ClientErrors
+		counted.ServerErrors += outcome.ServerErrors
+	}
+}
+
+// recordOutcome counts a request towards the stats of the current UTC day,
+// to be stored on the next flush.
+func (h *handler) recordOutcome(route string, status int) {
+	now := time.Now().UTC()
+	h.outcomes.add(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC), route, status)
+}
+
+// flushOutcomes stores the counted request outcomes every
+// outcomeFlushInterval. Counts that fail to store are kept for the next try.
+func (h *handler) flushOutcomes() {
+	ticker := time.NewTicker(outcomeFlushInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		outcomes := h.outcomes.take()
+		if len(outcomes) == 0 {
+			continue
+		}
+
+		if err := h.storeOutcomes(outcomes); err != nil {
+			log.Printf("failed to record request outcomes: %v", err)
+			h.outcomes.putBack(outcomes)
+		}
+	}
+}
+
+func (h *handler) storeOutcomes(outcomes []data.DailyRequestOutcome) error {
+	tx, err := h.db.BeginTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := h.db.RecordRequestOutcomes(tx, outcomes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// conversationFilter reads the search of ListConversations from the query.
+func conversationFilter(req *http.Request) (data.ChatUserFilter, error) {
+	query := req.URL.Query()
+
+	filter := data.ChatUserFilter{
+		AccountID: query.Get("accountId"),
+		Username:  strings.TrimSpace(query.Get("username")),
+		Status:    data.Status(query.Get("status")),
+		Text:      strings.TrimSpace(query.Get("q")),
+	}
+
+	if filter.Status != "" && !filter.Status.IsValid() {
+		return filter, fmt.Errorf("status must be one of %s, %s, %s and %s", data.StatusActive, data.StatusEnding, data.StatusEnded, data.StatusAbandoned)
+	}
+
+	if tag := query.Get("language"); tag == config.AutoLanguage {
+		filter.Language = config.AutoLanguage
+	} else if tag != "" {
+		language, ok := config.DetectLanguage(tag)
+		if !ok {
+			return filter, fmt.Errorf("language %s is not supported", tag)
+		}
+		filter.Language = language
+	}
+
+	if value := query.Get("from"); value != "" {
+		from, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return filter, fmt.Errorf("from must be a date such as 2024-01-31")
+		}
+		filter.Since = from
+	}
+
+	if value := query.Get("to"); value != "" {
+		to, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			return filter, fmt.Errorf("to must be a date such as 2024-01-31")
+		}
+		filter.Until = to.AddDate(0, 0, 1)
+	}
+
+	return filter, nil
+}
+
+func adminConversationSummary(overview *data.ChatUserOverview) model.AdminConversationSummary {
+	return model.AdminConversationSummary{
+		ConversationSummary: conversationSummary(&overview.ChatUser),
+		AccountID:           overview.AccountID,
+		Username:            overview.Username,
+		Turns:               overview.Turns,
+	}
+}
+
+func requestOutcome(outcome data.RequestOutcome) model.RequestOutcomeResponse {
+	return model.RequestOutcomeResponse{
+		Route:        outcome.Route,
+		Requests:     outcome.Requests,
+		ClientErrors: outcome.ClientErrors,
+		ServerErrors: outcome.ServerErrors,
+		ErrorRate:    errorRate(outcome.ServerErrors, outcome.Requests),
+	}
+}
+
+func errorRate(failed, requests int) float64 {
+	if requests == 0 {
+		return 0
+	}
+
+	return float64(failed) / float64(requests)
+}
@@ -0,0 +1,264 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/madeindra/mock-conversation/server/internal/auth"
+	"github.com/madeindra/mock-conversation/server/internal/data"
+	"github.com/madeindra/mock-conversation/server/internal/model"
+	"github.com/madeindra/mock-conversation/server/internal/util"
+)
+
+const (
+	apiKeySize          = 32
+	apiKeyPrefixLength  = 12
+	maxAPIKeyNameLength = 64
+
+	// apiKeyTouchInterval is how stale a key's last-used time may get before
+	// a request updates it
+	apiKeyTouchInterval = time.Minute
+)
+
+var errInvalidAPIKey = errors.New("invalid API key")
+
+// CreateAPIKey issues an API key for the account with the requested scopes.
+// The key is only returned here; the server keeps its hash.
+func (h *handler) CreateAPIKey(w http.ResponseWriter, req *http.Request) {
+	account, ok := h.authenticateManager(w, req)
+	if !ok {
+		return
+	}
+
+	var keyRequest model.APIKeyRequest
+	if err := json.NewDecoder(req.Body).Decode(&keyRequest); err != nil {
+		log.Printf("failed to read API key request body: %v", err)
+		util.SendResponse(w, nil, "failed to read request", http.StatusBadRequest)
+
+		return
+	}
+
+	name := strings.TrimSpace(keyRequest.Name)
+	if name == "" || len(name) > maxAPIKeyNameLength {
+		log.Printf("invalid API key name %q", name)
+		util.SendResponse(w, nil, fmt.Sprintf("name must be between 1 and %d characters", maxAPIKeyNameLength), http.StatusBadRequest)
+
+		return
+	}
+
+	scopes, err := auth.ParseScopes(keyRequest.Scopes)
+	if err != nil || len(scopes) == 0 {
+		log.Printf("invalid API key scopes %v: %v", keyRequest.Scopes, err)
+		util.SendResponse(w, nil, "scopes must list at least one of conversations:write, reports:read and admin", http.StatusBadRequest)
+
+		return
+	}
+
+	for _, scope := range scopes {
+		if scope == auth.ScopeAdmin && !h.isAdmin(account) {
+			log.Printf("account %s cannot grant the admin scope", account.ID)
+			util.SendResponse(w, nil, "only administrators can create keys with the admin scope", http.StatusForbidden)
+
+			return
+		}
+	}
+
+	key := auth.APIKeyPrefix + util.GenerateToken(apiKeySize)
+
+	tx, err := h.db.BeginTx()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+		util.SendResponse(w, nil, "failed to create API key", http.StatusInternalServerError)
+
+		return
+	}
+	defer tx.Rollback()
+
+	scopeNames := make([]string, len(scopes))
+	for i, scope := range scopes {
+		scopeNames[i] = string(scope)
+	}
+
+	apiKey, err := h.db.CreateAPIKey(tx, data.APIKey{
+		AccountID: account.ID,
+		Name:      name,
+		Prefix:    key[:apiKeyPrefixLength],
+		KeyHash:   util.HashToken(key),
+		Scopes:    scopeNames,
+	})
+	if err != nil {
+		log.Printf("failed to create API key: %v", err)
+		util.SendResponse(w, nil, "failed to create API key", http.StatusInternalServerError)
+
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+		util.SendResponse(w, nil, "failed to create API key", http.StatusInternalServerError)
+
+		return
+	}
+
+	response := model.CreateAPIKeyResponse{
+		APIKeyResponse: util.ConvertToAPIKey(apiKey),
+		Key:            key,
+	}
+
+	util.SendResponse(w, response, "API key created", http.StatusOK)
+}
+
+// ListAPIKeys returns the account's API keys, including revoked ones, without
+// the keys themselves.
+func (h *handler) ListAPIKeys(w http.ResponseWriter, req *http.Request) {
+	account, ok := h.authenticateManager(w, req)
+	if !ok {
+		return
+	}
+
+	keys, err := h.db.GetAPIKeysByAccountID(account.ID)
+	if err != nil {
+		log.Printf("failed to get API keys: %v", err)
+		util.SendResponse(w, nil, "failed to get API keys", http.StatusInternalServerError)
+
+		return
+	}
+
+	response := make([]model.APIKeyResponse, len(keys))
+	for i := range keys {
+		response[i] = util.ConvertToAPIKey(&keys[i])
+	}
+
+	util.SendResponse(w, response, "success", http.StatusOK)
+}
+
+// RevokeAPIKey stops one of the account's API keys from being accepted.
+func (h *handler) RevokeAPIKey(w http.ResponseWriter, req *http.Request) {
+	account, ok := h.authenticateManager(w, req)
+	if !ok {
+		return
+	}
+
+	var revokeRequest model.RevokeAPIKeyRequest
+	if err := json.NewDecoder(req.Body).Decode(&revokeRequest); err != nil {
+		log.Printf("failed to read revoke API key request body: %v", err)
+		util.SendResponse(w, nil, "failed to read request", http.StatusBadRequest)
+
+		return
+	}
+
+	tx, err := h.db.BeginTx()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+		util.SendResponse(w, nil, "failed to revoke API key", http.StatusInternalServerError)
+
+		return
+	}
+	defer tx.Rollback()
+
+	err = h.db.RevokeAPIKey(tx, account.ID, revokeRequest.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("active API key %s not found", revokeRequest.ID)
+		util.SendResponse(w, nil, "API key not found", http.StatusNotFound)
+
+		return
+	}
+	if err != nil {
+		log.Printf("failed to revoke API key: %v", err)
+		util.SendResponse(w, nil, "failed to revoke API key", http.StatusInternalServerError)
+
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+		util.SendResponse(w, nil, "failed to revoke API key", http.StatusInternalServerError)
+
+		return
+	}
+
+	util.SendResponse(w, nil, "API key revoked", http.StatusOK)
+}
+
+// authenticateManager resolves the account for requests that manage API keys
+// or organizations. These are allowed from a login session or with a key
+// holding the admin scope, but not with lesser keys. It writes the error
+// response itself and reports whether the handler may continue.
+func (h *handler) authenticateManager(w http.ResponseWriter, req *http.Request) (*data.Account, bool) {
+	claims, ok := requireClaims(w, req, auth.KindAccount)
+	if !ok {
+		return nil, false
+	}
+
+	if claims.KeyID != "" && !claims.Allows(auth.ScopeAdmin) {
+		log.Printf("API key %s lacks the admin scope", claims.KeyID)
+		util.SendResponse(w, nil, "the admin scope is required", http.StatusForbidden)
+
+		return nil, false
+	}
+
+	return h.getAccount(w, claims.Subject)
+}
+
+// authenticateAdmin is authenticateManager for requests only the configured
+// administrators may make.
+func (h *handler) authenticateAdmin(w http.ResponseWriter, req *http.Request) (*data.Account, bool) {
+	account, ok := h.authenticateManager(w, req)
+	if !ok {
+		return nil, false
+	}
+
+	if !h.isAdmin(account) {
+		log.Printf("account %s is not an admin", account.ID)
+		util.SendResponse(w, nil, "only admins can do this", http.StatusForbidden)
+
+		return nil, false
+	}
+
+	return account, true
+}
+
+// verifyAPIKey looks up the key by its hash and returns claims for the
+// account that owns it, limited to the key's scopes.
+func (h *handler) verifyAPIKey(key string) (auth.Claims, error) {
+	apiKey, err := h.db.GetAPIKeyByHash(util.HashToken(key))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("failed to get API key: %v", err)
+		}
+		return auth.Claims{}, errInvalidAPIKey
+	}
+
+	if !apiKey.RevokedAt.IsZero() {
+		return auth.Claims{}, errInvalidAPIKey
+	}
+
+	// Failing to record the use is not a reason to reject the request
+	if err := h.db.TouchAPIKey(apiKey.ID, apiKeyTouchInterval); err != nil {
+		log.Printf("failed to record API key use: %v", err)
+	}
+
+	scopes, err := auth.ParseScopes(apiKey.Scopes)
+	if err != nil {
+		log.Printf("API key %s has invalid scopes: %v", apiKey.ID, err)
+		return auth.Claims{}, errInvalidAPIKey
+	}
+
+	return auth.Claims{
+		Subject: apiKey.AccountID,
+		Kind:    auth.KindAccount,
+		KeyID:   apiKey.ID,
+		Scopes:  scopes,
+	}, nil
+}
+
+// isAdmin reports whether the account is one of the configured administrators.
+func (h *handler) isAdmin(account *data.Account) bool {
+	return h.admins[account.ID]
+}
@@ -0,0 +1,345 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/madeindra/mock-conversation/server/internal/auth"
+	"github.com/madeindra/mock-conversation/server/internal/data"
+	"github.com/madeindra/mock-conversation/server/internal/middleware"
+	"github.com/madeindra/mock-conversation/server/internal/model"
+	"github.com/madeindra/mock-conversation/server/internal/util"
+)
+
+// refreshTokenSize is the number of random bytes in a refresh token.
+const refreshTokenSize = 32
+
+// IssueToken exchanges a conversation's ID and secret for an access token and
+// a refresh token. The secret is checked once here rather than on every turn.
+func (h *handler) IssueToken(w http.ResponseWriter, req *http.Request) {
+	var tokenRequest model.TokenRequest
+	if err := json.NewDecoder(req.Body).Decode(&tokenRequest); err != nil {
+		log.Printf("failed to read token request body: %v", err)
+		util.SendResponse(w, nil, "failed to read request", http.StatusBadRequest)
+
+		return
+	}
+
+	if tokenRequest.ID == "" || tokenRequest.Secret == "" {
+		log.Println("user ID or secret is missing")
+		util.SendResponse(w, nil, "missing required authentication", http.StatusUnauthorized)
+
+		return
+	}
+
+	user, err := h.db.GetChatUser(tokenRequest.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("chat user %s not found", tokenRequest.ID)
+		util.SendResponse(w, nil, "invalid user ID or secret", http.StatusUnauthorized)
+
+		return
+	}
+	if err != nil {
+		log.Printf("failed to get chat user: %v", err)
+		util.SendResponse(w, nil, "failed to get chat user", http.StatusInternalServerError)
+
+		return
+	}
+
+	if err := util.CompareHash(tokenRequest.Secret, user.Secret); err != nil {
+		log.Println("invalid user secret")
+		util.SendResponse(w, nil, "invalid user ID or secret", http.StatusUnauthorized)
+
+		return
+	}
+
+	tokens, ok := h.startSession(w, auth.KindChat, user.ID)
+	if !ok {
+		return
+	}
+
+	util.SendResponse(w, tokens, "success", http.StatusOK)
+}
+
+// RefreshToken trades a refresh token for a new access token and refresh
+// token. Each refresh token works once; presenting one that was already used
+// revokes its session, since it has likely been stolen.
+func (h *handler) RefreshToken(w http.ResponseWriter, req *http.Request) {
+	session, refreshToken, ok := h.readRefreshToken(w, req)
+	if !ok {
+		return
+	}
+
+	if !util.CompareToken(refreshToken, session.RefreshHash) {
+		log.Printf("refresh token reused for session %s", session.ID)
+		h.revokeSession(session.ID)
+		util.SendResponse(w, nil, "invalid refresh token", http.StatusUnauthorized)
+
+		return
+	}
+
+	newRefreshToken := session.ID + "." + util.GenerateToken(refreshTokenSize)
+
+	tx, err := h.db.BeginTx()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+		util.SendResponse(w, nil, "failed to refresh token", http.StatusInternalServerError)
+
+		return
+	}
+	defer tx.Rollback()
+
+	err = h.db.RotateSession(tx, session.ID, session.RefreshHash, util.HashToken(newRefreshToken), time.Now().Add(h.refreshTTL))
+	if errors.Is(err, data.ErrInvalidSession) {
+		log.Printf("session %s changed during refresh", session.ID)
+		util.SendResponse(w, nil, "invalid refresh token", http.StatusUnauthorized)
+
+		return
+	}
+	if err != nil {
+		log.Printf("failed to rotate session: %v", err)
+		util.SendResponse(w, nil, "failed to refresh token", http.StatusInternalServerError)
+
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+		util.SendResponse(w, nil, "failed to refresh token", http.StatusInternalServerError)
+
+		return
+	}
+
+	accessToken, expiresAt, err := h.tokens.Sign(auth.Kind(session.Kind), session.SubjectID, session.ID)
+	if err != nil {
+		log.Printf("failed to sign access token: %v", err)
+		util.SendResponse(w, nil, "failed to refresh token", http.StatusInternalServerError)
+
+		return
+	}
+
+	response := model.TokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: newRefreshToken,
+		ExpiresAt:    expiresAt.UTC(),
+	}
+
+	util.SendResponse(w, response, "success", http.StatusOK)
+}
+
+// RevokeToken ends the session of a refresh token, so neither it nor the
+// access tokens issued with it are accepted any more.
+func (h *handler) RevokeToken(w http.ResponseWriter, req *http.Request) {
+	session, refreshToken, ok := h.readRefreshToken(w, req)
+	if !ok {
+		return
+	}
+
+	if !util.CompareToken(refreshToken, session.RefreshHash) {
+		log.Printf("invalid refresh token for session %s", session.ID)
+		util.SendResponse(w, nil, "invalid refresh token", http.StatusUnauthorized)
+
+		return
+	}
+
+	tx, err := h.db.BeginTx()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+		util.SendResponse(w, nil, "failed to revoke token", http.StatusInternalServerError)
+
+		return
+	}
+	defer tx.Rollback()
+
+	if err := h.db.RevokeSession(tx, session.ID); err != nil {
+		log.Printf("failed to revoke session: %v", err)
+		util.SendResponse(w, nil, "failed to revoke token", http.StatusInternalServerError)
+
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+		util.SendResponse(w, nil, "failed to revoke token", http.StatusInternalServerError)
+
+		return
+	}
+
+	util.SendResponse(w, nil, "token revoked", http.StatusOK)
+}
+
+// readRefreshToken reads the refresh token from the request body and loads
+// its session, which must still be active. It writes the error response
+// itself and reports whether the handler may continue.
+func (h *handler) readRefreshToken(w http.ResponseWriter, req *http.Request) (*data.Session, string, bool) {
+	var refreshRequest model.RefreshTokenRequest
+	if err := json.NewDecoder(req.Body).Decode(&refreshRequest); err != nil {
+		log.Printf("failed to read refresh token request body: %v", err)
+		util.SendResponse(w, nil, "failed to read request", http.StatusBadRequest)
+
+		return nil, "", false
+	}
+
+	// The refresh token is prefixed with its session ID so that the session
+	// can be found without scanning every stored hash
+	sessionID, _, found := strings.Cut(refreshRequest.RefreshToken, ".")
+	if !found || sessionID == "" {
+		log.Println("malformed refresh token")
+		util.SendResponse(w, nil, "invalid refresh token", http.StatusUnauthorized)
+
+		return nil, "", false
+	}
+
+	session, err := h.db.GetSession(sessionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("session %s not found", sessionID)
+		util.SendResponse(w, nil, "invalid refresh token", http.StatusUnauthorized)
+
+		return nil, "", false
+	}
+	if err != nil {
+		log.Printf("failed to get session: %v", err)
+		util.SendResponse(w, nil, "failed to get session", http.StatusInternalServerError)
+
+		return nil, "", false
+	}
+
+	if !session.IsActive(time.Now()) {
+		log.Printf("session %s is no longer active", session.ID)
+		util.SendResponse(w, nil, "refresh token has expired or was revoked", http.StatusUnauthorized)
+
+		return nil, "", false
+	}
+
+	return session, refreshRequest.RefreshToken, true
+}
+
+// startSession opens a session for the subject and issues its first access
+// and refresh tokens. It writes the error response itself and reports whether
+// the handler may continue.
+func (h *handler) startSession(w http.ResponseWriter, kind auth.Kind, subjectID string) (model.TokenResponse, bool) {
+	tx, err := h.db.BeginTx()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+		util.SendResponse(w, nil, "failed to issue token", http.StatusInternalServerError)
+
+		return model.TokenResponse{}, false
+	}
+	defer tx.Rollback()
+
+	tokens, err := h.createSession(tx, kind, subjectID)
+	if err != nil {
+		log.Printf("failed to create session: %v", err)
+		util.SendResponse(w, nil, "failed to issue token", http.StatusInternalServerError)
+
+		return model.TokenResponse{}, false
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+		util.SendResponse(w, nil, "failed to issue token", http.StatusInternalServerError)
+
+		return model.TokenResponse{}, false
+	}
+
+	return tokens, true
+}
+
+// createSession stores a session for the subject within the transaction and
+// returns its first access and refresh tokens.
+func (h *handler) createSession(tx *sql.Tx, kind auth.Kind, subjectID string) (model.TokenResponse, error) {
+	sessionID := data.NewSessionID()
+	refreshToken := sessionID + "." + util.GenerateToken(refreshTokenSize)
+
+	_, err := h.db.CreateSession(tx, data.Session{
+		ID:          sessionID,
+		Kind:        string(kind),
+		SubjectID:   subjectID,
+		RefreshHash: util.HashToken(refreshToken),
+		ExpiresAt:   time.Now().Add(h.refreshTTL),
+	})
+	if err != nil {
+		return model.TokenResponse{}, err
+	}
+
+	accessToken, expiresAt, err := h.tokens.Sign(kind, subjectID, sessionID)
+	if err != nil {
+		return model.TokenResponse{}, err
+	}
+
+	return model.TokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresAt:    expiresAt.UTC(),
+	}, nil
+}
+
+// verifyToken checks an access token's signature and expiry, then that its
+// session has not been revoked, which is a single lookup by primary key.
+func (h *handler) verifyToken(token string) (auth.Claims, error) {
+	claims, err := h.tokens.Verify(token)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+
+	session, err := h.db.GetSession(claims.SessionID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("failed to get session: %v", err)
+		}
+		return auth.Claims{}, auth.ErrInvalidToken
+	}
+
+	if !session.IsActive(time.Now()) || session.SubjectID != claims.Subject || session.Kind != string(claims.Kind) {
+		return auth.Claims{}, auth.ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// requireClaims returns the claims TokenAuth verified, which must be of the
+// given kind. It writes the error response itself and reports whether the
+// handler may continue.
+func requireClaims(w http.ResponseWriter, req *http.Request, kind auth.Kind) (auth.Claims, bool) {
+	claims, ok := middleware.Claims(req.Context())
+	if !ok {
+		log.Println("access token is missing")
+		util.SendResponse(w, nil, "missing required authentication", http.StatusUnauthorized)
+
+		return auth.Claims{}, false
+	}
+
+	if claims.Kind != kind {
+		log.Printf("%s token used where a %s token is required", claims.Kind, kind)
+		util.SendResponse(w, nil, "access token does not grant access to this resource", http.StatusForbidden)
+
+		return auth.Claims{}, false
+	}
+
+	return claims, true
+}
+
+// revokeSession ends a session outside of a request's own transaction. Failing
+// to do so is logged but not reported, as the request fails either way.
+func (h *handler) revokeSession(id string) {
+	tx, err := h.db.BeginTx()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := h.db.RevokeSession(tx, id); err != nil {
+		log.Printf("failed to revoke session: %v", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+	}
+}
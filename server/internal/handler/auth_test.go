@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/madeindra/mock-conversation/server/internal/auth"
+	"github.com/madeindra/mock-conversation/server/internal/middleware"
+)
+
+func TestRequireClaims(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims *auth.Claims
+		kind   auth.Kind
+		ok     bool
+		status int
+	}{
+		{name: "account token for an account", claims: &auth.Claims{Kind: auth.KindAccount, Subject: "account-1"}, kind: auth.KindAccount, ok: true, status: http.StatusOK},
+		{name: "chat token for a chat", claims: &auth.Claims{Kind: auth.KindChat, Subject: "chat-1"}, kind: auth.KindChat, ok: true, status: http.StatusOK},
+		{name: "chat token for an account", claims: &auth.Claims{Kind: auth.KindChat, Subject: "chat-1"}, kind: auth.KindAccount, status: http.StatusForbidden},
+		{name: "account token for a chat", claims: &auth.Claims{Kind: auth.KindAccount, Subject: "account-1"}, kind: auth.KindChat, status: http.StatusForbidden},
+		{name: "no token", kind: auth.KindAccount, status: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.claims != nil {
+				req = req.WithContext(context.WithValue(req.Context(), middleware.ContextKeyClaims, *tt.claims))
+			}
+
+			w := httptest.NewRecorder()
+
+			claims, ok := requireClaims(w, req, tt.kind)
+			if ok != tt.ok {
+				t.Fatalf("requireClaims() ok = %v, want %v", ok, tt.ok)
+			}
+			if w.Code != tt.status {
+				t.Errorf("requireClaims() status = %d, want %d", w.Code, tt.status)
+			}
+			if ok && claims.Subject != tt.claims.Subject {
+				t.Errorf("requireClaims() subject = %q, want %q", claims.Subject, tt.claims.Subject)
+			}
+		})
+	}
+}
@@ -0,0 +1,428 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/madeindra/mock-conversation/server/internal/config"
+	"github.com/madeindra/mock-conversation/server/internal/data"
+	"github.com/madeindra/mock-conversation/server/internal/middleware"
+	"github.com/madeindra/mock-conversation/server/internal/model"
+	"github.com/madeindra/mock-conversation/server/internal/openai"
+	"github.com/madeindra/mock-conversation/server/internal/util"
+)
+
+// budgetWarningHeader carries a warning on responses once a budget nears its
+// limit, or has been exceeded and the cheaper models are used
+const budgetWarningHeader = "X-Budget-Warning"
+
+// Budget reports how much of each budget that applies to the account is
+// left: its own and those of the organizations it belongs to.
+func (h *handler) Budget(w http.ResponseWriter, req *http.Request) {
+	account, ok := h.authenticateAccount(w, req)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+
+	budgets, err := h.budgets(account.ID, now)
+	if err != nil {
+		log.Printf("failed to get budgets: %v", err)
+		util.SendResponse(w, nil, "failed to get budget", http.StatusInternalServerError)
+
+		return
+	}
+
+	response := make([]model.BudgetResponse, len(budgets))
+	for i, budget := range budgets {
+		if budget.Subject == data.BudgetAccount {
+			budget.Name = account.Username
+		}
+		response[i] = util.ConvertToBudget(budget, now, h.budgetLimits.WarnPercent)
+	}
+
+	util.SendResponse(w, response, "success", http.StatusOK)
+}
+
+// SetBudget replaces the server's default budget for a single account or
+// organization. Only admins may do so.
+func (h *handler) SetBudget(w http.ResponseWriter, req *http.Request) {
+	if _, ok := h.authenticateAdmin(w, req); !ok {
+		return
+	}
+
+	var budgetRequest model.BudgetRequest
+	if err := json.NewDecoder(req.Body).Decode(&budgetRequest); err != nil {
+		log.Printf("failed to read budget request body: %v", err)
+		util.SendResponse(w, nil, "failed to read request", http.StatusBadRequest)
+
+		return
+	}
+
+	budget := data.Budget{
+		DailyCost:    int64(math.Round(budgetRequest.DailyCost * 1e6)),
+		MonthlyCost:  int64(math.Round(budgetRequest.MonthlyCost * 1e6)),
+		DailyTurns:   budgetRequest.DailyTurns,
+		MonthlyTurns: budgetRequest.MonthlyTurns,
+	}
+
+	switch {
+	case budgetRequest.AccountID != "" && budgetRequest.OrganizationID == "":
+		budget.Subject, budget.SubjectID = data.BudgetAccount, budgetRequest.AccountID
+	case budgetRequest.OrganizationID != "" && budgetRequest.AccountID == "":
+		budget.Subject, budget.SubjectID = data.BudgetOrganization, budgetRequest.OrganizationID
+	default:
+		log.Println("budget request names no single subject")
+		util.SendResponse(w, nil, "either accountId or organizationId is required", http.StatusBadRequest)
+
+		return
+	}
+
+	if budget.DailyCost < 0 || budget.MonthlyCost < 0 || budget.DailyTurns < 0 || budget.MonthlyTurns < 0 {
+		log.Println("budget request has negative limits")
+		util.SendResponse(w, nil, "limits cannot be negative", http.StatusBadRequest)
+
+		return
+	}
+
+	tx, err := h.db.BeginTx()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+		util.SendResponse(w, nil, "failed to set budget", http.StatusInternalServerError)
+
+		return
+	}
+	defer tx.Rollback()
+
+	if err := h.db.SetBudget(tx, budget); err != nil {
+		log.Printf("failed to set budget: %v", err)
+		util.SendResponse(w, nil, "failed to set budget", http.StatusInternalServerError)
+
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+		util.SendResponse(w, nil, "failed to set budget", http.StatusInternalServerError)
+
+		return
+	}
+
+	util.SendResponse(w, nil, "budget set", http.StatusOK)
+}
+
+// upstream returns the client for calls to the AI provider made on behalf of
+// the account, metering their usage. Calls use the account's own provider key
+// when it stored one. Once a budget of the account or of its organizations is
+// exceeded the request is rejected, or served by cheaper models when the
+// server is configured to downgrade; spending caps do not apply to the
+// account's own key, and turn quotas only apply when the request takes a
+// turn. Conversations without an account are not metered against any budget
+// and are only held back by rate limits.
+//
+// Requests counted against the same limited budget, whether of one account
+// or of several members of an organization, run one at a time from the check
+// until the returned release is called, so that concurrent requests cannot
+// all pass the check and spend past a limit together. Budgets without limits
+// that apply to the request hold nobody up. It writes the error response
+// itself and reports whether the handler may continue; the handler must call
+// release once its calls are done.
+func (h *handler) upstream(w http.ResponseWriter, req *http.Request, accountID string, turn bool) (openai.Client, func(), bool) {
+	apiKey, ownKey, ok := h.providerKey(w, accountID)
+	if !ok {
+		return nil, nil, false
+	}
+
+	client := openai.NewOpenAI(apiKey).WithUsage(func(usage openai.Usage) {
+		h.recordUsage(data.Usage{
+			AccountID:    accountID,
+			Operation:    usage.Operation,
+			Model:        usage.Model,
+			InputTokens:  usage.InputTokens,
+			OutputTokens: usage.OutputTokens,
+			AudioSeconds: usage.AudioSeconds,
+			Characters:   usage.Characters,
+			Cost:         usage.Cost(),
+			OwnKey:       ownKey,
+		})
+	})
+
+	if accountID == "" {
+		return client, func() {}, true
+	}
+
+	budgets, err := h.budgetSubjects(accountID)
+	if err != nil {
+		log.Printf("failed to get budgets: %v", err)
+		util.SendResponse(w, nil, "failed to check budget", http.StatusInternalServerError)
+
+		return nil, nil, false
+	}
+
+	var keys []string
+	for _, budget := range budgets {
+		if (!ownKey && budget.Limits.CostLimited()) || (turn && budget.Limits.TurnLimited()) {
+			keys = append(keys, string(budget.Subject)+":"+budget.ID)
+		}
+	}
+
+	release, err := h.budgetLocks.lock(req.Context(), keys...)
+	if err != nil {
+		log.Printf("request ended while waiting for the budget check: %v", err)
+		util.SendResponse(w, nil, "request cancelled", http.StatusServiceUnavailable)
+
+		return nil, nil, false
+	}
+
+	ok = false
+	defer func() {
+		if !ok {
+			release()
+		}
+	}()
+
+	now := time.Now()
+
+	if err := h.budgetUsage(budgets, now); err != nil {
+		log.Printf("failed to get budget usage: %v", err)
+		util.SendResponse(w, nil, "failed to check budget", http.StatusInternalServerError)
+
+		return nil, nil, false
+	}
+
+	warnPercent := h.budgetLimits.WarnPercent
+	downgrade := false
+	var warnings []string
+
+	for _, budget := range budgets {
+		for _, period := range budget.Periods(now) {
+			if turn && period.TurnLevel(warnPercent) == util.BudgetExceeded {
+				log.Printf("%s turn quota of %s %s is used up", period.Name, budget.Subject, budget.ID)
+				middleware.SetRetryAfter(w, period.ResetsAt.Sub(now))
+				util.SendResponse(w, nil, fmt.Sprintf("the %s turn quota of the %s is used up", period.Name, budget.Subject), http.StatusTooManyRequests)
+
+				return nil, nil, false
+			}
+
+			switch {
+			case ownKey:
+			case period.CostLevel(warnPercent) == util.BudgetExceeded:
+				if !h.budgetLimits.Downgrade {
+					log.Printf("%s spending cap of %s %s is reached", period.Name, budget.Subject, budget.ID)
+					middleware.SetRetryAfter(w, period.ResetsAt.Sub(now))
+					util.SendResponse(w, nil, fmt.Sprintf("the %s spending cap of the %s is reached", period.Name, budget.Subject), http.StatusTooManyRequests)
+
+					return nil, nil, false
+				}
+
+				downgrade = true
+				warnings = append(warnings, fmt.Sprintf("%s spending cap of the %s is reached, cheaper models are used", period.Name, budget.Subject))
+			case period.CostLevel(warnPercent) == util.BudgetWarning:
+				warnings = append(warnings, fmt.Sprintf("%s spending of the %s is nearing its cap", period.Name, budget.Subject))
+			}
+
+			if turn && period.TurnLevel(warnPercent) == util.BudgetWarning {
+				warnings = append(warnings, fmt.Sprintf("%s turns of the %s are nearing their quota", period.Name, budget.Subject))
+			}
+		}
+	}
+
+	if len(warnings) > 0 {
+		w.Header().Set(budgetWarningHeader, strings.Join(warnings, "; "))
+	}
+
+	ok = true
+
+	if downgrade {
+		return client.Economy(), release, true
+	}
+
+	return client, release, true
+}
+
+// budgetLocks lets a single request at a time through per budget, for the
+// budget check and the calls it allows.
+type budgetLocks struct {
+	mu    sync.Mutex
+	locks map[string]*budgetLock
+}
+
+type budgetLock struct {
+	held    chan struct{}
+	waiting int
+}
+
+func newBudgetLocks() *budgetLocks {
+	return &budgetLocks{locks: make(map[string]*budgetLock)}
+}
+
+// lock waits until every budget named by keys is free, or ctx is done, and
+// returns the func that frees them again. Budgets are taken in sorted order,
+// so that requests sharing several budgets cannot wait on each other. Locks
+// nobody holds or waits for are dropped.
+func (l *budgetLocks) lock(ctx context.Context, keys ...string) (func(), error) {
+	keys = slices.Clone(keys)
+	slices.Sort(keys)
+	keys = slices.Compact(keys)
+
+	var releases []func()
+	releaseAll := func() {
+		for i := len(releases) - 1; i >= 0; i-- {
+			releases[i]()
+		}
+	}
+
+	for _, key := range keys {
+		release, err := l.lockOne(ctx, key)
+		if err != nil {
+			releaseAll()
+			return nil, err
+		}
+		releases = append(releases, release)
+	}
+
+	var once sync.Once
+	return func() { once.Do(releaseAll) }, nil
+}
+
+func (l *budgetLocks) lockOne(ctx context.Context, key string) (func(), error) {
+	l.mu.Lock()
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &budgetLock{held: make(chan struct{}, 1)}
+		l.locks[key] = lock
+	}
+	lock.waiting++
+	l.mu.Unlock()
+
+	done := func() {
+		l.mu.Lock()
+		lock.waiting--
+		if lock.waiting == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+
+	select {
+	case lock.held <- struct{}{}:
+	case <-ctx.Done():
+		done()
+		return nil, ctx.Err()
+	}
+
+	return func() {
+		<-lock.held
+		done()
+	}, nil
+}
+
+// budgets returns the budgets that apply to the account, its own first, with
+// the usage counted against each.
+func (h *handler) budgets(accountID string, now time.Time) ([]util.BudgetState, error) {
+	budgets, err := h.budgetSubjects(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.budgetUsage(budgets, now); err != nil {
+		return nil, err
+	}
+
+	return budgets, nil
+}
+
+// budgetSubjects returns the budgets that apply to the account, its own
+// first, with their limits but no usage yet.
+func (h *handler) budgetSubjects(accountID string) ([]util.BudgetState, error) {
+	limits, err := h.budgetFor(data.BudgetAccount, accountID, h.budgetLimits.Account)
+	if err != nil {
+		return nil, err
+	}
+
+	budgets := []util.BudgetState{{Subject: data.BudgetAccount, ID: accountID, Limits: limits}}
+
+	organizations, err := h.db.GetOrganizationsByAccountID(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, organization := range organizations {
+		limits, err := h.budgetFor(data.BudgetOrganization, organization.ID, h.budgetLimits.Organization)
+		if err != nil {
+			return nil, err
+		}
+
+		budgets = append(budgets, util.BudgetState{Subject: data.BudgetOrganization, ID: organization.ID, Name: organization.Name, Limits: limits})
+	}
+
+	return budgets, nil
+}
+
+// budgetUsage counts the usage of the current day and month against each of
+// the budgets.
+func (h *handler) budgetUsage(budgets []util.BudgetState, now time.Time) error {
+	day, month := util.BudgetPeriodStarts(now)
+
+	for i, budget := range budgets {
+		var err error
+		if budget.Subject == data.BudgetAccount {
+			budgets[i].Usage, err = h.db.GetAccountUsage(budget.ID, day, month)
+		} else {
+			budgets[i].Usage, err = h.db.GetOrganizationUsage(budget.ID, day, month)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// budgetFor returns the limits an admin set for the subject, or the server's
+// defaults.
+func (h *handler) budgetFor(subject data.BudgetSubject, id string, defaults config.Budget) (config.Budget, error) {
+	budget, err := h.db.GetBudget(subject, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return defaults, nil
+	}
+	if err != nil {
+		return config.Budget{}, err
+	}
+
+	return config.Budget{
+		DailyCost:    budget.DailyCost,
+		MonthlyCost:  budget.MonthlyCost,
+		DailyTurns:   budget.DailyTurns,
+		MonthlyTurns: budget.MonthlyTurns,
+	}, nil
+}
+
+// recordTurn counts a turn taken in a conversation of the account against its
+// turn quotas.
+func (h *handler) recordTurn(accountID string) {
+	if accountID == "" {
+		return
+	}
+
+	h.recordUsage(data.Usage{AccountID: accountID, Operation: data.OperationTurn, Turns: 1})
+}
+
+// recordUsage stores a usage record. Failing to record is not fatal to the
+// request, whose calls have already been made.
+func (h *handler) recordUsage(usage data.Usage) {
+	if err := h.db.RecordUsage(usage); err != nil {
+		log.Printf("failed to record usage: %v", err)
+	}
+}
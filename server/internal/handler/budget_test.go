@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBudgetLocks(t *testing.T) {
+	locks := newBudgetLocks()
+
+	// A member of the organization holds it along with its own account
+	release, err := locks.lock(context.Background(), "organization:org-1", "account:account-1")
+	if err != nil {
+		t.Fatalf("lock() error = %v", err)
+	}
+
+	// Another account outside the organization is not held up, nor is a
+	// request with no limited budget
+	for _, keys := range [][]string{{"account:account-3"}, nil} {
+		other, err := locks.lock(context.Background(), keys...)
+		if err != nil {
+			t.Fatalf("lock(%q) error = %v", keys, err)
+		}
+		other()
+	}
+
+	// Another member of the organization waits until it is released, or
+	// gives up without keeping its own account locked
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := locks.lock(ctx, "account:account-2", "organization:org-1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("lock() while held error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	own, err := locks.lock(context.Background(), "account:account-2")
+	if err != nil {
+		t.Fatalf("lock() of the account that gave up error = %v", err)
+	}
+	own()
+
+	acquired := make(chan func())
+	go func() {
+		next, err := locks.lock(context.Background(), "account:account-2", "organization:org-1")
+		if err != nil {
+			t.Errorf("lock() after release error = %v", err)
+		}
+		acquired <- next
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("lock() returned while held")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	release()
+	release()
+
+	next := <-acquired
+	if next == nil {
+		return
+	}
+	next()
+
+	if len(locks.locks) != 0 {
+		t.Errorf("%d locks left after every release, want 0", len(locks.locks))
+	}
+}
@@ -3,19 +3,26 @@ package handler
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"time"
 
+	"github.com/madeindra/mock-conversation/server/internal/auth"
 	"github.com/madeindra/mock-conversation/server/internal/config"
 	"github.com/madeindra/mock-conversation/server/internal/data"
-	"github.com/madeindra/mock-conversation/server/internal/middleware"
 	"github.com/madeindra/mock-conversation/server/internal/model"
 	"github.com/madeindra/mock-conversation/server/internal/openai"
 	"github.com/madeindra/mock-conversation/server/internal/util"
 )
 
+const (
+	maxObjectives = 10
+	maxCharacters = 4
+)
+
 func (h *handler) Status(w http.ResponseWriter, _ *http.Request) {
 	isKeyValid, err := h.ai.IsKeyValid()
 	if err != nil {
@@ -49,7 +56,18 @@ func (h *handler) Status(w http.ResponseWriter, _ *http.Request) {
 	util.SendResponse(w, response, "success", http.StatusOK)
 }
 
+// StartChat creates a new conversation and opens a session for it. Requests
+// carrying an account access token link the conversation to that account.
 func (h *handler) StartChat(w http.ResponseWriter, req *http.Request) {
+	account, ok := h.optionalAccount(w, req)
+	if !ok {
+		return
+	}
+
+	if account != nil && !h.allowAccount(w, req, account.ID) {
+		return
+	}
+
 	var startChatRequest model.StartChatRequest
 	if err := json.NewDecoder(req.Body).Decode(&startChatRequest); err != nil {
 		log.Printf("failed to read start chat request body: %v", err)
@@ -58,18 +76,104 @@ func (h *handler) StartChat(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if startChatRequest.ClassroomID != "" && !h.requireClassroomMember(w, account, startChatRequest.ClassroomID) {
+		return
+	}
+
 	chatLanguage := h.ai.GetDefaultTranscriptLanguage()
-	if startChatRequest.Language != "" {
+	switch {
+	case startChatRequest.Language == config.AutoLanguage:
+		chatLanguage = config.AutoLanguage
+	case startChatRequest.Language != "":
+		if !requireLanguage(w, startChatRequest.Language, config.ConversationLanguages()) {
+			return
+		}
+
 		chatLanguage = config.GetLanguage(startChatRequest.Language)
 	}
 
+	// A conversation in auto mode opens in the default language and then
+	// follows the user
+	greetingLanguage := chatLanguage
+	if chatLanguage == config.AutoLanguage {
+		greetingLanguage = config.DefaultLanguage
+	}
+
+	if startChatRequest.SubtitleLanguage != "" && !requireLanguage(w, startChatRequest.SubtitleLanguage, config.Languages()) {
+		return
+	}
+
+	// Transliteration only applies to languages not written in the Latin script
+	transliterationEnabled := startChatRequest.Transliteration && (chatLanguage == config.AutoLanguage || config.GetTransliteration(chatLanguage) != "")
+	transliteration := ""
+	if transliterationEnabled {
+		transliteration = config.GetTransliteration(greetingLanguage)
+	}
+
 	// Resolve subtitle language name for translation
 	subtitleLanguage := ""
 	if startChatRequest.SubtitleLanguage != "" {
 		subtitleLanguage = config.GetLanguageName(startChatRequest.SubtitleLanguage)
 	}
 
-	systemPrompt, initialResult, err := util.GenerateStartChat(h.ai, startChatRequest.Role, startChatRequest.Topic, config.GetLanguageName(startChatRequest.Language), subtitleLanguage)
+	objectives := util.CleanObjectives(startChatRequest.Objectives)
+	if len(objectives) > maxObjectives {
+		log.Printf("too many objectives: %d", len(objectives))
+		util.SendResponse(w, nil, fmt.Sprintf("a conversation can have at most %d objectives", maxObjectives), http.StatusBadRequest)
+
+		return
+	}
+
+	// Scenario limits can only tighten the server-wide limits
+	limits := h.limits
+	if startChatRequest.Limits != nil {
+		limits = limits.Narrow(config.Limits{
+			MaxTurns:        startChatRequest.Limits.MaxTurns,
+			MaxMinutes:      startChatRequest.Limits.MaxMinutes,
+			MaxAudioSeconds: startChatRequest.Limits.MaxAudioSeconds,
+		})
+	}
+
+	characters, err := util.CleanCharacters(startChatRequest.Characters, maxCharacters)
+	if err != nil {
+		log.Printf("invalid characters: %v", err)
+		util.SendResponse(w, nil, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	// Every character in a scene gets its own voice
+	for i, voice := range h.randomVoices(greetingLanguage, len(characters)) {
+		characters[i].Voice = voice
+	}
+
+	prompt := openai.SystemPrompt{
+		Role:         startChatRequest.Role,
+		Topic:        startChatRequest.Topic,
+		Language:     config.GetLanguageInfo(greetingLanguage).Name,
+		Objectives:   objectives,
+		AutoLanguage: chatLanguage == config.AutoLanguage,
+	}
+	for _, character := range characters {
+		prompt.Characters = append(prompt.Characters, openai.PromptCharacter{Name: character.Name, Role: character.Role})
+	}
+
+	accountID := ""
+	if account != nil {
+		accountID = account.ID
+	}
+
+	ai, release, ok := h.upstream(w, req, accountID, false)
+	if !ok {
+		return
+	}
+	defer release()
+
+	systemPrompt, initialResult, err := util.GenerateStartChat(ai, prompt, util.ChatOptions{
+		SubtitleLanguage: subtitleLanguage,
+		Transliteration:  transliteration,
+		Characters:       characters,
+	})
 	if err != nil {
 		log.Printf("failed to get system prompt or initial text: %v", err)
 		util.SendResponse(w, nil, "failed to prepare chat", http.StatusInternalServerError)
@@ -77,10 +181,16 @@ func (h *handler) StartChat(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Pick a random voice for this conversation
-	voice := h.ai.RandomVoice()
+	// Pick a random voice for this conversation, or use the voice of the
+	// character who opens the scene
+	voice := h.randomVoices(greetingLanguage, 1)[0]
 
-	initialAudio, err := util.GenerateSpeech(h.ai, initialResult.Response, voice, chatLanguage)
+	speaker := util.FindSpeaker(characters, initialResult.Speaker)
+	if speaker != nil {
+		voice = speaker.Voice
+	}
+
+	initialAudio, err := h.generateSpeech(ai, initialResult.Response, voice, greetingLanguage)
 	if err != nil {
 		log.Printf("failed to generate speech: %v", err)
 		util.SendResponse(w, nil, "failed to generate speech", http.StatusInternalServerError)
@@ -106,7 +216,24 @@ func (h *handler) StartChat(w http.ResponseWriter, req *http.Request) {
 	}
 	defer tx.Rollback()
 
-	newUser, err := h.db.CreateChatUser(tx, hashed, chatLanguage, config.GetLanguage(startChatRequest.SubtitleLanguage), voice)
+	// An empty subtitle language means subtitles are turned off
+	storedSubtitleLanguage := ""
+	if startChatRequest.SubtitleLanguage != "" {
+		storedSubtitleLanguage = config.GetLanguage(startChatRequest.SubtitleLanguage)
+	}
+
+	newUser, err := h.db.CreateChatUser(tx, data.ChatUser{
+		Secret:           hashed,
+		AccountID:        accountID,
+		Language:         chatLanguage,
+		SubtitleLanguage: storedSubtitleLanguage,
+		Voice:            voice,
+		MaxTurns:         limits.MaxTurns,
+		MaxMinutes:       limits.MaxMinutes,
+		MaxAudioSeconds:  limits.MaxAudioSeconds,
+		Transliteration:  transliterationEnabled,
+		ClassroomID:      startChatRequest.ClassroomID,
+	})
 	if err != nil {
 		log.Printf("failed to create new chat: %v", err)
 		util.SendResponse(w, nil, "failed to create new chat", http.StatusInternalServerError)
@@ -114,23 +241,55 @@ func (h *handler) StartChat(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if _, err := h.db.CreateChats(tx, newUser.ID, []data.Entry{
+	// Characters are stored first so the greeting can reference its speaker;
+	// speaker points into characters and picks up the generated ID.
+	newCharacters, err := h.db.CreateCharacters(tx, newUser.ID, characters)
+	if err != nil {
+		log.Printf("failed to create characters: %v", err)
+		util.SendResponse(w, nil, "failed to create new chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	initialEntries, err := h.db.CreateChats(tx, newUser.ID, []data.Entry{
 		{
 			Role: string(openai.ROLE_SYSTEM),
 			Text: systemPrompt,
 		},
 		{
-			Role:  string(openai.ROLE_ASSISTANT),
-			Text:  initialResult.Response,
-			Audio: initialAudio,
+			Role:             string(openai.ROLE_ASSISTANT),
+			Text:             initialResult.Response,
+			Language:         greetingLanguage,
+			Audio:            initialAudio,
+			Subtitle:         initialResult.ResponseSubtitle,
+			SubtitleLanguage: storedSubtitleLanguage,
+			Transliteration:  initialResult.ResponseTransliteration,
+			CharacterID:      util.CharacterID(speaker),
 		},
-	}); err != nil {
+	})
+	if err != nil {
 		log.Printf("failed to create chat: %v", err)
 		util.SendResponse(w, nil, "failed to create chat", http.StatusInternalServerError)
 
 		return
 	}
 
+	newObjectives, err := h.db.CreateObjectives(tx, newUser.ID, objectives)
+	if err != nil {
+		log.Printf("failed to create objectives: %v", err)
+		util.SendResponse(w, nil, "failed to create new chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	tokens, err := h.createSession(tx, auth.KindChat, newUser.ID)
+	if err != nil {
+		log.Printf("failed to create session: %v", err)
+		util.SendResponse(w, nil, "failed to create new chat", http.StatusInternalServerError)
+
+		return
+	}
+
 	if err := tx.Commit(); err != nil {
 		log.Printf("failed to commit transaction: %v", err)
 		util.SendResponse(w, nil, "failed to create new chat", http.StatusInternalServerError)
@@ -139,13 +298,21 @@ func (h *handler) StartChat(w http.ResponseWriter, req *http.Request) {
 	}
 
 	initialChat := model.StartChatResponse{
-		ID:       newUser.ID,
-		Secret:   plainSecret,
-		Language: startChatRequest.Language,
+		ID:              newUser.ID,
+		Secret:          plainSecret,
+		Language:        startChatRequest.Language,
+		Transliteration: newUser.Transliteration,
+		Objectives:      util.ConvertToObjectives(newObjectives),
+		Characters:      util.ConvertToCharacters(newCharacters),
+		Limits:          util.ConvertToLimits(newUser),
+		TokenResponse:   tokens,
 		Chat: model.Chat{
-			Text:     initialResult.Response,
-			Audio:    initialAudio,
-			Subtitle: initialResult.ResponseSubtitle,
+			ID:              initialEntries[1].ID,
+			Text:            initialResult.Response,
+			Audio:           initialAudio,
+			Subtitle:        initialResult.ResponseSubtitle,
+			Transliteration: initialResult.ResponseTransliteration,
+			Speaker:         util.CharacterName(speaker),
 		},
 	}
 
@@ -153,34 +320,42 @@ func (h *handler) StartChat(w http.ResponseWriter, req *http.Request) {
 }
 
 func (h *handler) AnswerChat(w http.ResponseWriter, req *http.Request) {
-	userID := req.Context().Value(middleware.ContextKeyUserID).(string)
-	userSecret := req.Context().Value(middleware.ContextKeyUserSecret).(string)
+	user, ok := h.authenticate(w, req)
+	if !ok {
+		return
+	}
+
+	if !requireStatus(w, user, data.StatusActive) {
+		return
+	}
 
-	if userID == "" || userSecret == "" {
-		log.Println("user ID or secret is missing")
-		util.SendResponse(w, nil, "missing required authentication", http.StatusUnauthorized)
+	// An answer arriving past the time limit is not transcribed; the
+	// conversation is over
+	if util.TimeLimitReached(user, time.Now()) {
+		h.endExpiredChat(w, user)
 
 		return
 	}
 
-	user, err := h.db.GetChatUser(userID)
+	entries, err := h.db.GetChatsByChatUserID(user.ID)
 	if err != nil {
-		log.Printf("failed to get chat user: %v", err)
-		util.SendResponse(w, nil, "failed to get chat user", http.StatusNotFound)
+		log.Printf("failed to get chat: %v", err)
+		util.SendResponse(w, nil, "failed to get chat", http.StatusInternalServerError)
 
 		return
 	}
 
-	if err := util.CompareHash(userSecret, user.Secret); err != nil {
-		log.Println("invalid user secret")
-		util.SendResponse(w, nil, "invalid user secret", http.StatusUnauthorized)
+	objectives, err := h.db.GetObjectivesByChatUserID(user.ID)
+	if err != nil {
+		log.Printf("failed to get objectives: %v", err)
+		util.SendResponse(w, nil, "failed to get chat", http.StatusInternalServerError)
 
 		return
 	}
 
-	entries, err := h.db.GetChatsByChatUserID(user.ID)
+	characters, err := h.db.GetCharactersByChatUserID(user.ID)
 	if err != nil {
-		log.Printf("failed to get chat: %v", err)
+		log.Printf("failed to get characters: %v", err)
 		util.SendResponse(w, nil, "failed to get chat", http.StatusInternalServerError)
 
 		return
@@ -210,15 +385,27 @@ func (h *handler) AnswerChat(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Resolve subtitle language name
-	subtitleLanguage := ""
-	if user.SubtitleLanguage != "" {
-		subtitleLanguage = config.GetLanguageName(config.GetCode(user.SubtitleLanguage))
+	// Reject recordings over the limit before paying for a transcription
+	// whenever the duration can be read from the file itself
+	if duration, ok := util.WAVDuration(audioBytes); ok && !withinAudioLimit(user, duration) {
+		sendAudioLimitError(w, user, duration)
+
+		return
+	}
+
+	ai, release, ok := h.upstream(w, req, user.AccountID, true)
+	if !ok {
+		return
 	}
+	defer release()
+
+	subtitleLanguage := subtitleLanguageName(user)
 
 	// Step 1: Transcribe audio using gpt-4o-mini-transcribe
 	audioReader := io.NopCloser(bytes.NewReader(audioBytes))
-	transcript, err := util.TranscribeSpeech(h.ai, audioReader, fileHeader.Filename, user.Language)
+	// The language is left for the model to detect: forcing the conversation
+	// language garbles answers given in another language
+	transcription, err := util.TranscribeSpeech(ai, audioReader, fileHeader.Filename, "")
 	if err != nil {
 		log.Printf("failed to transcribe speech: %v", err)
 		util.SendResponse(w, nil, "failed to transcribe speech", http.StatusInternalServerError)
@@ -226,10 +413,50 @@ func (h *handler) AnswerChat(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Step 2: Generate response using gpt-4o-mini with JSON format
-	history := util.ConvertToChatMessage(entries)
+	if !withinAudioLimit(user, transcription.Duration) {
+		sendAudioLimitError(w, user, transcription.Duration)
+
+		return
+	}
+
+	transcript := transcription.Text
+
+	// The detected language is recorded with the turn; in auto mode the reply
+	// follows it
+	spoken, ok := config.DetectLanguage(transcription.Language)
+	if !ok {
+		spoken = replyLanguage(user, entries)
+	}
 
-	answerResult, err := util.GenerateAnswerChat(h.ai, history, transcript, subtitleLanguage)
+	// Answering in another language gets a gentle nudge back to the
+	// conversation language
+	mismatch := mismatchedLanguage(user, spoken)
+	if !ok && user.Language != config.AutoLanguage && transcription.Language != "" {
+		mismatch = transcription.Language
+	}
+
+	language := user.Language
+	if language == config.AutoLanguage {
+		language = spoken
+	}
+
+	// Close the conversation gracefully as it nears its turn or time limit
+	turn := util.CountTurns(entries) + 1
+	closing := util.ConversationClosing(user, turn, time.Now())
+
+	// Step 2: Generate response using gpt-4o-mini with JSON format
+	h.compactHistory(ai, user, entries, characters)
+	history := util.BuildHistory(user, entries, characters)
+
+	answerResult, err := util.GenerateAnswerChat(ai, history, transcript, util.ChatOptions{
+		SubtitleLanguage: subtitleLanguage,
+		Transliteration:  transliterationScheme(user, language),
+		ReplyLanguage:    replyLanguageName(user, language),
+		LanguageMismatch: mismatch,
+		Objectives:       objectives,
+		Characters:       characters,
+		Closing:          closing,
+	})
 	if err != nil {
 		log.Printf("failed to get chat completion: %v", err)
 		util.SendResponse(w, nil, fmt.Sprintf("failed to get chat completion: %v", err), http.StatusInternalServerError)
@@ -240,7 +467,12 @@ func (h *handler) AnswerChat(w http.ResponseWriter, req *http.Request) {
 	// Set the transcript from Whisper (not from the chat model)
 	answerResult.Transcript = transcript
 
-	answerAudio, err := util.GenerateSpeech(h.ai, answerResult.Response, user.Voice, user.Language)
+	// Once a limit is reached the server ends the conversation itself
+	if closing == util.ClosingNow {
+		answerResult.IsLast = true
+	}
+
+	speaker, answerAudio, err := h.speak(ai, user, characters, answerResult, language)
 	if err != nil {
 		log.Printf("failed to generate speech: %v", err)
 		util.SendResponse(w, nil, "failed to generate speech", http.StatusInternalServerError)
@@ -257,23 +489,61 @@ func (h *handler) AnswerChat(w http.ResponseWriter, req *http.Request) {
 	}
 	defer tx.Rollback()
 
-	if _, err := h.db.CreateChats(tx, userID, []data.Entry{
+	// The conversation may have been ended while the reply was generated
+	if !h.requireStatusTx(w, tx, user.ID, data.StatusActive) {
+		return
+	}
+
+	newEntries, err := h.db.CreateChats(tx, user.ID, []data.Entry{
 		{
-			Role: string(openai.ROLE_USER),
-			Text: answerResult.Transcript,
+			Role:             string(openai.ROLE_USER),
+			Text:             answerResult.Transcript,
+			Language:         spoken,
+			Subtitle:         answerResult.TranscriptSubtitle,
+			SubtitleLanguage: user.SubtitleLanguage,
+			Transliteration:  answerResult.TranscriptTransliteration,
 		},
 		{
-			Role:  string(openai.ROLE_ASSISTANT),
-			Text:  answerResult.Response,
-			Audio: answerAudio,
+			Role:             string(openai.ROLE_ASSISTANT),
+			Text:             answerResult.Response,
+			Language:         language,
+			Audio:            answerAudio,
+			Subtitle:         answerResult.ResponseSubtitle,
+			SubtitleLanguage: user.SubtitleLanguage,
+			Transliteration:  answerResult.ResponseTransliteration,
+			CharacterID:      util.CharacterID(speaker),
 		},
-	}); err != nil {
+	})
+	if err != nil {
 		log.Printf("failed to create chat: %v", err)
 		util.SendResponse(w, nil, "failed to create chat", http.StatusInternalServerError)
 
 		return
 	}
 
+	completedIDs := util.CompletedObjectiveIDs(objectives, answerResult.CompletedObjectives)
+	if err := h.db.CompleteObjectives(tx, completedIDs, newEntries[0].ID); err != nil {
+		log.Printf("failed to complete objectives: %v", err)
+		util.SendResponse(w, nil, "failed to create chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	// The scenario is over once every objective has been met
+	objectives = util.MarkObjectivesCompleted(objectives, completedIDs, newEntries[0].ID)
+	if util.AllObjectivesCompleted(objectives) {
+		answerResult.IsLast = true
+	}
+
+	if answerResult.IsLast {
+		if err := h.db.UpdateChatUserStatus(tx, user.ID, data.StatusActive, data.StatusEnded); err != nil {
+			log.Printf("failed to end chat: %v", err)
+			util.SendResponse(w, nil, "failed to create chat", http.StatusInternalServerError)
+
+			return
+		}
+	}
+
 	if err := tx.Commit(); err != nil {
 		log.Printf("failed to commit transaction: %v", err)
 		util.SendResponse(w, nil, "failed to create new chat", http.StatusInternalServerError)
@@ -281,17 +551,27 @@ func (h *handler) AnswerChat(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	h.recordTurn(user.AccountID)
+
 	response := model.AnswerChatResponse{
-		Language: config.GetCode(user.Language),
-		IsLast:   answerResult.IsLast,
+		Language:         config.GetCode(language),
+		IsLast:           answerResult.IsLast,
+		LanguageMismatch: mismatch != "",
+		Objectives:       util.ConvertToObjectives(objectives),
+		TurnsRemaining:   util.TurnsRemaining(user, turn),
 		Prompt: model.Chat{
-			Text:     answerResult.Transcript,
-			Subtitle: answerResult.TranscriptSubtitle,
+			ID:              newEntries[0].ID,
+			Text:            answerResult.Transcript,
+			Subtitle:        answerResult.TranscriptSubtitle,
+			Transliteration: answerResult.TranscriptTransliteration,
 		},
 		Answer: model.Chat{
-			Text:     answerResult.Response,
-			Audio:    answerAudio,
-			Subtitle: answerResult.ResponseSubtitle,
+			ID:              newEntries[1].ID,
+			Text:            answerResult.Response,
+			Audio:           answerAudio,
+			Subtitle:        answerResult.ResponseSubtitle,
+			Transliteration: answerResult.ResponseTransliteration,
+			Speaker:         util.CharacterName(speaker),
 		},
 	}
 
@@ -299,31 +579,48 @@ func (h *handler) AnswerChat(w http.ResponseWriter, req *http.Request) {
 }
 
 func (h *handler) EndChat(w http.ResponseWriter, req *http.Request) {
-	userID := req.Context().Value(middleware.ContextKeyUserID).(string)
-	userSecret := req.Context().Value(middleware.ContextKeyUserSecret).(string)
-
-	if userID == "" || userSecret == "" {
-		log.Println("user ID or secret is missing")
-		util.SendResponse(w, nil, "missing required authentication", http.StatusUnauthorized)
-
+	user, ok := h.authenticate(w, req)
+	if !ok {
 		return
 	}
 
-	user, err := h.db.GetChatUser(userID)
-	if err != nil {
-		log.Printf("failed to get chat user: %v", err)
-		util.SendResponse(w, nil, "failed to get chat user", http.StatusNotFound)
+	if !requireStatus(w, user, data.StatusActive) {
+		return
+	}
 
+	ai, release, ok := h.upstream(w, req, user.AccountID, false)
+	if !ok {
 		return
 	}
+	defer release()
+
+	// Claim the conversation before paying for the farewell, so that it can
+	// only be ended once. The claim is released if anything below fails.
+	if err := h.db.SetChatUserStatus(user.ID, data.StatusActive, data.StatusEnding); err != nil {
+		if errors.Is(err, data.ErrInvalidTransition) {
+			log.Printf("chat %s is no longer active", user.ID)
+			util.SendResponse(w, nil, "conversation is no longer active", http.StatusConflict)
 
-	if err := util.CompareHash(userSecret, user.Secret); err != nil {
-		log.Println("invalid user secret")
-		util.SendResponse(w, nil, "invalid user secret", http.StatusUnauthorized)
+			return
+		}
+
+		log.Printf("failed to update chat status: %v", err)
+		util.SendResponse(w, nil, "failed to end chat", http.StatusInternalServerError)
 
 		return
 	}
 
+	ended := false
+	defer func() {
+		if ended {
+			return
+		}
+
+		if err := h.db.SetChatUserStatus(user.ID, data.StatusEnding, data.StatusActive); err != nil {
+			log.Printf("failed to release ending chat: %v", err)
+		}
+	}()
+
 	entries, err := h.db.GetChatsByChatUserID(user.ID)
 	if err != nil {
 		log.Printf("failed to get chat: %v", err)
@@ -332,15 +629,35 @@ func (h *handler) EndChat(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Resolve subtitle language name
-	subtitleLanguage := ""
-	if user.SubtitleLanguage != "" {
-		subtitleLanguage = config.GetLanguageName(config.GetCode(user.SubtitleLanguage))
+	subtitleLanguage := subtitleLanguageName(user)
+
+	objectives, err := h.db.GetObjectivesByChatUserID(user.ID)
+	if err != nil {
+		log.Printf("failed to get objectives: %v", err)
+		util.SendResponse(w, nil, "failed to get chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	characters, err := h.db.GetCharactersByChatUserID(user.ID)
+	if err != nil {
+		log.Printf("failed to get characters: %v", err)
+		util.SendResponse(w, nil, "failed to get chat", http.StatusInternalServerError)
+
+		return
 	}
 
-	history := util.ConvertToChatMessage(entries)
+	h.compactHistory(ai, user, entries, characters)
+	history := util.BuildHistory(user, entries, characters)
 
-	endResult, err := util.GenerateEndChat(h.ai, history, subtitleLanguage)
+	language := replyLanguage(user, entries)
+
+	endResult, err := util.GenerateEndChat(ai, history, util.ChatOptions{
+		SubtitleLanguage: subtitleLanguage,
+		Transliteration:  transliterationScheme(user, language),
+		ReplyLanguage:    replyLanguageName(user, language),
+		Characters:       characters,
+	})
 	if err != nil {
 		log.Printf("failed to get chat completion: %v", err)
 		util.SendResponse(w, nil, "failed to get chat completion", http.StatusInternalServerError)
@@ -348,7 +665,7 @@ func (h *handler) EndChat(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	answerAudio, err := util.GenerateSpeech(h.ai, endResult.Response, user.Voice, user.Language)
+	speaker, answerAudio, err := h.speak(ai, user, characters, endResult, language)
 	if err != nil {
 		log.Printf("failed to generate speech: %v", err)
 		util.SendResponse(w, nil, "failed to generate speech", http.StatusInternalServerError)
@@ -365,13 +682,30 @@ func (h *handler) EndChat(w http.ResponseWriter, req *http.Request) {
 	}
 	defer tx.Rollback()
 
-	if _, err := h.db.CreateChat(tx, userID, string(openai.ROLE_ASSISTANT), endResult.Response, answerAudio); err != nil {
+	farewell, err := h.db.CreateChat(tx, user.ID, data.Entry{
+		Role:             string(openai.ROLE_ASSISTANT),
+		Text:             endResult.Response,
+		Language:         language,
+		Audio:            answerAudio,
+		Subtitle:         endResult.ResponseSubtitle,
+		SubtitleLanguage: user.SubtitleLanguage,
+		Transliteration:  endResult.ResponseTransliteration,
+		CharacterID:      util.CharacterID(speaker),
+	})
+	if err != nil {
 		log.Printf("failed to create chat: %v", err)
 		util.SendResponse(w, nil, "failed to create chat", http.StatusInternalServerError)
 
 		return
 	}
 
+	if err := h.db.UpdateChatUserStatus(tx, user.ID, data.StatusEnding, data.StatusEnded); err != nil {
+		log.Printf("failed to end chat: %v", err)
+		util.SendResponse(w, nil, "failed to create chat", http.StatusInternalServerError)
+
+		return
+	}
+
 	if err := tx.Commit(); err != nil {
 		log.Printf("failed to commit transaction: %v", err)
 		util.SendResponse(w, nil, "failed to create new chat", http.StatusInternalServerError)
@@ -379,13 +713,19 @@ func (h *handler) EndChat(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	ended = true
+
 	response := model.AnswerChatResponse{
-		Language: config.GetCode(user.Language),
-		IsLast:   true,
+		Language:   config.GetCode(language),
+		IsLast:     true,
+		Objectives: util.ConvertToObjectives(objectives),
 		Answer: model.Chat{
-			Text:     endResult.Response,
-			Audio:    answerAudio,
-			Subtitle: endResult.ResponseSubtitle,
+			ID:              farewell.ID,
+			Text:            endResult.Response,
+			Audio:           answerAudio,
+			Subtitle:        endResult.ResponseSubtitle,
+			Transliteration: endResult.ResponseTransliteration,
+			Speaker:         util.CharacterName(speaker),
 		},
 	}
 
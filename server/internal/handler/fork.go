@@ -0,0 +1,289 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/madeindra/mock-conversation/server/internal/auth"
+	"github.com/madeindra/mock-conversation/server/internal/data"
+	"github.com/madeindra/mock-conversation/server/internal/model"
+	"github.com/madeindra/mock-conversation/server/internal/openai"
+	"github.com/madeindra/mock-conversation/server/internal/util"
+)
+
+// maxTreeDepth guards the walk up to the root conversation against cycles.
+const maxTreeDepth = 1000
+
+// ForkChat branches the conversation off at one of its assistant replies. The
+// new conversation gets its own ID and secret and a copy of the history up to
+// and including that reply, so the user can answer it differently.
+func (h *handler) ForkChat(w http.ResponseWriter, req *http.Request) {
+	user, ok := h.authenticate(w, req)
+	if !ok {
+		return
+	}
+
+	var forkChatRequest model.ForkChatRequest
+	if err := json.NewDecoder(req.Body).Decode(&forkChatRequest); err != nil {
+		log.Printf("failed to read fork chat request body: %v", err)
+		util.SendResponse(w, nil, "failed to read request", http.StatusBadRequest)
+
+		return
+	}
+
+	entries, err := h.db.GetChatsByChatUserID(user.ID)
+	if err != nil {
+		log.Printf("failed to get chat: %v", err)
+		util.SendResponse(w, nil, "failed to get chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	forkIndex := -1
+	for i, entry := range entries {
+		if entry.ID == forkChatRequest.EntryID {
+			forkIndex = i
+			break
+		}
+	}
+
+	if forkIndex < 0 {
+		log.Printf("fork entry not found: %s", forkChatRequest.EntryID)
+		util.SendResponse(w, nil, "entry not found", http.StatusNotFound)
+
+		return
+	}
+
+	if entries[forkIndex].Role != string(openai.ROLE_ASSISTANT) {
+		log.Printf("cannot fork at %s entry", entries[forkIndex].Role)
+		util.SendResponse(w, nil, "a conversation can only be forked at an assistant reply", http.StatusBadRequest)
+
+		return
+	}
+
+	objectives, err := h.db.GetObjectivesByChatUserID(user.ID)
+	if err != nil {
+		log.Printf("failed to get objectives: %v", err)
+		util.SendResponse(w, nil, "failed to get chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	characters, err := h.db.GetCharactersByChatUserID(user.ID)
+	if err != nil {
+		log.Printf("failed to get characters: %v", err)
+		util.SendResponse(w, nil, "failed to get chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	plainSecret := util.GenerateRandom()
+	hashed, err := util.CreateHash(plainSecret)
+	if err != nil {
+		log.Printf("failed to create hash: %v", err)
+		util.SendResponse(w, nil, "failed to fork chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	tx, err := h.db.BeginTx()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+		util.SendResponse(w, nil, "failed to fork chat", http.StatusInternalServerError)
+
+		return
+	}
+	defer tx.Rollback()
+
+	newUser, err := h.db.ForkChatUser(tx, user, hashed, entries[forkIndex].ID)
+	if err != nil {
+		log.Printf("failed to fork chat user: %v", err)
+		util.SendResponse(w, nil, "failed to fork chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	copiedCharacters := make([]data.Character, len(characters))
+	for i, character := range characters {
+		copiedCharacters[i] = data.Character{Name: character.Name, Role: character.Role, Voice: character.Voice}
+	}
+
+	newCharacters, err := h.db.CreateCharacters(tx, newUser.ID, copiedCharacters)
+	if err != nil {
+		log.Printf("failed to copy characters: %v", err)
+		util.SendResponse(w, nil, "failed to fork chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	characterIDs := make(map[string]string, len(characters))
+	for i, character := range characters {
+		characterIDs[character.ID] = newCharacters[i].ID
+	}
+
+	copiedEntries := make([]data.Entry, forkIndex+1)
+	for i, entry := range entries[:forkIndex+1] {
+		copiedEntries[i] = data.Entry{
+			Role:             entry.Role,
+			Text:             entry.Text,
+			Language:         entry.Language,
+			Audio:            entry.Audio,
+			Subtitle:         entry.Subtitle,
+			SubtitleLanguage: entry.SubtitleLanguage,
+			Transliteration:  entry.Transliteration,
+			CharacterID:      characterIDs[entry.CharacterID],
+		}
+	}
+
+	newEntries, err := h.db.CreateChats(tx, newUser.ID, copiedEntries)
+	if err != nil {
+		log.Printf("failed to copy chats: %v", err)
+		util.SendResponse(w, nil, "failed to fork chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	entryIDs := make(map[string]string, len(newEntries))
+	summaryPosition := 0
+	for i, entry := range entries[:forkIndex+1] {
+		entryIDs[entry.ID] = newEntries[i].ID
+		if entry.Position <= user.SummaryPosition {
+			summaryPosition = newEntries[i].Position
+		}
+	}
+
+	// The summary carries over when it ends before the fork point
+	if user.Summary != "" && user.SummaryPosition < entries[forkIndex].Position {
+		if err := h.db.UpdateChatUserSummary(tx, newUser.ID, user.Summary, summaryPosition); err != nil {
+			log.Printf("failed to copy chat summary: %v", err)
+			util.SendResponse(w, nil, "failed to fork chat", http.StatusInternalServerError)
+
+			return
+		}
+	}
+
+	descriptions := make([]string, len(objectives))
+	for i, objective := range objectives {
+		descriptions[i] = objective.Description
+	}
+
+	newObjectives, err := h.db.CreateObjectives(tx, newUser.ID, descriptions)
+	if err != nil {
+		log.Printf("failed to copy objectives: %v", err)
+		util.SendResponse(w, nil, "failed to fork chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	// Only progress made within the copied history carries over
+	for i, objective := range objectives {
+		entryID, ok := entryIDs[objective.CompletedBy]
+		if !objective.IsCompleted() || !ok {
+			continue
+		}
+
+		if err := h.db.CompleteObjectives(tx, []string{newObjectives[i].ID}, entryID); err != nil {
+			log.Printf("failed to copy objective progress: %v", err)
+			util.SendResponse(w, nil, "failed to fork chat", http.StatusInternalServerError)
+
+			return
+		}
+	}
+
+	tokens, err := h.createSession(tx, auth.KindChat, newUser.ID)
+	if err != nil {
+		log.Printf("failed to create session: %v", err)
+		util.SendResponse(w, nil, "failed to fork chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+		util.SendResponse(w, nil, "failed to fork chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	newObjectives, err = h.db.GetObjectivesByChatUserID(newUser.ID)
+	if err != nil {
+		log.Printf("failed to get objectives: %v", err)
+		util.SendResponse(w, nil, "failed to get chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	lastReply := newEntries[len(newEntries)-1]
+
+	forkedChat := model.StartChatResponse{
+		ID:         newUser.ID,
+		Secret:     plainSecret,
+		Language:   languageCode(newUser.Language),
+		Objectives: util.ConvertToObjectives(newObjectives),
+		Characters: util.ConvertToCharacters(newCharacters),
+		Limits:     util.ConvertToLimits(newUser),
+
+		TokenResponse: tokens,
+		Chat: model.Chat{
+			ID:              lastReply.ID,
+			Text:            lastReply.Text,
+			Audio:           lastReply.Audio,
+			Subtitle:        lastReply.Subtitle,
+			Transliteration: lastReply.Transliteration,
+			Speaker:         util.CharacterName(util.CharacterByID(newCharacters, lastReply.CharacterID)),
+		},
+	}
+
+	util.SendResponse(w, forkedChat, "a new chat forked", http.StatusOK)
+}
+
+// ChatTree returns every conversation in the lineage of the current one,
+// starting from the conversation all of them were forked from.
+func (h *handler) ChatTree(w http.ResponseWriter, req *http.Request) {
+	user, ok := h.authenticateViewer(w, req)
+	if !ok {
+		return
+	}
+
+	root := user
+	for depth := 0; root.ParentID != "" && depth < maxTreeDepth; depth++ {
+		parent, err := h.db.GetChatUser(root.ParentID)
+		if err != nil {
+			log.Printf("failed to get parent chat user: %v", err)
+			util.SendResponse(w, nil, "failed to get chat tree", http.StatusInternalServerError)
+
+			return
+		}
+		root = parent
+	}
+
+	users, err := h.db.GetChatUserTree(root.ID)
+	if err != nil {
+		log.Printf("failed to get chat tree: %v", err)
+		util.SendResponse(w, nil, "failed to get chat tree", http.StatusInternalServerError)
+
+		return
+	}
+
+	nodes := make(map[string]*model.ConversationNode, len(users))
+	for _, u := range users {
+		nodes[u.ID] = &model.ConversationNode{
+			ID:          u.ID,
+			ParentID:    u.ParentID,
+			ForkEntryID: u.ForkEntryID,
+			CreatedAt:   u.CreatedAt,
+			Status:      string(u.Status),
+			Current:     u.ID == user.ID,
+		}
+	}
+
+	// users is ordered oldest first, so children end up in creation order
+	for _, u := range users {
+		if parent, ok := nodes[u.ParentID]; ok && u.ID != root.ID {
+			parent.Children = append(parent.Children, nodes[u.ID])
+		}
+	}
+
+	util.SendResponse(w, nodes[root.ID], "success", http.StatusOK)
+}
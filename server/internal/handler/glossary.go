@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/madeindra/mock-conversation/server/internal/config"
+	"github.com/madeindra/mock-conversation/server/internal/data"
+	"github.com/madeindra/mock-conversation/server/internal/model"
+	"github.com/madeindra/mock-conversation/server/internal/openai"
+	"github.com/madeindra/mock-conversation/server/internal/util"
+)
+
+// ChatGlossary returns a word-by-word breakdown of one turn, selected with
+// ?entryId=. Words are explained in the language given with ?language=,
+// defaulting to the subtitle language, or English when subtitles are off.
+// Glossaries are cached per entry and language.
+func (h *handler) ChatGlossary(w http.ResponseWriter, req *http.Request) {
+	user, ok := h.authenticate(w, req)
+	if !ok {
+		return
+	}
+
+	entryID := req.URL.Query().Get("entryId")
+
+	language := config.DefaultLanguage
+	if user.SubtitleLanguage != "" {
+		language = user.SubtitleLanguage
+	}
+
+	if code := req.URL.Query().Get("language"); code != "" {
+		if !requireLanguage(w, code, config.Languages()) {
+			return
+		}
+
+		language = config.GetLanguage(code)
+	}
+
+	entry, err := h.db.GetChat(user.ID, entryID)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("glossary entry not found: %s", entryID)
+		util.SendResponse(w, nil, "entry not found", http.StatusNotFound)
+
+		return
+	}
+	if err != nil {
+		log.Printf("failed to get chat: %v", err)
+		util.SendResponse(w, nil, "failed to get chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	if entry.Role == string(openai.ROLE_SYSTEM) {
+		log.Println("cannot build glossary of system entry")
+		util.SendResponse(w, nil, "only conversation turns have a glossary", http.StatusBadRequest)
+
+		return
+	}
+
+	tokens, ok, err := h.cachedGlossary(entry, language)
+	if err != nil {
+		log.Printf("failed to get glossary: %v", err)
+		util.SendResponse(w, nil, "failed to get glossary", http.StatusInternalServerError)
+
+		return
+	}
+
+	// Only a glossary that is not cached yet calls the AI, and so counts
+	// against the budgets
+	if !ok {
+		ai, release, ok := h.upstream(w, req, user.AccountID, false)
+		if !ok {
+			return
+		}
+		defer release()
+
+		tokens, err = h.glossary(ai, user, entry, language)
+		if err != nil {
+			log.Printf("failed to get glossary: %v", err)
+			util.SendResponse(w, nil, "failed to get glossary", http.StatusInternalServerError)
+
+			return
+		}
+	}
+
+	response := model.GlossaryResponse{
+		EntryID:  entry.ID,
+		Language: config.GetCode(language),
+		Text:     entry.Text,
+		Tokens:   util.ConvertToGlossary(tokens),
+	}
+
+	util.SendResponse(w, response, "success", http.StatusOK)
+}
+
+// cachedGlossary returns the entry's cached glossary in the given language,
+// and reports whether there was one.
+func (h *handler) cachedGlossary(entry *data.Entry, language config.Language) ([]openai.GlossaryToken, bool, error) {
+	cached, err := h.db.GetGlossary(entry.ID, language)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var tokens []openai.GlossaryToken
+	if err := json.Unmarshal([]byte(cached.Tokens), &tokens); err != nil {
+		return nil, false, err
+	}
+
+	return tokens, true, nil
+}
+
+// glossary builds the entry's glossary in the given language with the AI and
+// caches it. Callers look in the cache first.
+func (h *handler) glossary(ai openai.Client, user *data.ChatUser, entry *data.Entry, language config.Language) ([]openai.GlossaryToken, error) {
+	tokens, err := util.GenerateGlossary(ai, entry.Text, config.GetLanguageInfo(entryLanguage(user, *entry)).Name, config.GetLanguageName(config.GetCode(language)))
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := json.Marshal(tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := h.db.BeginTx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := h.db.SaveGlossary(tx, data.Glossary{ChatID: entry.ID, Language: language, Tokens: string(encoded)}); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
@@ -1,43 +1,440 @@
 package handler
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
 	"github.com/go-chi/chi"
+	chimiddleware "github.com/go-chi/chi/middleware"
 
 	"github.com/go-chi/cors"
 
+	"github.com/madeindra/mock-conversation/server/internal/auth"
 	"github.com/madeindra/mock-conversation/server/internal/config"
 	"github.com/madeindra/mock-conversation/server/internal/data"
+	"github.com/madeindra/mock-conversation/server/internal/keyring"
 	"github.com/madeindra/mock-conversation/server/internal/middleware"
+	"github.com/madeindra/mock-conversation/server/internal/oidc"
 	"github.com/madeindra/mock-conversation/server/internal/openai"
+	"github.com/madeindra/mock-conversation/server/internal/ratelimit"
+	"github.com/madeindra/mock-conversation/server/internal/util"
 )
 
+const sweepInterval = time.Minute
+
+// conversationHeader names the conversation an account request acts on, as
+// account credentials are not tied to a single conversation.
+const conversationHeader = "X-Conversation-ID"
+
 type handler struct {
-	ai openai.Client
-	db *data.Database
+	// ai serves requests that do not call the provider on anyone's behalf;
+	// upstream builds the metered client for those that do
+	ai     openai.Client
+	apiKey string
+	db     *data.Database
+	limits config.Limits
+	memory config.Memory
+
+	tokens     *auth.Signer
+	refreshTTL time.Duration
+	admins     map[string]bool
+
+	rateLimits config.RateLimit
+	rateStore  ratelimit.Store
+
+	budgetLimits config.Budgets
+	budgetLocks  *budgetLocks
+
+	outcomes *outcomeCounter
+
+	// keyring is nil while accounts cannot store provider keys of their own
+	keyring           *keyring.Keyring
+	serverKeyFallback bool
+
+	// sso is nil while single sign-on is not configured
+	sso               *oidc.Provider
+	ssoClientRedirect string
 }
 
 func NewHandler(cfg config.AppConfig) *chi.Mux {
 	h := &handler{
-		ai: openai.NewOpenAI(cfg.APIKey),
-		db: data.New(cfg.DBPath),
+		ai:     openai.NewOpenAI(cfg.APIKey),
+		apiKey: cfg.APIKey,
+		db:     data.New(cfg.DBPath),
+		limits: cfg.Limits,
+		memory: cfg.Memory,
+
+		tokens:     auth.NewSigner(cfg.Auth.TokenSecret, cfg.Auth.AccessTokenTTL),
+		refreshTTL: cfg.Auth.RefreshTokenTTL,
+		admins:     make(map[string]bool, len(cfg.AdminAccountIDs)),
+
+		rateLimits: cfg.RateLimit,
+		rateStore:  cfg.RateLimit.Store,
+
+		budgetLimits: cfg.Budgets,
+		budgetLocks:  newBudgetLocks(),
+
+		outcomes: newOutcomeCounter(),
+
+		serverKeyFallback: !cfg.ProviderKeys.Enabled() || cfg.ProviderKeys.Fallback,
 	}
 
+	if h.rateStore == nil {
+		h.rateStore = ratelimit.NewMemoryStore()
+	}
+
+	if cfg.ProviderKeys.Enabled() {
+		ring, err := keyring.New(cfg.ProviderKeys.MasterKeys)
+		if err != nil {
+			log.Fatal(err)
+		}
+		h.keyring = ring
+	}
+
+	if cfg.OIDC.Enabled() {
+		h.sso = oidc.NewProvider(oidc.Config{
+			Issuer:       cfg.OIDC.Issuer,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL:  cfg.OIDC.RedirectURL,
+		})
+		h.ssoClientRedirect = cfg.OIDC.ClientRedirectURL
+	}
+
+	for _, accountID := range cfg.AdminAccountIDs {
+		h.admins[strings.TrimSpace(accountID)] = true
+	}
+
+	go h.sweepChats(cfg.AbandonAfter, cfg.EndingTimeout)
+	go h.flushOutcomes()
+
 	r := chi.NewRouter()
 
+	if cfg.RateLimit.TrustProxy {
+		r.Use(chimiddleware.RealIP)
+	}
+
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins: cfg.CORSOrigins,
 		AllowedMethods: cfg.CORSMethods,
 		AllowedHeaders: cfg.CORSHeaders,
+		ExposedHeaders: []string{"Retry-After", budgetWarningHeader},
 	}))
+	r.Use(middleware.RecordOutcome(h.recordOutcome))
+	r.Use(middleware.RateLimit(h.rateStore, cfg.RateLimit.IP, ipRateKey))
+
+	// Every route calling the AI provider shares the same slots
+	upstream := middleware.ConcurrencyLimit(cfg.RateLimit.MaxUpstreamCalls)
 
 	r.Get("/chat/status", h.Status)
-	r.Post("/chat/start", h.StartChat)
+	r.Get("/chat/languages", h.Languages)
+	r.With(middleware.RateLimit(h.rateStore, cfg.RateLimit.StartChat, startChatRateKey), upstream).Post("/chat/start", h.StartChat)
+	r.Post("/account/register", h.Register)
+	r.Post("/account/login", h.Login)
+	r.Post("/auth/token", h.IssueToken)
+	r.Post("/auth/refresh", h.RefreshToken)
+	r.Post("/auth/revoke", h.RevokeToken)
+
+	if h.sso != nil {
+		r.With(middleware.RateLimit(h.rateStore, cfg.RateLimit.OIDCLogin, oidcLoginRateKey)).Get("/auth/oidc/login", h.OIDCLogin)
+		r.Get("/auth/oidc/callback", h.OIDCCallback)
+	}
 
 	r.Group(func(r chi.Router) {
-		r.Use(middleware.BasicAuth)
-		r.Post("/chat/answer", h.AnswerChat)
-		r.Get("/chat/end", h.EndChat)
+		r.Use(middleware.APIKeyAuth(h.verifyAPIKey))
+		r.Use(middleware.TokenAuth(h.verifyToken))
+		r.Use(middleware.RateLimit(h.rateStore, cfg.RateLimit.Account, accountRateKey))
+		r.Use(middleware.RateLimit(h.rateStore, cfg.RateLimit.Conversation, conversationRateKey))
+
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.RequireScope(auth.ScopeConversationsWrite))
+			r.With(upstream).Post("/chat/answer", h.AnswerChat)
+			r.With(upstream).Get("/chat/end", h.EndChat)
+			r.With(upstream).Post("/chat/regenerate", h.RegenerateChat)
+			r.Post("/chat/undo", h.UndoChat)
+			r.Post("/chat/fork", h.ForkChat)
+			r.With(upstream).Post("/chat/translate", h.TranslateChat)
+			r.With(upstream).Post("/chat/subtitle", h.SetSubtitleLanguage)
+			r.With(upstream).Get("/chat/glossary", h.ChatGlossary)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.RequireScope(auth.ScopeReportsRead))
+			r.Get("/chat/tree", h.ChatTree)
+			r.Get("/chat/history", h.ChatHistory)
+			r.Get("/account/chats", h.ListChats)
+			r.Get("/account/budget", h.Budget)
+			r.Get("/orgs", h.ListOrganizations)
+			r.Get("/orgs/invites", h.ListInvites)
+			r.Get("/orgs/members", h.ListOrganizationMembers)
+			r.Get("/orgs/classrooms", h.ListClassrooms)
+			r.Get("/classrooms/members", h.ListClassroomMembers)
+			r.Get("/classrooms/progress", h.ClassroomProgress)
+			r.Get("/classrooms/chats", h.ListStudentChats)
+		})
+
+		r.Post("/account/keys", h.CreateAPIKey)
+		r.Get("/account/keys", h.ListAPIKeys)
+		r.Post("/account/keys/revoke", h.RevokeAPIKey)
+		r.Post("/orgs", h.CreateOrganization)
+		r.Post("/orgs/members", h.AddOrganizationMember)
+		r.Post("/orgs/invites/accept", h.AcceptInvite)
+		r.Post("/orgs/invites/decline", h.DeclineInvite)
+		r.Post("/orgs/classrooms", h.CreateClassroom)
+		r.Post("/classrooms/members", h.AddClassroomMember)
+		r.Post("/admin/budgets", h.SetBudget)
+		r.Get("/admin/chats", h.ListConversations)
+		r.Get("/admin/chats/transcript", h.ConversationTranscript)
+		r.Post("/admin/chats/delete", h.DeleteConversation)
+		r.Get("/admin/stats", h.Stats)
+
+		if h.keyring != nil {
+			r.Post("/account/provider-key", h.SetProviderKey)
+			r.Get("/account/provider-key", h.GetProviderKey)
+			r.Post("/account/provider-key/delete", h.DeleteProviderKey)
+			r.Post("/admin/provider-keys/rotate", h.RotateProviderKeys)
+		}
 	})
 
 	return r
 }
+
+// sweepChats periodically ends conversations past their time limit, marks
+// those that have been idle for longer than abandonAfter as abandoned, and
+// puts those that have been ending for longer than endingTimeout back to
+// active. A zero duration skips its check.
+func (h *handler) sweepChats(abandonAfter, endingTimeout time.Duration) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		count, err := h.db.EndExpiredChatUsers(time.Now())
+		if err != nil {
+			log.Printf("failed to end expired chats: %v", err)
+		} else if count > 0 {
+			log.Printf("ended %d chats past their time limit", count)
+		}
+
+		if abandonAfter > 0 {
+			count, err := h.db.AbandonIdleChatUsers(time.Now().Add(-abandonAfter))
+			if err != nil {
+				log.Printf("failed to abandon idle chats: %v", err)
+			} else if count > 0 {
+				log.Printf("marked %d idle chats as abandoned", count)
+			}
+		}
+
+		if endingTimeout > 0 {
+			count, err := h.db.ReleaseEndingChatUsers(time.Now().Add(-endingTimeout))
+			if err != nil {
+				log.Printf("failed to release ending chats: %v", err)
+			} else if count > 0 {
+				log.Printf("put %d chats stuck ending back to active", count)
+			}
+		}
+	}
+}
+
+// authenticate resolves the conversation from the credentials verified by the
+// auth middlewares: a conversation's access token, or account credentials
+// naming one of the account's conversations in the X-Conversation-ID header.
+// It writes the error response itself and reports whether the handler may
+// continue.
+func (h *handler) authenticate(w http.ResponseWriter, req *http.Request) (*data.ChatUser, bool) {
+	return h.resolveChat(w, req, false)
+}
+
+// authenticateViewer is authenticate for handlers that only read the
+// conversation, which teachers may also do for their students' conversations.
+func (h *handler) authenticateViewer(w http.ResponseWriter, req *http.Request) (*data.ChatUser, bool) {
+	return h.resolveChat(w, req, true)
+}
+
+func (h *handler) resolveChat(w http.ResponseWriter, req *http.Request, readOnly bool) (*data.ChatUser, bool) {
+	claims, ok := middleware.Claims(req.Context())
+	if !ok {
+		log.Println("credentials are missing")
+		util.SendResponse(w, nil, "missing required authentication", http.StatusUnauthorized)
+
+		return nil, false
+	}
+
+	var user *data.ChatUser
+	var err error
+	if claims.Kind == auth.KindAccount {
+		user, err = h.db.GetChatUserForAccount(claims.Subject, req.Header.Get(conversationHeader), readOnly)
+	} else {
+		user, err = h.db.GetChatUser(claims.Subject)
+	}
+
+	// Conversations the account may not access are reported as missing
+	if err != nil {
+		log.Printf("failed to get chat user: %v", err)
+		util.SendResponse(w, nil, "failed to get chat user", http.StatusNotFound)
+
+		return nil, false
+	}
+
+	return user, true
+}
+
+// speak generates the audio for a reply in the given language using the voice
+// of the character who said it, or the conversation's voice when there are no
+// characters.
+func (h *handler) speak(ai openai.Client, user *data.ChatUser, characters []data.Character, result openai.AnswerChatResult, language config.Language) (*data.Character, string, error) {
+	voice := user.Voice
+
+	speaker := util.FindSpeaker(characters, result.Speaker)
+	if speaker != nil {
+		voice = speaker.Voice
+	}
+
+	audio, err := h.generateSpeech(ai, result.Response, voice, language)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return speaker, audio, nil
+}
+
+// generateSpeech voices text unless the language has no speech support, in
+// which case no audio is returned and the client falls back to its own speech
+// synthesis.
+func (h *handler) generateSpeech(ai openai.Client, text, voice string, language config.Language) (string, error) {
+	if !config.GetLanguageInfo(language).TTS {
+		return "", nil
+	}
+
+	return util.GenerateSpeech(ai, text, voice, language)
+}
+
+// randomVoices picks n voices for a conversation in the given language,
+// preferring the language's own voices when the registry lists any.
+func (h *handler) randomVoices(language config.Language, n int) []string {
+	voices := config.GetLanguageInfo(language).Voices
+	if len(voices) == 0 {
+		return h.ai.RandomVoices(n)
+	}
+
+	picked := make([]string, n)
+	order := rand.Perm(len(voices))
+	for i := range picked {
+		picked[i] = voices[order[i%len(voices)]]
+	}
+	return picked
+}
+
+// requireStatus writes a conflict response unless the conversation is in one
+// of the given statuses, and reports whether the handler may continue.
+func requireStatus(w http.ResponseWriter, user *data.ChatUser, statuses ...data.Status) bool {
+	for _, status := range statuses {
+		if user.Status == status {
+			return true
+		}
+	}
+
+	log.Printf("chat %s is %s", user.ID, user.Status)
+	util.SendResponse(w, nil, fmt.Sprintf("conversation is %s", user.Status), http.StatusConflict)
+
+	return false
+}
+
+// requireStatusTx is requireStatus against the status read within the
+// transaction, guarding writes against concurrent status changes.
+func (h *handler) requireStatusTx(w http.ResponseWriter, tx *sql.Tx, chatUserID string, statuses ...data.Status) bool {
+	status, err := h.db.GetChatUserStatus(tx, chatUserID)
+	if err != nil {
+		log.Printf("failed to get chat status: %v", err)
+		util.SendResponse(w, nil, "failed to get chat", http.StatusInternalServerError)
+
+		return false
+	}
+
+	return requireStatus(w, &data.ChatUser{ID: chatUserID, Status: status}, statuses...)
+}
+
+// compactHistory folds the oldest turns into the conversation's running
+// summary once the history grows past the token budget, updating user in
+// place. Failing to fold is not fatal: the longer history is sent instead.
+func (h *handler) compactHistory(ai openai.Client, user *data.ChatUser, entries []data.Entry, characters []data.Character) {
+	fold := util.EntriesToFold(user, entries, h.memory)
+	if len(fold) == 0 {
+		return
+	}
+
+	summary, err := util.GenerateSummary(ai, user.Summary, util.ConvertToChatMessage(fold, characters))
+	if err != nil {
+		log.Printf("failed to summarize chat: %v", err)
+		return
+	}
+
+	position := fold[len(fold)-1].Position
+
+	tx, err := h.db.BeginTx()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := h.db.UpdateChatUserSummary(tx, user.ID, summary, position); err != nil {
+		log.Printf("failed to update chat summary: %v", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+		return
+	}
+
+	user.Summary = summary
+	user.SummaryPosition = position
+}
+
+func withinAudioLimit(user *data.ChatUser, seconds float64) bool {
+	return user.MaxAudioSeconds <= 0 || seconds <= float64(user.MaxAudioSeconds)
+}
+
+func sendAudioLimitError(w http.ResponseWriter, user *data.ChatUser, seconds float64) {
+	log.Printf("recording of %.1fs exceeds the %ds limit", seconds, user.MaxAudioSeconds)
+	util.SendResponse(w, nil, fmt.Sprintf("recording is too long, the limit is %d seconds", user.MaxAudioSeconds), http.StatusRequestEntityTooLarge)
+}
+
+// endExpiredChat ends a conversation found past its time limit, unless another
+// request has already moved it on, and tells the client it is over.
+func (h *handler) endExpiredChat(w http.ResponseWriter, user *data.ChatUser) {
+	err := h.db.SetChatUserStatus(user.ID, data.StatusActive, data.StatusEnded)
+	if err != nil && !errors.Is(err, data.ErrInvalidTransition) {
+		log.Printf("failed to end chat: %v", err)
+		util.SendResponse(w, nil, "failed to end chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	log.Printf("chat %s ran past its %d minute limit", user.ID, user.MaxMinutes)
+	util.SendResponse(w, nil, fmt.Sprintf("conversation is over, the limit is %d minutes", user.MaxMinutes), http.StatusConflict)
+}
+
+// transliterationScheme returns the romanization to generate for a turn in
+// the given language, or an empty string when it is turned off.
+func transliterationScheme(user *data.ChatUser, language config.Language) string {
+	if !user.Transliteration {
+		return ""
+	}
+
+	return config.GetTransliteration(language)
+}
+
+func subtitleLanguageName(user *data.ChatUser) string {
+	if user.SubtitleLanguage == "" {
+		return ""
+	}
+
+	return config.GetLanguageName(config.GetCode(user.SubtitleLanguage))
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/madeindra/mock-conversation/server/internal/config"
+	"github.com/madeindra/mock-conversation/server/internal/data"
+	"github.com/madeindra/mock-conversation/server/internal/model"
+	"github.com/madeindra/mock-conversation/server/internal/openai"
+	"github.com/madeindra/mock-conversation/server/internal/util"
+)
+
+// ChatHistory returns the ordered transcript of the conversation, without the
+// system prompt, so that a client can resume it. Audio is left out unless
+// requested with ?audio=true since it makes up most of the payload.
+func (h *handler) ChatHistory(w http.ResponseWriter, req *http.Request) {
+	user, ok := h.authenticateViewer(w, req)
+	if !ok {
+		return
+	}
+
+	includeAudio, _ := strconv.ParseBool(req.URL.Query().Get("audio"))
+
+	entries, err := h.db.GetChatsByChatUserID(user.ID)
+	if err != nil {
+		log.Printf("failed to get chat: %v", err)
+		util.SendResponse(w, nil, "failed to get chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	objectives, err := h.db.GetObjectivesByChatUserID(user.ID)
+	if err != nil {
+		log.Printf("failed to get objectives: %v", err)
+		util.SendResponse(w, nil, "failed to get chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	characters, err := h.db.GetCharactersByChatUserID(user.ID)
+	if err != nil {
+		log.Printf("failed to get characters: %v", err)
+		util.SendResponse(w, nil, "failed to get chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	history := make([]model.HistoryEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Role == string(openai.ROLE_SYSTEM) {
+			continue
+		}
+
+		chat := model.Chat{
+			ID:              entry.ID,
+			Text:            entry.Text,
+			Subtitle:        entry.Subtitle,
+			Transliteration: entry.Transliteration,
+			Speaker:         util.CharacterName(util.CharacterByID(characters, entry.CharacterID)),
+		}
+		if includeAudio {
+			chat.Audio = entry.Audio
+		}
+
+		history = append(history, historyEntry(entry, chat))
+	}
+
+	subtitleLanguage := ""
+	if user.SubtitleLanguage != "" {
+		subtitleLanguage = config.GetCode(user.SubtitleLanguage)
+	}
+
+	response := model.ChatHistoryResponse{
+		ID:               user.ID,
+		Language:         languageCode(user.Language),
+		SubtitleLanguage: subtitleLanguage,
+		Transliteration:  user.Transliteration,
+		Status:           string(user.Status),
+		Limits:           util.ConvertToLimits(user),
+		Objectives:       util.ConvertToObjectives(objectives),
+		Characters:       util.ConvertToCharacters(characters),
+		Entries:          history,
+	}
+
+	util.SendResponse(w, response, "success", http.StatusOK)
+}
+
+// historyEntry wraps chat with the entry's role and the language its subtitle
+// was written in, which may differ from the current subtitle language.
+func historyEntry(entry data.Entry, chat model.Chat) model.HistoryEntry {
+	historyEntry := model.HistoryEntry{Role: entry.Role, Chat: chat}
+	if entry.Language != "" {
+		historyEntry.Language = config.GetCode(entry.Language)
+	}
+	if entry.Subtitle != "" && entry.SubtitleLanguage != "" {
+		historyEntry.SubtitleLanguage = config.GetCode(entry.SubtitleLanguage)
+	}
+
+	return historyEntry
+}
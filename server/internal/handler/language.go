@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/madeindra/mock-conversation/server/internal/config"
+	"github.com/madeindra/mock-conversation/server/internal/data"
+	"github.com/madeindra/mock-conversation/server/internal/model"
+	"github.com/madeindra/mock-conversation/server/internal/openai"
+	"github.com/madeindra/mock-conversation/server/internal/util"
+)
+
+// Languages lists the languages a conversation can be held in and the ones
+// subtitles, translations and glossaries can be shown in.
+func (h *handler) Languages(w http.ResponseWriter, _ *http.Request) {
+	// "auto" follows whatever language the user speaks
+	conversation := append([]model.Language{{Code: config.AutoLanguage, Name: "Automatic", NativeName: "Automatic"}}, util.ConvertToLanguages(config.ConversationLanguages())...)
+
+	response := model.LanguagesResponse{
+		Conversation: conversation,
+		Subtitle:     util.ConvertToLanguages(config.Languages()),
+	}
+
+	util.SendResponse(w, response, "success", http.StatusOK)
+}
+
+// entryLanguage returns the language an entry is written in.
+func entryLanguage(user *data.ChatUser, entry data.Entry) config.Language {
+	if entry.Language != "" {
+		return entry.Language
+	}
+
+	if user.Language == config.AutoLanguage {
+		return config.DefaultLanguage
+	}
+
+	return user.Language
+}
+
+// replyLanguage returns the language the next reply is given in: the
+// conversation language, or in auto mode the language the user last spoke.
+func replyLanguage(user *data.ChatUser, entries []data.Entry) config.Language {
+	if user.Language != config.AutoLanguage {
+		return user.Language
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Role == string(openai.ROLE_USER) && entries[i].Language != "" {
+			return entries[i].Language
+		}
+	}
+
+	return config.DefaultLanguage
+}
+
+// replyLanguageName names the reply language for the AI in auto mode. It is
+// empty otherwise, since the system prompt already fixes the language.
+func replyLanguageName(user *data.ChatUser, language config.Language) string {
+	if user.Language != config.AutoLanguage {
+		return ""
+	}
+
+	return config.GetLanguageInfo(language).Name
+}
+
+// mismatchedLanguage names the language the user spoke in when it is not the
+// conversation language, or returns an empty string.
+func mismatchedLanguage(user *data.ChatUser, spoken config.Language) string {
+	if user.Language == config.AutoLanguage || spoken == "" || spoken == user.Language {
+		return ""
+	}
+
+	return config.GetLanguageInfo(spoken).Name
+}
+
+// languageCode returns the tag clients know the conversation language by.
+func languageCode(language config.Language) string {
+	if language == config.AutoLanguage {
+		return config.AutoLanguage
+	}
+
+	return config.GetCode(language)
+}
+
+// requireLanguage writes a bad request response listing the valid options
+// unless tag is one of them, and reports whether the handler may continue.
+func requireLanguage(w http.ResponseWriter, tag string, options []config.LanguageInfo) bool {
+	tags := make([]string, 0, len(options))
+	for _, option := range options {
+		if strings.EqualFold(option.Tag, tag) {
+			return true
+		}
+		tags = append(tags, option.Tag)
+	}
+
+	log.Printf("unsupported language: %q", tag)
+	util.SendResponse(w, nil, fmt.Sprintf("unsupported language %q, valid options are: %s", tag, strings.Join(tags, ", ")), http.StatusBadRequest)
+
+	return false
+}
@@ -0,0 +1,316 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/madeindra/mock-conversation/server/internal/auth"
+	"github.com/madeindra/mock-conversation/server/internal/data"
+	"github.com/madeindra/mock-conversation/server/internal/model"
+	"github.com/madeindra/mock-conversation/server/internal/oidc"
+	"github.com/madeindra/mock-conversation/server/internal/util"
+)
+
+const (
+	// oidcLoginTimeout is how long the user has to log in at the provider
+	oidcLoginTimeout = 10 * time.Minute
+
+	// oidcStateCookie ties a sign-on attempt to the browser that started it,
+	// so that a callback carrying someone else's state is refused
+	oidcStateCookie = "oidc_state"
+	oidcCookiePath  = "/auth/oidc"
+
+	// maxUsernameAttempts bounds the search for a free username when an
+	// account is created for a new identity
+	maxUsernameAttempts = 20
+)
+
+// OIDCLogin starts single sign-on by sending the browser to the identity
+// provider. The state, nonce and PKCE verifier are kept until the provider
+// sends the user back to OIDCCallback, and a hash of the state is set in a
+// cookie so that only this browser can complete the login.
+func (h *handler) OIDCLogin(w http.ResponseWriter, req *http.Request) {
+	login := data.OIDCLogin{
+		State:    util.GenerateToken(32),
+		Verifier: util.GenerateToken(32),
+		Nonce:    util.GenerateToken(16),
+	}
+
+	authURL, err := h.sso.AuthCodeURL(req.Context(), login.State, login.Nonce, oidc.Challenge(login.Verifier))
+	if err != nil {
+		log.Printf("failed to build authorization URL: %v", err)
+		util.SendResponse(w, nil, "identity provider is unavailable", http.StatusBadGateway)
+
+		return
+	}
+
+	tx, err := h.db.BeginTx()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+		util.SendResponse(w, nil, "failed to start login", http.StatusInternalServerError)
+
+		return
+	}
+	defer tx.Rollback()
+
+	if err := h.db.CreateOIDCLogin(tx, login, time.Now().Add(-oidcLoginTimeout)); err != nil {
+		log.Printf("failed to create login: %v", err)
+		util.SendResponse(w, nil, "failed to start login", http.StatusInternalServerError)
+
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+		util.SendResponse(w, nil, "failed to start login", http.StatusInternalServerError)
+
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    util.HashToken(login.State),
+		Path:     oidcCookiePath,
+		MaxAge:   int(oidcLoginTimeout.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, req, authURL, http.StatusFound)
+}
+
+// OIDCCallback completes single sign-on. It redeems the authorization code,
+// validates the ID token and opens a session for the account linked to the
+// identity, creating the account on first login.
+func (h *handler) OIDCCallback(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+
+	if providerError := query.Get("error"); providerError != "" {
+		log.Printf("identity provider returned %s: %s", providerError, query.Get("error_description"))
+		util.SendResponse(w, nil, "login was not completed", http.StatusUnauthorized)
+
+		return
+	}
+
+	// The state cookie is single use, whatever the outcome
+	cookie, err := req.Cookie(oidcStateCookie)
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     oidcCookiePath,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	// A login started in another browser could otherwise sign this one in
+	// to the account of whoever started it
+	if err != nil || !util.CompareToken(query.Get("state"), cookie.Value) {
+		log.Println("login state does not match the browser")
+		util.SendResponse(w, nil, "invalid login state", http.StatusBadRequest)
+
+		return
+	}
+
+	login, ok := h.takeOIDCLogin(w, query.Get("state"))
+	if !ok {
+		return
+	}
+
+	idToken, err := h.sso.Exchange(req.Context(), query.Get("code"), login.Verifier, login.Nonce)
+	if errors.Is(err, oidc.ErrInvalidIDToken) {
+		log.Printf("failed to validate ID token: %v", err)
+		util.SendResponse(w, nil, "invalid ID token", http.StatusUnauthorized)
+
+		return
+	}
+	if err != nil {
+		log.Printf("failed to exchange authorization code: %v", err)
+		util.SendResponse(w, nil, "failed to complete login with the identity provider", http.StatusBadGateway)
+
+		return
+	}
+
+	account, ok := h.identityAccount(w, idToken)
+	if !ok {
+		return
+	}
+
+	tokens, ok := h.startSession(w, auth.KindAccount, account.ID)
+	if !ok {
+		return
+	}
+
+	if h.ssoClientRedirect == "" {
+		response := model.LoginResponse{
+			AccountResponse: util.ConvertToAccount(account),
+			TokenResponse:   tokens,
+		}
+
+		util.SendResponse(w, response, "success", http.StatusOK)
+
+		return
+	}
+
+	// Tokens go in the fragment, which browsers do not send to servers
+	fragment := url.Values{
+		"accessToken":  {tokens.AccessToken},
+		"refreshToken": {tokens.RefreshToken},
+		"expiresAt":    {tokens.ExpiresAt.Format(time.RFC3339)},
+	}
+
+	http.Redirect(w, req, h.ssoClientRedirect+"#"+fragment.Encode(), http.StatusFound)
+}
+
+// takeOIDCLogin consumes the sign-on attempt the provider's state refers to.
+// It writes the error response itself and reports whether the handler may
+// continue.
+func (h *handler) takeOIDCLogin(w http.ResponseWriter, state string) (*data.OIDCLogin, bool) {
+	if state == "" {
+		log.Println("login state is missing")
+		util.SendResponse(w, nil, "invalid login state", http.StatusBadRequest)
+
+		return nil, false
+	}
+
+	tx, err := h.db.BeginTx()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+		util.SendResponse(w, nil, "failed to complete login", http.StatusInternalServerError)
+
+		return nil, false
+	}
+	defer tx.Rollback()
+
+	login, err := h.db.TakeOIDCLogin(tx, state)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Println("unknown login state")
+		util.SendResponse(w, nil, "invalid login state", http.StatusBadRequest)
+
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("failed to get login: %v", err)
+		util.SendResponse(w, nil, "failed to complete login", http.StatusInternalServerError)
+
+		return nil, false
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+		util.SendResponse(w, nil, "failed to complete login", http.StatusInternalServerError)
+
+		return nil, false
+	}
+
+	if time.Since(login.CreatedAt) > oidcLoginTimeout {
+		log.Println("login state has expired")
+		util.SendResponse(w, nil, "login has expired, please try again", http.StatusBadRequest)
+
+		return nil, false
+	}
+
+	return login, true
+}
+
+// identityAccount returns the account linked to the identity, creating one
+// just in time on its first login. It writes the error response itself and
+// reports whether the handler may continue.
+func (h *handler) identityAccount(w http.ResponseWriter, idToken oidc.IDToken) (*data.Account, bool) {
+	account, err := h.db.GetAccountByIdentity(idToken.Issuer, idToken.Subject)
+	if err == nil {
+		return account, true
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("failed to get account: %v", err)
+		util.SendResponse(w, nil, "failed to get account", http.StatusInternalServerError)
+
+		return nil, false
+	}
+
+	tx, err := h.db.BeginTx()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+		util.SendResponse(w, nil, "failed to create account", http.StatusInternalServerError)
+
+		return nil, false
+	}
+	defer tx.Rollback()
+
+	// Accounts created through single sign-on have no password, so they can
+	// only log in through the identity provider
+	base := identityUsername(idToken)
+	for attempt := 1; attempt <= maxUsernameAttempts && account == nil; attempt++ {
+		username := base
+		if attempt > 1 {
+			username = fmt.Sprintf("%s%d", base, attempt)
+		}
+
+		account, err = h.db.CreateAccount(tx, username, "")
+		if err != nil && !errors.Is(err, data.ErrUsernameTaken) {
+			log.Printf("failed to create account: %v", err)
+			util.SendResponse(w, nil, "failed to create account", http.StatusInternalServerError)
+
+			return nil, false
+		}
+	}
+
+	if account == nil {
+		log.Printf("no free username for %s", base)
+		util.SendResponse(w, nil, "failed to create account", http.StatusConflict)
+
+		return nil, false
+	}
+
+	if err := h.db.LinkAccountIdentity(tx, account.ID, idToken.Issuer, idToken.Subject); err != nil {
+		log.Printf("failed to link account identity: %v", err)
+		util.SendResponse(w, nil, "failed to create account", http.StatusInternalServerError)
+
+		return nil, false
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+		util.SendResponse(w, nil, "failed to create account", http.StatusInternalServerError)
+
+		return nil, false
+	}
+
+	return account, true
+}
+
+// identityUsername picks a username for a new account from the identity's
+// preferred username or email address, keeping only characters that are
+// valid in usernames.
+func identityUsername(idToken oidc.IDToken) string {
+	name := idToken.PreferredUsername
+	if name == "" {
+		name, _, _ = strings.Cut(idToken.Email, "@")
+	}
+
+	var username strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '.' || r == '-' || r == '_' {
+			username.WriteRune(r)
+		}
+	}
+
+	// Room is left for the number added when the username is taken
+	result := username.String()
+	if len(result) > maxUsernameLength-3 {
+		result = result[:maxUsernameLength-3]
+	}
+
+	if len(result) < minUsernameLength {
+		result = "user" + result
+	}
+
+	return result
+}
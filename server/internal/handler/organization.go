@@ -0,0 +1,487 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/madeindra/mock-conversation/server/internal/data"
+	"github.com/madeindra/mock-conversation/server/internal/model"
+	"github.com/madeindra/mock-conversation/server/internal/util"
+)
+
+const maxOrganizationNameLength = 100
+
+// CreateOrganization creates an organization with the caller as its first
+// teacher.
+func (h *handler) CreateOrganization(w http.ResponseWriter, req *http.Request) {
+	account, ok := h.authenticateManager(w, req)
+	if !ok {
+		return
+	}
+
+	var organizationRequest model.OrganizationRequest
+	if err := json.NewDecoder(req.Body).Decode(&organizationRequest); err != nil {
+		log.Printf("failed to read organization request body: %v", err)
+		util.SendResponse(w, nil, "failed to read request", http.StatusBadRequest)
+
+		return
+	}
+
+	name, ok := requireName(w, organizationRequest.Name)
+	if !ok {
+		return
+	}
+
+	tx, err := h.db.BeginTx()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+		util.SendResponse(w, nil, "failed to create organization", http.StatusInternalServerError)
+
+		return
+	}
+	defer tx.Rollback()
+
+	organization, err := h.db.CreateOrganization(tx, name, account.ID)
+	if err != nil {
+		log.Printf("failed to create organization: %v", err)
+		util.SendResponse(w, nil, "failed to create organization", http.StatusInternalServerError)
+
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+		util.SendResponse(w, nil, "failed to create organization", http.StatusInternalServerError)
+
+		return
+	}
+
+	util.SendResponse(w, util.ConvertToOrganization(organization, account.ID), "organization created", http.StatusOK)
+}
+
+// ListOrganizations returns the organizations the caller belongs to, with its
+// role in each.
+func (h *handler) ListOrganizations(w http.ResponseWriter, req *http.Request) {
+	account, ok := h.authenticateAccount(w, req)
+	if !ok {
+		return
+	}
+
+	organizations, err := h.db.GetOrganizationsByAccountID(account.ID)
+	if err != nil {
+		log.Printf("failed to get organizations: %v", err)
+		util.SendResponse(w, nil, "failed to get organizations", http.StatusInternalServerError)
+
+		return
+	}
+
+	response := make([]model.OrganizationResponse, len(organizations))
+	for i := range organizations {
+		response[i] = util.ConvertToOrganization(&organizations[i], account.ID)
+	}
+
+	util.SendResponse(w, response, "success", http.StatusOK)
+}
+
+// AddOrganizationMember invites an account to an organization as a teacher or
+// a student; it joins once it accepts the invite. Only the organization's
+// teachers may invite, and only its owner may change the role of a member.
+func (h *handler) AddOrganizationMember(w http.ResponseWriter, req *http.Request) {
+	account, memberRequest, member, ok := h.readMemberRequest(w, req)
+	if !ok {
+		return
+	}
+
+	tx, err := h.db.BeginTx()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+		util.SendResponse(w, nil, "failed to add member", http.StatusInternalServerError)
+
+		return
+	}
+	defer tx.Rollback()
+
+	invited, err := h.db.AddOrganizationMember(tx, account.ID, memberRequest.OrganizationID, member.ID, data.Role(memberRequest.Role))
+	if !sendOrganizationError(w, err, "failed to add member") {
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+		util.SendResponse(w, nil, "failed to add member", http.StatusInternalServerError)
+
+		return
+	}
+
+	if invited {
+		util.SendResponse(w, nil, "invite sent", http.StatusOK)
+
+		return
+	}
+
+	util.SendResponse(w, nil, "member updated", http.StatusOK)
+}
+
+// ListInvites returns the organizations the caller has been invited to and
+// has yet to answer.
+func (h *handler) ListInvites(w http.ResponseWriter, req *http.Request) {
+	account, ok := h.authenticateAccount(w, req)
+	if !ok {
+		return
+	}
+
+	invites, err := h.db.GetInvitesByAccountID(account.ID)
+	if err != nil {
+		log.Printf("failed to get invites: %v", err)
+		util.SendResponse(w, nil, "failed to get invites", http.StatusInternalServerError)
+
+		return
+	}
+
+	util.SendResponse(w, util.ConvertToInvites(invites), "success", http.StatusOK)
+}
+
+// AcceptInvite makes the caller a member of the organization it was invited
+// to, with the role it was invited with.
+func (h *handler) AcceptInvite(w http.ResponseWriter, req *http.Request) {
+	h.answerInvite(w, req, h.db.AcceptInvite, "invite accepted")
+}
+
+// DeclineInvite turns down the caller's invite to an organization.
+func (h *handler) DeclineInvite(w http.ResponseWriter, req *http.Request) {
+	h.answerInvite(w, req, h.db.DeclineInvite, "invite declined")
+}
+
+func (h *handler) answerInvite(w http.ResponseWriter, req *http.Request, answer func(tx *sql.Tx, accountID, organizationID string) error, message string) {
+	account, ok := h.authenticateManager(w, req)
+	if !ok {
+		return
+	}
+
+	var inviteRequest model.InviteRequest
+	if err := json.NewDecoder(req.Body).Decode(&inviteRequest); err != nil {
+		log.Printf("failed to read invite request body: %v", err)
+		util.SendResponse(w, nil, "failed to read request", http.StatusBadRequest)
+
+		return
+	}
+
+	tx, err := h.db.BeginTx()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+		util.SendResponse(w, nil, "failed to answer invite", http.StatusInternalServerError)
+
+		return
+	}
+	defer tx.Rollback()
+
+	err = answer(tx, account.ID, inviteRequest.OrganizationID)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("account %s has no invite to organization %s", account.ID, inviteRequest.OrganizationID)
+		util.SendResponse(w, nil, "invite not found", http.StatusNotFound)
+
+		return
+	}
+	if err != nil {
+		log.Printf("failed to answer invite: %v", err)
+		util.SendResponse(w, nil, "failed to answer invite", http.StatusInternalServerError)
+
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+		util.SendResponse(w, nil, "failed to answer invite", http.StatusInternalServerError)
+
+		return
+	}
+
+	util.SendResponse(w, nil, message, http.StatusOK)
+}
+
+// ListOrganizationMembers returns the members of the organization given with
+// ?organizationId=. Only the organization's teachers may see them.
+func (h *handler) ListOrganizationMembers(w http.ResponseWriter, req *http.Request) {
+	account, ok := h.authenticateAccount(w, req)
+	if !ok {
+		return
+	}
+
+	members, err := h.db.GetOrganizationMembers(account.ID, req.URL.Query().Get("organizationId"))
+	if !sendOrganizationError(w, err, "failed to get members") {
+		return
+	}
+
+	util.SendResponse(w, util.ConvertToMembers(members), "success", http.StatusOK)
+}
+
+// CreateClassroom adds a classroom to an organization. Only the
+// organization's teachers may do so.
+func (h *handler) CreateClassroom(w http.ResponseWriter, req *http.Request) {
+	account, ok := h.authenticateManager(w, req)
+	if !ok {
+		return
+	}
+
+	var classroomRequest model.ClassroomRequest
+	if err := json.NewDecoder(req.Body).Decode(&classroomRequest); err != nil {
+		log.Printf("failed to read classroom request body: %v", err)
+		util.SendResponse(w, nil, "failed to read request", http.StatusBadRequest)
+
+		return
+	}
+
+	name, ok := requireName(w, classroomRequest.Name)
+	if !ok {
+		return
+	}
+
+	tx, err := h.db.BeginTx()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+		util.SendResponse(w, nil, "failed to create classroom", http.StatusInternalServerError)
+
+		return
+	}
+	defer tx.Rollback()
+
+	classroom, err := h.db.CreateClassroom(tx, account.ID, classroomRequest.OrganizationID, name)
+	if !sendOrganizationError(w, err, "failed to create classroom") {
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+		util.SendResponse(w, nil, "failed to create classroom", http.StatusInternalServerError)
+
+		return
+	}
+
+	util.SendResponse(w, util.ConvertToClassroom(classroom), "classroom created", http.StatusOK)
+}
+
+// ListClassrooms returns the classrooms of the organization given with
+// ?organizationId= that the caller can see: all of them for teachers, and
+// their own for students.
+func (h *handler) ListClassrooms(w http.ResponseWriter, req *http.Request) {
+	account, ok := h.authenticateAccount(w, req)
+	if !ok {
+		return
+	}
+
+	classrooms, err := h.db.GetClassrooms(account.ID, req.URL.Query().Get("organizationId"))
+	if err != nil {
+		log.Printf("failed to get classrooms: %v", err)
+		util.SendResponse(w, nil, "failed to get classrooms", http.StatusInternalServerError)
+
+		return
+	}
+
+	response := make([]model.ClassroomResponse, len(classrooms))
+	for i := range classrooms {
+		response[i] = util.ConvertToClassroom(&classrooms[i])
+	}
+
+	util.SendResponse(w, response, "success", http.StatusOK)
+}
+
+// AddClassroomMember adds a member of the organization to one of its
+// classrooms as a teacher or a student. Only the organization's teachers may
+// do so, only they can teach a classroom, and only the organization's owner
+// may change the role of a classroom member.
+func (h *handler) AddClassroomMember(w http.ResponseWriter, req *http.Request) {
+	account, memberRequest, member, ok := h.readMemberRequest(w, req)
+	if !ok {
+		return
+	}
+
+	tx, err := h.db.BeginTx()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+		util.SendResponse(w, nil, "failed to add member", http.StatusInternalServerError)
+
+		return
+	}
+	defer tx.Rollback()
+
+	err = h.db.AddClassroomMember(tx, account.ID, memberRequest.ClassroomID, member.ID, data.Role(memberRequest.Role))
+	if !sendOrganizationError(w, err, "failed to add member") {
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+		util.SendResponse(w, nil, "failed to add member", http.StatusInternalServerError)
+
+		return
+	}
+
+	util.SendResponse(w, nil, "member added", http.StatusOK)
+}
+
+// ListClassroomMembers returns the teachers and students of the classroom
+// given with ?classroomId=. Only the classroom's teachers may see them.
+func (h *handler) ListClassroomMembers(w http.ResponseWriter, req *http.Request) {
+	account, ok := h.authenticateAccount(w, req)
+	if !ok {
+		return
+	}
+
+	members, err := h.db.GetClassroomMembers(account.ID, req.URL.Query().Get("classroomId"))
+	if !sendOrganizationError(w, err, "failed to get members") {
+		return
+	}
+
+	util.SendResponse(w, util.ConvertToMembers(members), "success", http.StatusOK)
+}
+
+// ClassroomProgress sums up the conversations and objectives of every student
+// in the classroom given with ?classroomId=. Only the classroom's teachers may
+// see it.
+func (h *handler) ClassroomProgress(w http.ResponseWriter, req *http.Request) {
+	account, ok := h.authenticateAccount(w, req)
+	if !ok {
+		return
+	}
+
+	progress, err := h.db.GetClassroomProgress(account.ID, req.URL.Query().Get("classroomId"))
+	if !sendOrganizationError(w, err, "failed to get progress") {
+		return
+	}
+
+	util.SendResponse(w, util.ConvertToStudentProgress(progress), "success", http.StatusOK)
+}
+
+// ListStudentChats returns the conversations a student started in the
+// classroom given with ?classroomId=, newest first, one page at a time, for a
+// teacher of that classroom. The transcripts themselves are read through
+// /chat/history with the X-Conversation-ID header.
+func (h *handler) ListStudentChats(w http.ResponseWriter, req *http.Request) {
+	account, ok := h.authenticateAccount(w, req)
+	if !ok {
+		return
+	}
+
+	page, pageSize, err := pagination(req)
+	if err != nil {
+		log.Printf("invalid pagination: %v", err)
+		util.SendResponse(w, nil, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	query := req.URL.Query()
+	users, total, err := h.db.GetStudentChatUsers(account.ID, query.Get("classroomId"), query.Get("studentId"), pageSize, (page-1)*pageSize)
+	if !sendOrganizationError(w, err, "failed to get chats") {
+		return
+	}
+
+	util.SendResponse(w, conversationList(users, total, page, pageSize), "success", http.StatusOK)
+}
+
+// requireClassroomMember checks that the account may start conversations in
+// the classroom, which takes being one of its members. It writes the error
+// response itself and reports whether the handler may continue.
+func (h *handler) requireClassroomMember(w http.ResponseWriter, account *data.Account, classroomID string) bool {
+	if account == nil {
+		log.Println("classroom conversation started without an account")
+		util.SendResponse(w, nil, "log in to start a conversation in a classroom", http.StatusUnauthorized)
+
+		return false
+	}
+
+	_, err := h.db.GetClassroomRole(classroomID, account.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("account %s is not a member of classroom %s", account.ID, classroomID)
+		util.SendResponse(w, nil, "you are not a member of this classroom", http.StatusForbidden)
+
+		return false
+	}
+	if err != nil {
+		log.Printf("failed to get classroom role: %v", err)
+		util.SendResponse(w, nil, "failed to get classroom", http.StatusInternalServerError)
+
+		return false
+	}
+
+	return true
+}
+
+// readMemberRequest authenticates a membership change and resolves the
+// account it is about. It writes the error response itself and reports
+// whether the handler may continue.
+func (h *handler) readMemberRequest(w http.ResponseWriter, req *http.Request) (*data.Account, model.MemberRequest, *data.Account, bool) {
+	var memberRequest model.MemberRequest
+
+	account, ok := h.authenticateManager(w, req)
+	if !ok {
+		return nil, memberRequest, nil, false
+	}
+
+	if err := json.NewDecoder(req.Body).Decode(&memberRequest); err != nil {
+		log.Printf("failed to read member request body: %v", err)
+		util.SendResponse(w, nil, "failed to read request", http.StatusBadRequest)
+
+		return nil, memberRequest, nil, false
+	}
+
+	if !data.Role(memberRequest.Role).IsValid() {
+		log.Printf("invalid role %q", memberRequest.Role)
+		util.SendResponse(w, nil, fmt.Sprintf("role must be %s or %s", data.RoleTeacher, data.RoleStudent), http.StatusBadRequest)
+
+		return nil, memberRequest, nil, false
+	}
+
+	member, err := h.db.GetAccountByUsername(strings.TrimSpace(memberRequest.Username))
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("account %s not found", memberRequest.Username)
+		util.SendResponse(w, nil, "account not found", http.StatusNotFound)
+
+		return nil, memberRequest, nil, false
+	}
+	if err != nil {
+		log.Printf("failed to get account: %v", err)
+		util.SendResponse(w, nil, "failed to get account", http.StatusInternalServerError)
+
+		return nil, memberRequest, nil, false
+	}
+
+	return account, memberRequest, member, true
+}
+
+// sendOrganizationError writes the response for an error from an
+// organization or classroom access, and reports whether there was none.
+func sendOrganizationError(w http.ResponseWriter, err error, message string) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, data.ErrForbidden):
+		log.Printf("%s: %v", message, err)
+		util.SendResponse(w, nil, "you do not have the role needed for this", http.StatusForbidden)
+	case errors.Is(err, sql.ErrNoRows):
+		log.Printf("%s: %v", message, err)
+		util.SendResponse(w, nil, "organization, classroom or member not found", http.StatusNotFound)
+	default:
+		log.Printf("%s: %v", message, err)
+		util.SendResponse(w, nil, message, http.StatusInternalServerError)
+	}
+
+	return false
+}
+
+func requireName(w http.ResponseWriter, name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxOrganizationNameLength {
+		log.Printf("invalid name %q", name)
+		util.SendResponse(w, nil, fmt.Sprintf("name must be between 1 and %d characters", maxOrganizationNameLength), http.StatusBadRequest)
+
+		return "", false
+	}
+
+	return name, true
+}
@@ -0,0 +1,285 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/madeindra/mock-conversation/server/internal/data"
+	"github.com/madeindra/mock-conversation/server/internal/model"
+	"github.com/madeindra/mock-conversation/server/internal/openai"
+	"github.com/madeindra/mock-conversation/server/internal/util"
+)
+
+const (
+	maxProviderKeyLength  = 256
+	providerKeyHintLength = 4
+)
+
+// SetProviderKey stores the account's own key for the AI provider, replacing
+// any earlier one. The key is checked with the provider first and stored
+// encrypted; it is never returned.
+func (h *handler) SetProviderKey(w http.ResponseWriter, req *http.Request) {
+	account, ok := h.authenticateManager(w, req)
+	if !ok {
+		return
+	}
+
+	var keyRequest model.ProviderKeyRequest
+	if err := json.NewDecoder(req.Body).Decode(&keyRequest); err != nil {
+		log.Printf("failed to read provider key request body: %v", err)
+		util.SendResponse(w, nil, "failed to read request", http.StatusBadRequest)
+
+		return
+	}
+
+	key := strings.TrimSpace(keyRequest.Key)
+	if len(key) <= providerKeyHintLength || len(key) > maxProviderKeyLength {
+		log.Println("invalid provider key length")
+		util.SendResponse(w, nil, fmt.Sprintf("key must be between %d and %d characters", providerKeyHintLength+1, maxProviderKeyLength), http.StatusBadRequest)
+
+		return
+	}
+
+	valid, err := openai.NewOpenAI(key).IsKeyValid()
+	if err != nil {
+		log.Printf("failed to check provider key: %v", err)
+		util.SendResponse(w, nil, "failed to check key with the provider", http.StatusBadGateway)
+
+		return
+	}
+
+	if !valid {
+		log.Printf("provider rejected the key of account %s", account.ID)
+		util.SendResponse(w, nil, "the provider rejected this key", http.StatusBadRequest)
+
+		return
+	}
+
+	// The account ID is bound to the sealed key so that it cannot be moved
+	// to another account
+	masterKeyID, sealedKey, err := h.keyring.Seal([]byte(key), []byte(account.ID))
+	if err != nil {
+		log.Printf("failed to encrypt provider key: %v", err)
+		util.SendResponse(w, nil, "failed to set provider key", http.StatusInternalServerError)
+
+		return
+	}
+
+	tx, err := h.db.BeginTx()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+		util.SendResponse(w, nil, "failed to set provider key", http.StatusInternalServerError)
+
+		return
+	}
+	defer tx.Rollback()
+
+	err = h.db.SetProviderKey(tx, data.ProviderKey{
+		AccountID:   account.ID,
+		MasterKeyID: masterKeyID,
+		SealedKey:   sealedKey,
+		Hint:        "..." + key[len(key)-providerKeyHintLength:],
+	})
+	if err != nil {
+		log.Printf("failed to set provider key: %v", err)
+		util.SendResponse(w, nil, "failed to set provider key", http.StatusInternalServerError)
+
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+		util.SendResponse(w, nil, "failed to set provider key", http.StatusInternalServerError)
+
+		return
+	}
+
+	providerKey, err := h.db.GetProviderKey(account.ID)
+	if err != nil {
+		log.Printf("failed to get provider key: %v", err)
+		util.SendResponse(w, nil, "failed to get provider key", http.StatusInternalServerError)
+
+		return
+	}
+
+	util.SendResponse(w, util.ConvertToProviderKey(providerKey), "provider key set", http.StatusOK)
+}
+
+// GetProviderKey tells whether the account has stored a provider key, and
+// which one by its last characters.
+func (h *handler) GetProviderKey(w http.ResponseWriter, req *http.Request) {
+	account, ok := h.authenticateManager(w, req)
+	if !ok {
+		return
+	}
+
+	providerKey, err := h.db.GetProviderKey(account.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("account %s has no provider key", account.ID)
+		util.SendResponse(w, nil, "no provider key is set", http.StatusNotFound)
+
+		return
+	}
+	if err != nil {
+		log.Printf("failed to get provider key: %v", err)
+		util.SendResponse(w, nil, "failed to get provider key", http.StatusInternalServerError)
+
+		return
+	}
+
+	util.SendResponse(w, util.ConvertToProviderKey(providerKey), "success", http.StatusOK)
+}
+
+// DeleteProviderKey removes the account's provider key. Its conversations go
+// back to the server's key, if the server allows it.
+func (h *handler) DeleteProviderKey(w http.ResponseWriter, req *http.Request) {
+	account, ok := h.authenticateManager(w, req)
+	if !ok {
+		return
+	}
+
+	tx, err := h.db.BeginTx()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+		util.SendResponse(w, nil, "failed to delete provider key", http.StatusInternalServerError)
+
+		return
+	}
+	defer tx.Rollback()
+
+	err = h.db.DeleteProviderKey(tx, account.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("account %s has no provider key", account.ID)
+		util.SendResponse(w, nil, "no provider key is set", http.StatusNotFound)
+
+		return
+	}
+	if err != nil {
+		log.Printf("failed to delete provider key: %v", err)
+		util.SendResponse(w, nil, "failed to delete provider key", http.StatusInternalServerError)
+
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+		util.SendResponse(w, nil, "failed to delete provider key", http.StatusInternalServerError)
+
+		return
+	}
+
+	util.SendResponse(w, nil, "provider key deleted", http.StatusOK)
+}
+
+// RotateProviderKeys re-encrypts every provider key stored under an earlier
+// master key with the current one, after which the earlier master keys can be
+// retired. Keys that cannot be decrypted are left alone and counted as
+// failed. Only admins may do so.
+func (h *handler) RotateProviderKeys(w http.ResponseWriter, req *http.Request) {
+	if _, ok := h.authenticateAdmin(w, req); !ok {
+		return
+	}
+
+	masterKeyID := h.keyring.CurrentKeyID()
+
+	keys, err := h.db.GetProviderKeysSealedWithout(masterKeyID)
+	if err != nil {
+		log.Printf("failed to get provider keys: %v", err)
+		util.SendResponse(w, nil, "failed to rotate provider keys", http.StatusInternalServerError)
+
+		return
+	}
+
+	tx, err := h.db.BeginTx()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+		util.SendResponse(w, nil, "failed to rotate provider keys", http.StatusInternalServerError)
+
+		return
+	}
+	defer tx.Rollback()
+
+	response := model.ProviderKeyRotationResponse{MasterKeyID: masterKeyID}
+
+	for _, key := range keys {
+		secret, err := h.keyring.Open(key.MasterKeyID, key.SealedKey, []byte(key.AccountID))
+		if err != nil {
+			log.Printf("failed to decrypt provider key of account %s: %v", key.AccountID, err)
+			response.Failed++
+
+			continue
+		}
+
+		resealedID, sealedKey, err := h.keyring.Seal(secret, []byte(key.AccountID))
+		if err != nil {
+			log.Printf("failed to encrypt provider key: %v", err)
+			util.SendResponse(w, nil, "failed to rotate provider keys", http.StatusInternalServerError)
+
+			return
+		}
+
+		// A key replaced since it was read is already sealed with the
+		// current master key
+		rotated, err := h.db.ResealProviderKey(tx, key.AccountID, key.MasterKeyID, resealedID, sealedKey)
+		if err != nil {
+			log.Printf("failed to update provider key: %v", err)
+			util.SendResponse(w, nil, "failed to rotate provider keys", http.StatusInternalServerError)
+
+			return
+		}
+
+		if rotated {
+			response.Rotated++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+		util.SendResponse(w, nil, "failed to rotate provider keys", http.StatusInternalServerError)
+
+		return
+	}
+
+	util.SendResponse(w, response, "provider keys rotated", http.StatusOK)
+}
+
+// providerKey returns the key for calls to the AI provider made on behalf of
+// the account, and whether it is the account's own: the key it stored, or
+// else the server's key when the server allows it. It writes the error
+// response itself and reports whether the handler may continue.
+func (h *handler) providerKey(w http.ResponseWriter, accountID string) (string, bool, bool) {
+	if h.keyring != nil && accountID != "" {
+		providerKey, err := h.db.GetProviderKey(accountID)
+		switch {
+		case err == nil:
+			key, err := h.keyring.Open(providerKey.MasterKeyID, providerKey.SealedKey, []byte(accountID))
+			if err != nil {
+				log.Printf("failed to decrypt provider key of account %s: %v", accountID, err)
+				util.SendResponse(w, nil, "failed to read your provider key, please set it again", http.StatusInternalServerError)
+
+				return "", false, false
+			}
+
+			return string(key), true, true
+		case !errors.Is(err, sql.ErrNoRows):
+			log.Printf("failed to get provider key: %v", err)
+			util.SendResponse(w, nil, "failed to get provider key", http.StatusInternalServerError)
+
+			return "", false, false
+		}
+	}
+
+	if !h.serverKeyFallback {
+		log.Printf("no provider key for account %q and the server key is not shared", accountID)
+		util.SendResponse(w, nil, "a provider key of your own is needed, set one in your account", http.StatusForbidden)
+
+		return "", false, false
+	}
+
+	return h.apiKey, false, true
+}
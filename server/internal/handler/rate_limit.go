@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/madeindra/mock-conversation/server/internal/auth"
+	"github.com/madeindra/mock-conversation/server/internal/middleware"
+)
+
+func ipRateKey(req *http.Request) string {
+	return "ip:" + middleware.ClientIP(req)
+}
+
+// oidcLoginRateKey names a bucket of its own for the client IP, apart from
+// the one every request takes from.
+func oidcLoginRateKey(req *http.Request) string {
+	return "oidc-login:" + middleware.ClientIP(req)
+}
+
+// startChatRateKey names a bucket of its own for the client IP, apart from
+// the one every request takes from.
+func startChatRateKey(req *http.Request) string {
+	return "start-chat:" + middleware.ClientIP(req)
+}
+
+func accountRateKey(req *http.Request) string {
+	claims, ok := middleware.Claims(req.Context())
+	if !ok || claims.Kind != auth.KindAccount {
+		return ""
+	}
+
+	return "account:" + claims.Subject
+}
+
+// conversationRateKey names the bucket of the conversation the request acts
+// on. Account requests get a bucket per account and conversation, so that
+// naming someone else's conversation cannot use up its owner's requests.
+func conversationRateKey(req *http.Request) string {
+	claims, ok := middleware.Claims(req.Context())
+	if !ok {
+		return ""
+	}
+
+	if claims.Kind == auth.KindChat {
+		return "conversation:" + claims.Subject
+	}
+
+	id := req.Header.Get(conversationHeader)
+	if id == "" {
+		return ""
+	}
+
+	return "conversation:" + claims.Subject + ":" + id
+}
+
+// allowAccount takes a token from the account's bucket for requests that
+// authenticate in the handler rather than through middleware. It writes the
+// error response itself and reports whether the handler may continue.
+func (h *handler) allowAccount(w http.ResponseWriter, req *http.Request, accountID string) bool {
+	if !h.rateLimits.Account.Enabled() {
+		return true
+	}
+
+	retryAfter, err := h.rateStore.Take(req.Context(), "account:"+accountID, h.rateLimits.Account)
+	if err != nil {
+		log.Printf("failed to check rate limit: %v", err)
+		return true
+	}
+
+	if retryAfter > 0 {
+		log.Printf("rate limit reached for account %s", accountID)
+		middleware.SendTooManyRequests(w, retryAfter)
+
+		return false
+	}
+
+	return true
+}
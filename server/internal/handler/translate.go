@@ -0,0 +1,290 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/madeindra/mock-conversation/server/internal/config"
+	"github.com/madeindra/mock-conversation/server/internal/data"
+	"github.com/madeindra/mock-conversation/server/internal/model"
+	"github.com/madeindra/mock-conversation/server/internal/openai"
+	"github.com/madeindra/mock-conversation/server/internal/util"
+)
+
+// TranslateChat translates a single turn of the conversation into any
+// supported language. Translations are cached per entry and language, so
+// asking for the same line again does not call the AI.
+func (h *handler) TranslateChat(w http.ResponseWriter, req *http.Request) {
+	user, ok := h.authenticate(w, req)
+	if !ok {
+		return
+	}
+
+	var translateChatRequest model.TranslateChatRequest
+	if err := json.NewDecoder(req.Body).Decode(&translateChatRequest); err != nil {
+		log.Printf("failed to read translate chat request body: %v", err)
+		util.SendResponse(w, nil, "failed to read request", http.StatusBadRequest)
+
+		return
+	}
+
+	if !requireLanguage(w, translateChatRequest.Language, config.Languages()) {
+		return
+	}
+
+	entry, err := h.db.GetChat(user.ID, translateChatRequest.EntryID)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("translation entry not found: %s", translateChatRequest.EntryID)
+		util.SendResponse(w, nil, "entry not found", http.StatusNotFound)
+
+		return
+	}
+	if err != nil {
+		log.Printf("failed to get chat: %v", err)
+		util.SendResponse(w, nil, "failed to get chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	if entry.Role == string(openai.ROLE_SYSTEM) {
+		log.Println("cannot translate system entry")
+		util.SendResponse(w, nil, "only conversation turns can be translated", http.StatusBadRequest)
+
+		return
+	}
+
+	language := config.GetLanguage(translateChatRequest.Language)
+
+	translation, ok, err := h.knownTranslation(user, entry, language)
+	if err != nil {
+		log.Printf("failed to get translation: %v", err)
+		util.SendResponse(w, nil, "failed to translate chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	// Only a translation that is not known yet calls the AI, and so counts
+	// against the budgets
+	if !ok {
+		ai, release, ok := h.upstream(w, req, user.AccountID, false)
+		if !ok {
+			return
+		}
+		defer release()
+
+		translation, err = h.translateEntry(ai, entry, language)
+		if err != nil {
+			log.Printf("failed to translate chat: %v", err)
+			util.SendResponse(w, nil, "failed to translate chat", http.StatusInternalServerError)
+
+			return
+		}
+	}
+
+	response := model.TranslateChatResponse{
+		EntryID:     entry.ID,
+		Language:    translateChatRequest.Language,
+		Text:        entry.Text,
+		Translation: translation,
+	}
+
+	util.SendResponse(w, response, "success", http.StatusOK)
+}
+
+// SetSubtitleLanguage changes the subtitle language of an active conversation,
+// or turns subtitles off with an empty language. With backfill set, earlier
+// turns get subtitles in the new language as well; otherwise they keep the
+// ones they were created with.
+func (h *handler) SetSubtitleLanguage(w http.ResponseWriter, req *http.Request) {
+	user, ok := h.authenticate(w, req)
+	if !ok {
+		return
+	}
+
+	if !requireStatus(w, user, data.StatusActive) {
+		return
+	}
+
+	var subtitleChatRequest model.SubtitleChatRequest
+	if err := json.NewDecoder(req.Body).Decode(&subtitleChatRequest); err != nil {
+		log.Printf("failed to read subtitle chat request body: %v", err)
+		util.SendResponse(w, nil, "failed to read request", http.StatusBadRequest)
+
+		return
+	}
+
+	language := ""
+	if subtitleChatRequest.Language != "" {
+		if !requireLanguage(w, subtitleChatRequest.Language, config.Languages()) {
+			return
+		}
+
+		language = config.GetLanguage(subtitleChatRequest.Language)
+	}
+
+	var backfilled []data.Entry
+	if subtitleChatRequest.Backfill && language != "" {
+		entries, err := h.db.GetChatsByChatUserID(user.ID)
+		if err != nil {
+			log.Printf("failed to get chat: %v", err)
+			util.SendResponse(w, nil, "failed to get chat", http.StatusInternalServerError)
+
+			return
+		}
+
+		// Known translations are filled in first, and the AI is only
+		// called, and the budgets checked, when some are missing
+		var missing []int
+		for _, entry := range entries {
+			if entry.Role == string(openai.ROLE_SYSTEM) || (entry.Subtitle != "" && entry.SubtitleLanguage == language) {
+				continue
+			}
+
+			subtitle, ok, err := h.knownTranslation(user, &entry, language)
+			if err != nil {
+				log.Printf("failed to get translation: %v", err)
+				util.SendResponse(w, nil, "failed to update subtitles", http.StatusInternalServerError)
+
+				return
+			}
+			if !ok {
+				missing = append(missing, len(backfilled))
+			}
+
+			entry.Subtitle = subtitle
+			entry.SubtitleLanguage = language
+			backfilled = append(backfilled, entry)
+		}
+
+		if len(missing) > 0 {
+			ai, release, ok := h.upstream(w, req, user.AccountID, false)
+			if !ok {
+				return
+			}
+			defer release()
+
+			for _, i := range missing {
+				subtitle, err := h.translateEntry(ai, &backfilled[i], language)
+				if err != nil {
+					log.Printf("failed to translate chat: %v", err)
+					util.SendResponse(w, nil, "failed to update subtitles", http.StatusInternalServerError)
+
+					return
+				}
+
+				backfilled[i].Subtitle = subtitle
+			}
+		}
+	}
+
+	tx, err := h.db.BeginTx()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+		util.SendResponse(w, nil, "failed to update subtitles", http.StatusInternalServerError)
+
+		return
+	}
+	defer tx.Rollback()
+
+	if !h.requireStatusTx(w, tx, user.ID, data.StatusActive) {
+		return
+	}
+
+	if err := h.db.UpdateChatUserSubtitleLanguage(tx, user.ID, language); err != nil {
+		log.Printf("failed to update subtitle language: %v", err)
+		util.SendResponse(w, nil, "failed to update subtitles", http.StatusInternalServerError)
+
+		return
+	}
+
+	if err := h.db.UpdateChatSubtitles(tx, backfilled); err != nil {
+		log.Printf("failed to update subtitles: %v", err)
+		util.SendResponse(w, nil, "failed to update subtitles", http.StatusInternalServerError)
+
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+		util.SendResponse(w, nil, "failed to update subtitles", http.StatusInternalServerError)
+
+		return
+	}
+
+	characters, err := h.db.GetCharactersByChatUserID(user.ID)
+	if err != nil {
+		log.Printf("failed to get characters: %v", err)
+		util.SendResponse(w, nil, "failed to get chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	response := model.SubtitleChatResponse{}
+	if language != "" {
+		response.SubtitleLanguage = config.GetCode(language)
+	}
+
+	for _, entry := range backfilled {
+		response.Entries = append(response.Entries, historyEntry(entry, model.Chat{
+			ID:              entry.ID,
+			Text:            entry.Text,
+			Subtitle:        entry.Subtitle,
+			Transliteration: entry.Transliteration,
+			Speaker:         util.CharacterName(util.CharacterByID(characters, entry.CharacterID)),
+		}))
+	}
+
+	util.SendResponse(w, response, "success", http.StatusOK)
+}
+
+// knownTranslation returns the entry's text in the given language when it is
+// known without calling the AI: entries already in that language as they are,
+// then their subtitle or a cached translation. It reports whether one was
+// found.
+func (h *handler) knownTranslation(user *data.ChatUser, entry *data.Entry, language config.Language) (string, bool, error) {
+	if language == entryLanguage(user, *entry) {
+		return entry.Text, true, nil
+	}
+
+	if entry.Subtitle != "" && entry.SubtitleLanguage == language {
+		return entry.Subtitle, true, nil
+	}
+
+	cached, err := h.db.GetTranslation(entry.ID, language)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	return cached.Text, true, nil
+}
+
+// translateEntry translates the entry into the given language with the AI and
+// caches the translation. Callers look for a known translation first.
+func (h *handler) translateEntry(ai openai.Client, entry *data.Entry, language config.Language) (string, error) {
+	translation, err := util.GenerateTranslation(ai, entry.Text, config.GetLanguageName(config.GetCode(language)))
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := h.db.BeginTx()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if _, err := h.db.SaveTranslation(tx, data.Translation{ChatID: entry.ID, Language: language, Text: translation}); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	return translation, nil
+}
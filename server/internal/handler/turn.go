@@ -0,0 +1,327 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/madeindra/mock-conversation/server/internal/config"
+	"github.com/madeindra/mock-conversation/server/internal/data"
+	"github.com/madeindra/mock-conversation/server/internal/model"
+	"github.com/madeindra/mock-conversation/server/internal/openai"
+	"github.com/madeindra/mock-conversation/server/internal/util"
+)
+
+// RegenerateChat replaces the last assistant reply with a new one generated
+// from the same history. The replaced reply is soft-deleted.
+func (h *handler) RegenerateChat(w http.ResponseWriter, req *http.Request) {
+	user, ok := h.authenticate(w, req)
+	if !ok {
+		return
+	}
+
+	if !requireStatus(w, user, data.StatusActive) {
+		return
+	}
+
+	// Past the time limit no new reply is generated; the conversation is
+	// over, as it is for an answer
+	if util.TimeLimitReached(user, time.Now()) {
+		h.endExpiredChat(w, user)
+
+		return
+	}
+
+	ai, release, ok := h.upstream(w, req, user.AccountID, false)
+	if !ok {
+		return
+	}
+	defer release()
+
+	entries, err := h.db.GetChatsByChatUserID(user.ID)
+	if err != nil {
+		log.Printf("failed to get chat: %v", err)
+		util.SendResponse(w, nil, "failed to get chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	if len(entries) < 2 || entries[len(entries)-1].Role != string(openai.ROLE_ASSISTANT) {
+		log.Println("no reply to regenerate")
+		util.SendResponse(w, nil, "there is no reply to regenerate", http.StatusBadRequest)
+
+		return
+	}
+
+	last := entries[len(entries)-1]
+	previous := entries[len(entries)-2]
+
+	objectives, err := h.db.GetObjectivesByChatUserID(user.ID)
+	if err != nil {
+		log.Printf("failed to get objectives: %v", err)
+		util.SendResponse(w, nil, "failed to get chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	characters, err := h.db.GetCharactersByChatUserID(user.ID)
+	if err != nil {
+		log.Printf("failed to get characters: %v", err)
+		util.SendResponse(w, nil, "failed to get chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	// The new reply keeps the language of the one it replaces
+	language := entryLanguage(user, last)
+
+	// Objectives completed by the user's message are evaluated again
+	opts := util.ChatOptions{
+		SubtitleLanguage: subtitleLanguageName(user),
+		Transliteration:  transliterationScheme(user, language),
+		ReplyLanguage:    replyLanguageName(user, language),
+		LanguageMismatch: mismatchedLanguage(user, previous.Language),
+		Objectives:       util.ReopenedObjectives(objectives, previous.ID),
+		Characters:       characters,
+	}
+
+	// The reply is regenerated the same way it was produced: as an answer to
+	// the user's message, as the opening greeting, or as the farewell
+	var result openai.AnswerChatResult
+	switch openai.Role(previous.Role) {
+	case openai.ROLE_USER:
+		opts.Closing = util.ConversationClosing(user, util.CountTurns(entries), time.Now())
+
+		history := util.BuildHistory(user, entries[:len(entries)-2], characters)
+		result, err = util.GenerateAnswerChat(ai, history, previous.Text, opts)
+		result.Transcript = previous.Text
+		result.IsLast = result.IsLast || opts.Closing == util.ClosingNow
+	case openai.ROLE_SYSTEM:
+		result, err = util.GenerateGreeting(ai, previous.Text, opts)
+	default:
+		history := util.BuildHistory(user, entries[:len(entries)-1], characters)
+		result, err = util.GenerateEndChat(ai, history, opts)
+	}
+	if err != nil {
+		log.Printf("failed to get chat completion: %v", err)
+		util.SendResponse(w, nil, "failed to get chat completion", http.StatusInternalServerError)
+
+		return
+	}
+
+	speaker, answerAudio, err := h.speak(ai, user, characters, result, language)
+	if err != nil {
+		log.Printf("failed to generate speech: %v", err)
+		util.SendResponse(w, nil, "failed to generate speech", http.StatusInternalServerError)
+
+		return
+	}
+
+	tx, err := h.db.BeginTx()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+		util.SendResponse(w, nil, "failed to regenerate chat", http.StatusInternalServerError)
+
+		return
+	}
+	defer tx.Rollback()
+
+	if !h.requireStatusTx(w, tx, user.ID, data.StatusActive) {
+		return
+	}
+
+	if err := h.db.DeleteChats(tx, []string{last.ID}); err != nil {
+		log.Printf("failed to delete chat: %v", err)
+		util.SendResponse(w, nil, "failed to regenerate chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	reply, err := h.db.CreateChat(tx, user.ID, data.Entry{
+		Role:             string(openai.ROLE_ASSISTANT),
+		Text:             result.Response,
+		Language:         language,
+		Audio:            answerAudio,
+		Subtitle:         result.ResponseSubtitle,
+		SubtitleLanguage: user.SubtitleLanguage,
+		Transliteration:  result.ResponseTransliteration,
+		CharacterID:      util.CharacterID(speaker),
+	})
+	if err != nil {
+		log.Printf("failed to create chat: %v", err)
+		util.SendResponse(w, nil, "failed to regenerate chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	if previous.Role == string(openai.ROLE_USER) {
+		if err := h.db.ReopenObjectives(tx, []string{previous.ID}); err != nil {
+			log.Printf("failed to reopen objectives: %v", err)
+			util.SendResponse(w, nil, "failed to regenerate chat", http.StatusInternalServerError)
+
+			return
+		}
+
+		completedIDs := util.CompletedObjectiveIDs(opts.Objectives, result.CompletedObjectives)
+		if err := h.db.CompleteObjectives(tx, completedIDs, previous.ID); err != nil {
+			log.Printf("failed to complete objectives: %v", err)
+			util.SendResponse(w, nil, "failed to regenerate chat", http.StatusInternalServerError)
+
+			return
+		}
+
+		objectives = util.MarkObjectivesCompleted(opts.Objectives, completedIDs, previous.ID)
+		if util.AllObjectivesCompleted(objectives) {
+			result.IsLast = true
+		}
+	}
+
+	if result.IsLast {
+		if err := h.db.UpdateChatUserStatus(tx, user.ID, data.StatusActive, data.StatusEnded); err != nil {
+			log.Printf("failed to end chat: %v", err)
+			util.SendResponse(w, nil, "failed to regenerate chat", http.StatusInternalServerError)
+
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+		util.SendResponse(w, nil, "failed to regenerate chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	response := model.AnswerChatResponse{
+		Language:         config.GetCode(language),
+		IsLast:           result.IsLast,
+		LanguageMismatch: previous.Role == string(openai.ROLE_USER) && opts.LanguageMismatch != "",
+		Objectives:       util.ConvertToObjectives(objectives),
+		Answer: model.Chat{
+			ID:              reply.ID,
+			Text:            result.Response,
+			Audio:           answerAudio,
+			Subtitle:        result.ResponseSubtitle,
+			Transliteration: result.ResponseTransliteration,
+			Speaker:         util.CharacterName(speaker),
+		},
+	}
+
+	if previous.Role == string(openai.ROLE_USER) {
+		response.Prompt = model.Chat{
+			ID:              previous.ID,
+			Text:            result.Transcript,
+			Subtitle:        result.TranscriptSubtitle,
+			Transliteration: result.TranscriptTransliteration,
+		}
+	}
+
+	util.SendResponse(w, response, "success", http.StatusOK)
+}
+
+// UndoChat removes the last user message together with the reply to it, so the
+// conversation continues from the reply before them.
+func (h *handler) UndoChat(w http.ResponseWriter, req *http.Request) {
+	user, ok := h.authenticate(w, req)
+	if !ok {
+		return
+	}
+
+	if !requireStatus(w, user, data.StatusActive) {
+		return
+	}
+
+	entries, err := h.db.GetChatsByChatUserID(user.ID)
+	if err != nil {
+		log.Printf("failed to get chat: %v", err)
+		util.SendResponse(w, nil, "failed to get chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	n := len(entries)
+	if n < 3 || entries[n-1].Role != string(openai.ROLE_ASSISTANT) || entries[n-2].Role != string(openai.ROLE_USER) {
+		log.Println("no turn to undo")
+		util.SendResponse(w, nil, "there is no turn to undo", http.StatusBadRequest)
+
+		return
+	}
+
+	characters, err := h.db.GetCharactersByChatUserID(user.ID)
+	if err != nil {
+		log.Printf("failed to get characters: %v", err)
+		util.SendResponse(w, nil, "failed to get chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	tx, err := h.db.BeginTx()
+	if err != nil {
+		log.Printf("failed to begin transaction: %v", err)
+		util.SendResponse(w, nil, "failed to undo chat", http.StatusInternalServerError)
+
+		return
+	}
+	defer tx.Rollback()
+
+	if !h.requireStatusTx(w, tx, user.ID, data.StatusActive) {
+		return
+	}
+
+	if err := h.db.DeleteChats(tx, []string{entries[n-2].ID, entries[n-1].ID}); err != nil {
+		log.Printf("failed to delete chats: %v", err)
+		util.SendResponse(w, nil, "failed to undo chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	if err := h.db.ReopenObjectives(tx, []string{entries[n-2].ID}); err != nil {
+		log.Printf("failed to reopen objectives: %v", err)
+		util.SendResponse(w, nil, "failed to undo chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	// A summary that covers the undone turn no longer matches the history, so
+	// it is dropped and rebuilt on a later turn
+	if entries[n-2].Position <= user.SummaryPosition {
+		if err := h.db.UpdateChatUserSummary(tx, user.ID, "", 0); err != nil {
+			log.Printf("failed to reset chat summary: %v", err)
+			util.SendResponse(w, nil, "failed to undo chat", http.StatusInternalServerError)
+
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("failed to commit transaction: %v", err)
+		util.SendResponse(w, nil, "failed to undo chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	objectives, err := h.db.GetObjectivesByChatUserID(user.ID)
+	if err != nil {
+		log.Printf("failed to get objectives: %v", err)
+		util.SendResponse(w, nil, "failed to get chat", http.StatusInternalServerError)
+
+		return
+	}
+
+	lastReply := entries[n-3]
+
+	response := model.UndoChatResponse{
+		Language:   config.GetCode(entryLanguage(user, lastReply)),
+		Objectives: util.ConvertToObjectives(objectives),
+		LastReply: model.Chat{
+			ID:              lastReply.ID,
+			Text:            lastReply.Text,
+			Audio:           lastReply.Audio,
+			Subtitle:        lastReply.Subtitle,
+			Transliteration: lastReply.Transliteration,
+			Speaker:         util.CharacterName(util.CharacterByID(characters, lastReply.CharacterID)),
+		},
+	}
+
+	util.SendResponse(w, response, "success", http.StatusOK)
+}
@@ -0,0 +1,109 @@
+// Package keyring encrypts secrets at rest with AES-256-GCM under a set of
+// master keys, so that the master key can be rotated without losing access to
+// secrets sealed under an earlier one.
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// KeySize is the length of a master key in bytes
+const KeySize = 32
+
+var (
+	ErrUnknownKey = errors.New("secret was sealed with an unknown master key")
+	ErrDecrypt    = errors.New("failed to decrypt secret")
+)
+
+// Keyring seals secrets with its current master key and opens secrets sealed
+// with any of its keys. Every key is known by an ID derived from the key
+// itself, which is stored with the sealed secret.
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// New builds a keyring from master keys of KeySize bytes. The first key seals
+// new secrets; the others only open secrets sealed before a rotation.
+func New(masterKeys [][]byte) (*Keyring, error) {
+	if len(masterKeys) == 0 {
+		return nil, errors.New("at least one master key is needed")
+	}
+
+	k := &Keyring{keys: make(map[string]cipher.AEAD, len(masterKeys))}
+	for i, key := range masterKeys {
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("master key %d is %d bytes, not %d", i+1, len(key), KeySize)
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		id := keyID(key)
+		if i == 0 {
+			k.current = id
+		}
+		k.keys[id] = aead
+	}
+
+	return k, nil
+}
+
+// CurrentKeyID is the ID of the master key new secrets are sealed with.
+func (k *Keyring) CurrentKeyID() string {
+	return k.current
+}
+
+// Seal encrypts the secret with the current master key. The associated data
+// is authenticated but not stored, so the same data is needed to open the
+// secret again; it binds the secret to its owner.
+func (k *Keyring) Seal(secret, associatedData []byte) (string, []byte, error) {
+	aead := k.keys[k.current]
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+
+	return k.current, aead.Seal(nonce, nonce, secret, associatedData), nil
+}
+
+// Open decrypts a secret sealed with the master key of the given ID.
+func (k *Keyring) Open(keyID string, sealed, associatedData []byte) ([]byte, error) {
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+	secret, err := aead.Open(nil, nonce, ciphertext, associatedData)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	return secret, nil
+}
+
+// keyID fingerprints a master key without revealing it.
+func keyID(key []byte) string {
+	sum := sha256.Sum256(append([]byte("mock-conversation keyring "), key...))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,119 @@
+package keyring
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, KeySize)
+}
+
+func TestOpen(t *testing.T) {
+	oldKey, currentKey := testKey(1), testKey(2)
+
+	before, err := New([][]byte{oldKey})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// The old key was rotated out for the current one, and is kept to open
+	// what it sealed
+	rotated, err := New([][]byte{currentKey, oldKey})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// The old key is dropped entirely
+	dropped, err := New([][]byte{currentKey})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	secret, owner := []byte("sk-secret"), []byte("account-1")
+
+	keyID, sealed, err := before.Seal(secret, owner)
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+
+	tamperedSealed := bytes.Clone(sealed)
+	tamperedSealed[len(tamperedSealed)-1] ^= 1
+
+	tests := []struct {
+		name           string
+		keyring        *Keyring
+		keyID          string
+		sealed         []byte
+		associatedData []byte
+		err            error
+	}{
+		{name: "round trip", keyring: before, keyID: keyID, sealed: sealed, associatedData: owner},
+		{name: "key rotated out but kept", keyring: rotated, keyID: keyID, sealed: sealed, associatedData: owner},
+		{name: "key rotated out and dropped", keyring: dropped, keyID: keyID, sealed: sealed, associatedData: owner, err: ErrUnknownKey},
+		{name: "wrong associated data", keyring: before, keyID: keyID, sealed: sealed, associatedData: []byte("account-2"), err: ErrDecrypt},
+		{name: "no associated data", keyring: before, keyID: keyID, sealed: sealed, err: ErrDecrypt},
+		{name: "another key under the same ID", keyring: dropped, keyID: dropped.CurrentKeyID(), sealed: sealed, associatedData: owner, err: ErrDecrypt},
+		{name: "tampered ciphertext", keyring: before, keyID: keyID, sealed: tamperedSealed, associatedData: owner, err: ErrDecrypt},
+		{name: "shorter than a nonce", keyring: before, keyID: keyID, sealed: sealed[:4], associatedData: owner, err: ErrDecrypt},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.keyring.Open(tt.keyID, tt.sealed, tt.associatedData)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Open() error = %v, want %v", err, tt.err)
+			}
+			if tt.err == nil && !bytes.Equal(got, secret) {
+				t.Errorf("Open() = %q, want %q", got, secret)
+			}
+		})
+	}
+}
+
+func TestSeal(t *testing.T) {
+	rotated, err := New([][]byte{testKey(2), testKey(1)})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	keyID, first, err := rotated.Seal([]byte("sk-secret"), nil)
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	if keyID != rotated.CurrentKeyID() {
+		t.Errorf("Seal() key ID = %q, want the current %q", keyID, rotated.CurrentKeyID())
+	}
+
+	_, second, err := rotated.Seal([]byte("sk-secret"), nil)
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	if bytes.Equal(first, second) {
+		t.Error("Seal() gave the same output twice, want a fresh nonce each time")
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name       string
+		masterKeys [][]byte
+		valid      bool
+	}{
+		{name: "single key", masterKeys: [][]byte{testKey(1)}, valid: true},
+		{name: "rotated keys", masterKeys: [][]byte{testKey(2), testKey(1)}, valid: true},
+		{name: "no keys"},
+		{name: "short key", masterKeys: [][]byte{testKey(1)[:16]}},
+		{name: "short old key", masterKeys: [][]byte{testKey(2), testKey(1)[:31]}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.masterKeys)
+			if (err == nil) != tt.valid {
+				t.Errorf("New() error = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
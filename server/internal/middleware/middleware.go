@@ -2,52 +2,125 @@ package middleware
 
 import (
 	"context"
-	"encoding/base64"
+	"fmt"
+	"log"
 	"net/http"
 	"strings"
+
+	"github.com/madeindra/mock-conversation/server/internal/auth"
+	"github.com/madeindra/mock-conversation/server/internal/util"
 )
 
 type contextKey string
 
 const (
-	ContextKeyUserID     contextKey = "user-id"
-	ContextKeyUserSecret contextKey = "user-secret"
+	ContextKeyClaims contextKey = "claims"
+)
+
+const (
+	bearerPrefix = "Bearer "
+	apiKeyHeader = "X-API-Key"
 )
 
-func BasicAuth(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		accessKey := r.Header.Get("Authorization")
+// APIKeyAuth verifies the API key of requests that carry one and stores its
+// claims in the request context under ContextKeyClaims. Requests without a
+// key are passed on unchanged, to be checked by TokenAuth.
+func APIKeyAuth(verify func(key string) (auth.Claims, error)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := APIKey(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
 
-		if accessKey == "" {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+			claims, err := verify(key)
+			if err != nil {
+				log.Printf("failed to verify API key: %v", err)
+				util.SendResponse(w, nil, err.Error(), http.StatusUnauthorized)
+				return
+			}
 
-		if accessKey[:6] != "Basic " {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ContextKeyClaims, claims)))
+		})
+	}
+}
+
+// TokenAuth requires a Bearer access token accepted by verify and stores its
+// claims in the request context under ContextKeyClaims. Requests already
+// authenticated by APIKeyAuth are passed on unchanged.
+func TokenAuth(verify func(token string) (auth.Claims, error)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := Claims(r.Context()); ok {
+				next.ServeHTTP(w, r)
+				return
+			}
 
-		accessKey = accessKey[6:]
+			token, ok := BearerToken(r)
+			if !ok {
+				util.SendResponse(w, nil, "missing required authentication", http.StatusUnauthorized)
+				return
+			}
 
-		decoded, err := base64.StdEncoding.DecodeString(accessKey)
-		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+			claims, err := verify(token)
+			if err != nil {
+				log.Printf("failed to verify access token: %v", err)
+				util.SendResponse(w, nil, err.Error(), http.StatusUnauthorized)
+				return
+			}
 
-		parts := strings.Split(string(decoded), ":")
-		if len(parts) != 2 {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ContextKeyClaims, claims)))
+		})
+	}
+}
 
-		userID := parts[0]
-		userSecret := parts[1]
+// RequireScope rejects account requests whose claims do not grant the scope.
+// Conversation tokens are already limited to a single conversation and pass.
+func RequireScope(scope auth.Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := Claims(r.Context())
+			if ok && claims.Kind != auth.KindChat && !claims.Allows(scope) {
+				log.Printf("credentials lack the %s scope", scope)
+				util.SendResponse(w, nil, fmt.Sprintf("the %s scope is required", scope), http.StatusForbidden)
+				return
+			}
 
-		r = r.WithContext(context.WithValue(r.Context(), ContextKeyUserID, userID))
-		r = r.WithContext(context.WithValue(r.Context(), ContextKeyUserSecret, userSecret))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// APIKey returns the API key the request carries, either in the X-API-Key
+// header or as a Bearer token.
+func APIKey(r *http.Request) (string, bool) {
+	if key := strings.TrimSpace(r.Header.Get(apiKeyHeader)); key != "" {
+		return key, true
+	}
+
+	token, ok := BearerToken(r)
+	if !ok || !strings.HasPrefix(token, auth.APIKeyPrefix) {
+		return "", false
+	}
+
+	return token, true
+}
+
+// BearerToken returns the token in the request's Authorization header, if it
+// carries a Bearer token.
+func BearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < len(bearerPrefix) || !strings.EqualFold(header[:len(bearerPrefix)], bearerPrefix) {
+		return "", false
+	}
+
+	token := strings.TrimSpace(header[len(bearerPrefix):])
+	return token, token != ""
+}
 
-		next.ServeHTTP(w, r)
-	})
+// Claims returns the claims TokenAuth stored in the context.
+func Claims(ctx context.Context) (auth.Claims, bool) {
+	claims, ok := ctx.Value(ContextKeyClaims).(auth.Claims)
+	return claims, ok
 }
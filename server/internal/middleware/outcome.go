@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	chimiddleware "github.com/go-chi/chi/middleware"
+)
+
+// RecordOutcome passes the method and route pattern of every request, along
+// with the status it was answered with, to record once it has been served.
+// Requests matching no route are left out so that probes for unknown paths do
+// not pile up.
+func RecordOutcome(record func(route string, status int)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ww := chimiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
+
+			rctx := chi.RouteContext(r.Context())
+			if rctx == nil || rctx.RoutePattern() == "" {
+				return
+			}
+
+			// Handlers that write a body without a status answer with 200
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			record(r.Method+" "+rctx.RoutePattern(), status)
+		})
+	}
+}
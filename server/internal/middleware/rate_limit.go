@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/madeindra/mock-conversation/server/internal/ratelimit"
+	"github.com/madeindra/mock-conversation/server/internal/util"
+)
+
+// upstreamRetryAfter is the wait suggested when every upstream slot is taken,
+// as a conversation turn usually completes within a few seconds
+const upstreamRetryAfter = 2 * time.Second
+
+// RateLimit takes a token from the bucket key names for the request and
+// rejects the request with 429 Too Many Requests once the bucket is empty.
+// Requests key returns an empty name for are not limited. Should the store
+// fail, requests are let through rather than failing the whole server.
+func RateLimit(store ratelimit.Store, limit ratelimit.Limit, key func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !limit.Enabled() {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name := key(r)
+			if name == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			retryAfter, err := store.Take(r.Context(), name, limit)
+			if err != nil {
+				log.Printf("failed to check rate limit: %v", err)
+			} else if retryAfter > 0 {
+				log.Printf("rate limit reached for %s", name)
+				SendTooManyRequests(w, retryAfter)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ConcurrencyLimit lets at most limit requests through at once and rejects the
+// rest with 429 Too Many Requests instead of queueing them. A limit of zero
+// means unlimited. The slots are shared by every route the middleware wraps.
+func ConcurrencyLimit(limit int) func(http.Handler) http.Handler {
+	slots := make(chan struct{}, max(limit, 0))
+
+	return func(next http.Handler) http.Handler {
+		if limit <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+				next.ServeHTTP(w, r)
+			default:
+				log.Printf("all %d upstream slots are taken", limit)
+				SendTooManyRequests(w, upstreamRetryAfter)
+			}
+		})
+	}
+}
+
+// SendTooManyRequests rejects the request with 429 Too Many Requests and a
+// Retry-After header.
+func SendTooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	SetRetryAfter(w, retryAfter)
+	util.SendResponse(w, nil, "too many requests, please try again later", http.StatusTooManyRequests)
+}
+
+// SetRetryAfter sets the Retry-After header in whole seconds, rounded up.
+func SetRetryAfter(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := max(int(math.Ceil(retryAfter.Seconds())), 1)
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+// ClientIP returns the IP address the request came from, without the port.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/madeindra/mock-conversation/server/internal/ratelimit"
+)
+
+func TestRateLimit(t *testing.T) {
+	tests := []struct {
+		name       string
+		limit      ratelimit.Limit
+		key        string
+		status     int
+		retryAfter string
+	}{
+		{name: "limited", limit: ratelimit.Limit{Requests: 1, Per: time.Minute}, key: "ip:1", status: http.StatusTooManyRequests, retryAfter: "60"},
+		{name: "wait rounded up to a second", limit: ratelimit.Limit{Requests: 1000, Per: time.Second}, key: "ip:1", status: http.StatusTooManyRequests, retryAfter: "1"},
+		{name: "disabled", key: "ip:1", status: http.StatusOK},
+		{name: "no key", limit: ratelimit.Limit{Requests: 1, Per: time.Minute}, status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			handler := RateLimit(ratelimit.NewMemoryStore(), tt.limit, func(r *http.Request) string { return tt.key })(next)
+
+			// The first request empties the bucket, the second is checked
+			var w *httptest.ResponseRecorder
+			for range 2 {
+				w = httptest.NewRecorder()
+				handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			}
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.retryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.retryAfter)
+			}
+		})
+	}
+}
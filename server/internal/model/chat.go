@@ -1,7 +1,43 @@
 package model
 
 type Chat struct {
-	Audio    string `json:"audio,omitempty"`
-	Text     string `json:"text,omitempty"`
-	Subtitle string `json:"subtitle,omitempty"`
+	ID              string `json:"id,omitempty"`
+	Audio           string `json:"audio,omitempty"`
+	Text            string `json:"text,omitempty"`
+	Subtitle        string `json:"subtitle,omitempty"`
+	Transliteration string `json:"transliteration,omitempty"`
+	Speaker         string `json:"speaker,omitempty"`
+}
+
+type Objective struct {
+	Description string `json:"description"`
+	Completed   bool   `json:"completed"`
+}
+
+type Limits struct {
+	MaxTurns        int `json:"maxTurns,omitempty"`
+	MaxMinutes      int `json:"maxMinutes,omitempty"`
+	MaxAudioSeconds int `json:"maxAudioSeconds,omitempty"`
+}
+
+type Language struct {
+	Code            string `json:"code"`
+	Name            string `json:"name"`
+	NativeName      string `json:"nativeName"`
+	Script          string `json:"script,omitempty"`
+	Direction       string `json:"direction,omitempty"`
+	Transliteration bool   `json:"transliteration,omitempty"`
+}
+
+type GlossaryToken struct {
+	Text         string `json:"text"`
+	Lemma        string `json:"lemma"`
+	PartOfSpeech string `json:"partOfSpeech"`
+	Translation  string `json:"translation"`
+	Note         string `json:"note,omitempty"`
+}
+
+type Character struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
 }
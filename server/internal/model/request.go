@@ -1,8 +1,93 @@
 package model
 
 type StartChatRequest struct {
-	Role             string `json:"role"`
-	Topic            string `json:"topic"`
-	Language         string `json:"language"`
-	SubtitleLanguage string `json:"subtitleLanguage,omitempty"`
+	Role             string      `json:"role"`
+	Topic            string      `json:"topic"`
+	Language         string      `json:"language"`
+	SubtitleLanguage string      `json:"subtitleLanguage,omitempty"`
+	Transliteration  bool        `json:"transliteration,omitempty"`
+	Objectives       []string    `json:"objectives,omitempty"`
+	Characters       []Character `json:"characters,omitempty"`
+	Limits           *Limits     `json:"limits,omitempty"`
+
+	// ClassroomID starts the conversation as classwork of a classroom the
+	// account belongs to, which the classroom's teachers can then see
+	ClassroomID string `json:"classroomId,omitempty"`
+}
+
+type ForkChatRequest struct {
+	EntryID string `json:"entryId"`
+}
+
+type SubtitleChatRequest struct {
+	Language string `json:"language"`
+	Backfill bool   `json:"backfill,omitempty"`
+}
+
+type TranslateChatRequest struct {
+	EntryID  string `json:"entryId"`
+	Language string `json:"language"`
+}
+
+type TokenRequest struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+type AccountRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type APIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+type RevokeAPIKeyRequest struct {
+	ID string `json:"id"`
+}
+
+type OrganizationRequest struct {
+	Name string `json:"name"`
+}
+
+type ClassroomRequest struct {
+	OrganizationID string `json:"organizationId"`
+	Name           string `json:"name"`
+}
+
+type MemberRequest struct {
+	OrganizationID string `json:"organizationId,omitempty"`
+	ClassroomID    string `json:"classroomId,omitempty"`
+	Username       string `json:"username"`
+	Role           string `json:"role"`
+}
+
+type InviteRequest struct {
+	OrganizationID string `json:"organizationId"`
+}
+
+// BudgetRequest sets the budget of an account or an organization, replacing
+// the server's defaults. Costs are in US dollars and zero means unlimited.
+type BudgetRequest struct {
+	AccountID      string  `json:"accountId,omitempty"`
+	OrganizationID string  `json:"organizationId,omitempty"`
+	DailyCost      float64 `json:"dailyCost"`
+	MonthlyCost    float64 `json:"monthlyCost"`
+	DailyTurns     int     `json:"dailyTurns"`
+	MonthlyTurns   int     `json:"monthlyTurns"`
+}
+
+// ProviderKeyRequest stores the account's own key for the AI provider.
+type ProviderKeyRequest struct {
+	Key string `json:"key"`
+}
+
+type DeleteConversationRequest struct {
+	ID string `json:"id"`
 }
@@ -1,23 +1,44 @@
 package model
 
+import "time"
+
 type Response struct {
 	Message string `json:"message,omitempty"`
 	Data    any    `json:"data,omitempty"`
 }
 
 type StartChatResponse struct {
-	ID       string `json:"id"`
-	Secret   string `json:"secret"`
-	Language string `json:"language"`
+	ID              string `json:"id"`
+	Secret          string `json:"secret"`
+	Language        string `json:"language"`
+	Transliteration bool   `json:"transliteration,omitempty"`
+
+	Objectives []Objective `json:"objectives,omitempty"`
+	Characters []Character `json:"characters,omitempty"`
+	Limits     *Limits     `json:"limits,omitempty"`
 
+	TokenResponse
 	Chat
 }
 
+// TokenResponse carries a short-lived access token to send as a Bearer token
+// and the refresh token that renews it.
+type TokenResponse struct {
+	AccessToken  string    `json:"accessToken"`
+	RefreshToken string    `json:"refreshToken"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+}
+
 type AnswerChatResponse struct {
 	Language string `json:"language"`
 	Prompt   Chat   `json:"prompt,omitempty"`
 	Answer   Chat   `json:"answer,omitempty"`
 	IsLast   bool   `json:"isLast"`
+
+	LanguageMismatch bool `json:"languageMismatch"`
+
+	Objectives     []Objective `json:"objectives,omitempty"`
+	TurnsRemaining *int        `json:"turnsRemaining,omitempty"`
 }
 
 type StatusResponse struct {
@@ -26,3 +47,255 @@ type StatusResponse struct {
 	APIStatus    *string `json:"apiStatus,omitempty"`
 	KeyValid     bool    `json:"keyValid"`
 }
+
+type UndoChatResponse struct {
+	Language   string      `json:"language"`
+	LastReply  Chat        `json:"lastReply"`
+	Objectives []Objective `json:"objectives,omitempty"`
+}
+
+type ConversationNode struct {
+	ID          string              `json:"id"`
+	ParentID    string              `json:"parentId,omitempty"`
+	ForkEntryID string              `json:"forkEntryId,omitempty"`
+	CreatedAt   time.Time           `json:"createdAt"`
+	Status      string              `json:"status"`
+	Current     bool                `json:"current"`
+	Children    []*ConversationNode `json:"children,omitempty"`
+}
+
+type LanguagesResponse struct {
+	Conversation []Language `json:"conversation"`
+	Subtitle     []Language `json:"subtitle"`
+}
+
+type TranslateChatResponse struct {
+	EntryID     string `json:"entryId"`
+	Language    string `json:"language"`
+	Text        string `json:"text"`
+	Translation string `json:"translation"`
+}
+
+type SubtitleChatResponse struct {
+	SubtitleLanguage string         `json:"subtitleLanguage,omitempty"`
+	Entries          []HistoryEntry `json:"entries,omitempty"`
+}
+
+type GlossaryResponse struct {
+	EntryID  string          `json:"entryId"`
+	Language string          `json:"language"`
+	Text     string          `json:"text"`
+	Tokens   []GlossaryToken `json:"tokens"`
+}
+
+type HistoryEntry struct {
+	Role             string `json:"role"`
+	Language         string `json:"language,omitempty"`
+	SubtitleLanguage string `json:"subtitleLanguage,omitempty"`
+
+	Chat
+}
+
+type ChatHistoryResponse struct {
+	ID               string         `json:"id"`
+	Language         string         `json:"language"`
+	SubtitleLanguage string         `json:"subtitleLanguage,omitempty"`
+	Transliteration  bool           `json:"transliteration,omitempty"`
+	Status           string         `json:"status"`
+	Limits           *Limits        `json:"limits,omitempty"`
+	Objectives       []Objective    `json:"objectives,omitempty"`
+	Characters       []Character    `json:"characters,omitempty"`
+	Entries          []HistoryEntry `json:"entries"`
+}
+
+type AccountResponse struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type LoginResponse struct {
+	AccountResponse
+	TokenResponse
+}
+
+type APIKeyResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+// CreateAPIKeyResponse is the only response that includes the key itself.
+type CreateAPIKeyResponse struct {
+	APIKeyResponse
+	Key string `json:"key"`
+}
+
+type OrganizationResponse struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	Owner     bool      `json:"owner"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type InviteResponse struct {
+	OrganizationID   string    `json:"organizationId"`
+	OrganizationName string    `json:"organizationName"`
+	Role             string    `json:"role"`
+	InvitedBy        string    `json:"invitedBy"`
+	CreatedAt        time.Time `json:"createdAt"`
+}
+
+type ClassroomResponse struct {
+	ID             string    `json:"id"`
+	OrganizationID string    `json:"organizationId"`
+	Name           string    `json:"name"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+type MemberResponse struct {
+	AccountID string    `json:"accountId"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	JoinedAt  time.Time `json:"joinedAt"`
+}
+
+type StudentProgressResponse struct {
+	AccountID           string     `json:"accountId"`
+	Username            string     `json:"username"`
+	Conversations       int        `json:"conversations"`
+	Ended               int        `json:"ended"`
+	ObjectivesCompleted int        `json:"objectivesCompleted"`
+	ObjectivesTotal     int        `json:"objectivesTotal"`
+	LastActiveAt        *time.Time `json:"lastActiveAt,omitempty"`
+}
+
+type ConversationSummary struct {
+	ID               string     `json:"id"`
+	ParentID         string     `json:"parentId,omitempty"`
+	ClassroomID      string     `json:"classroomId,omitempty"`
+	Language         string     `json:"language"`
+	SubtitleLanguage string     `json:"subtitleLanguage,omitempty"`
+	Status           string     `json:"status"`
+	CreatedAt        time.Time  `json:"createdAt"`
+	EndedAt          *time.Time `json:"endedAt,omitempty"`
+}
+
+type ConversationListResponse struct {
+	Conversations []ConversationSummary `json:"conversations"`
+	Page          int                   `json:"page"`
+	PageSize      int                   `json:"pageSize"`
+	Total         int                   `json:"total"`
+}
+
+// BudgetResponse reports a budget that applies to the account, either its own
+// or one of its organizations'.
+type BudgetResponse struct {
+	Subject string               `json:"subject"`
+	ID      string               `json:"id"`
+	Name    string               `json:"name"`
+	Daily   BudgetPeriodResponse `json:"daily"`
+	Monthly BudgetPeriodResponse `json:"monthly"`
+}
+
+// BudgetPeriodResponse has amounts in US dollars. Limits and remaining
+// amounts are left out when unlimited.
+type BudgetPeriodResponse struct {
+	Spent          float64   `json:"spent"`
+	CostLimit      *float64  `json:"costLimit,omitempty"`
+	CostRemaining  *float64  `json:"costRemaining,omitempty"`
+	Turns          int       `json:"turns"`
+	TurnLimit      *int      `json:"turnLimit,omitempty"`
+	TurnsRemaining *int      `json:"turnsRemaining,omitempty"`
+	Status         string    `json:"status"`
+	ResetsAt       time.Time `json:"resetsAt"`
+}
+
+// ProviderKeyResponse describes the account's own provider key without
+// revealing it.
+type ProviderKeyResponse struct {
+	Hint      string    `json:"hint"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// ProviderKeyRotationResponse counts the provider keys re-encrypted under the
+// current master key, and those that could not be decrypted.
+type ProviderKeyRotationResponse struct {
+	MasterKeyID string `json:"masterKeyId"`
+	Rotated     int    `json:"rotated"`
+	Failed      int    `json:"failed"`
+}
+
+// AdminConversationSummary is a conversation as listed for admins, with the
+// account owning it and the number of turns the learner took.
+type AdminConversationSummary struct {
+	ConversationSummary
+	AccountID string `json:"accountId,omitempty"`
+	Username  string `json:"username,omitempty"`
+	Turns     int    `json:"turns"`
+}
+
+type AdminConversationListResponse struct {
+	Conversations []AdminConversationSummary `json:"conversations"`
+	Page          int                        `json:"page"`
+	PageSize      int                        `json:"pageSize"`
+	Total         int                        `json:"total"`
+}
+
+// TranscriptResponse is the whole of a conversation for admins, including the
+// system prompt and the running summary sent to the model in place of older
+// turns.
+type TranscriptResponse struct {
+	AdminConversationSummary
+	Transliteration bool           `json:"transliteration,omitempty"`
+	Limits          *Limits        `json:"limits,omitempty"`
+	Summary         string         `json:"summary,omitempty"`
+	Objectives      []Objective    `json:"objectives,omitempty"`
+	Characters      []Character    `json:"characters,omitempty"`
+	Entries         []HistoryEntry `json:"entries"`
+}
+
+// StatsResponse sums up the conversations started and the requests served
+// since the start of its period.
+type StatsResponse struct {
+	Since         time.Time              `json:"since"`
+	Conversations int                    `json:"conversations"`
+	AverageTurns  float64                `json:"averageTurns"`
+	PerDay        []DailyCount           `json:"perDay"`
+	Languages     []LanguageCount        `json:"languages"`
+	Statuses      []StatusCount          `json:"statuses"`
+	Requests      RequestOutcomeResponse `json:"requests"`
+}
+
+type DailyCount struct {
+	Day           string `json:"day"`
+	Conversations int    `json:"conversations"`
+}
+
+type LanguageCount struct {
+	Language      string `json:"language"`
+	Conversations int    `json:"conversations"`
+}
+
+type StatusCount struct {
+	Status        string `json:"status"`
+	Conversations int    `json:"conversations"`
+}
+
+// RequestOutcomeResponse counts requests and their errors. ErrorRate is the
+// share of requests the server failed to serve; the client's own errors are
+// only counted.
+type RequestOutcomeResponse struct {
+	Route        string                   `json:"route,omitempty"`
+	Requests     int                      `json:"requests"`
+	ClientErrors int                      `json:"clientErrors"`
+	ServerErrors int                      `json:"serverErrors"`
+	ErrorRate    float64                  `json:"errorRate"`
+	Routes       []RequestOutcomeResponse `json:"routes,omitempty"`
+}
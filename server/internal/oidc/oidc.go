@@ -0,0 +1,312 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	discoveryPath = "/.well-known/openid-configuration"
+
+	// requestTimeout bounds every call to the identity provider
+	requestTimeout = 10 * time.Second
+)
+
+var ErrInvalidIDToken = errors.New("invalid ID token")
+
+// Config describes the client registered with the identity provider.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// Discovery holds the parts of the provider's discovery document in use.
+type Discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// IDToken holds the validated claims of an ID token.
+type IDToken struct {
+	Issuer            string `json:"iss"`
+	Subject           string `json:"sub"`
+	Nonce             string `json:"nonce"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+	IssuedAt          int64  `json:"iat"`
+	ExpiresAt         int64  `json:"exp"`
+}
+
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Provider runs the authorization code flow with PKCE against one identity
+// provider. The discovery document is fetched on first use and the signing
+// keys are cached; see keySet.
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *Discovery
+	keys      *keySet
+}
+
+func NewProvider(config Config) *Provider {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "profile", "email"}
+	}
+
+	return &Provider{
+		config: config,
+		client: &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// AuthCodeURL returns the provider URL the user is sent to for logging in.
+// The challenge is derived from the flow's verifier with Challenge.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, challenge string) (string, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange redeems the authorization code for tokens and returns the claims
+// of the validated ID token, which must carry the given nonce.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (IDToken, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return IDToken{}, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {verifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return IDToken{}, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	var tokens tokenResponse
+	status, err := p.doJSON(req, &tokens)
+	if err != nil {
+		return IDToken{}, err
+	}
+
+	if status != http.StatusOK || tokens.Error != "" {
+		return IDToken{}, fmt.Errorf("token endpoint returned %d: %s %s", status, tokens.Error, tokens.ErrorDescription)
+	}
+
+	if tokens.IDToken == "" {
+		return IDToken{}, fmt.Errorf("token endpoint returned no ID token")
+	}
+
+	return p.Verify(ctx, tokens.IDToken, nonce)
+}
+
+// Verify checks the ID token's signature against the provider's keys, then
+// its issuer, audience, expiry and nonce.
+func (p *Provider) Verify(ctx context.Context, rawToken, nonce string) (IDToken, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return IDToken{}, err
+	}
+
+	payload, err := p.keySet(discovery).verify(ctx, rawToken)
+	if err != nil {
+		return IDToken{}, err
+	}
+
+	var claims IDToken
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return IDToken{}, ErrInvalidIDToken
+	}
+
+	var audience struct {
+		Audience        audience `json:"aud"`
+		AuthorizedParty string   `json:"azp"`
+	}
+	if err := json.Unmarshal(payload, &audience); err != nil {
+		return IDToken{}, ErrInvalidIDToken
+	}
+
+	if claims.Issuer != discovery.Issuer {
+		return IDToken{}, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidIDToken, claims.Issuer)
+	}
+
+	if !audience.Audience.contains(p.config.ClientID) {
+		return IDToken{}, fmt.Errorf("%w: not issued for this client", ErrInvalidIDToken)
+	}
+
+	if len(audience.Audience) > 1 && audience.AuthorizedParty != p.config.ClientID {
+		return IDToken{}, fmt.Errorf("%w: unexpected authorized party %q", ErrInvalidIDToken, audience.AuthorizedParty)
+	}
+
+	if claims.Subject == "" {
+		return IDToken{}, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	}
+
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return IDToken{}, fmt.Errorf("%w: token has expired", ErrInvalidIDToken)
+	}
+
+	if claims.Nonce != nonce {
+		return IDToken{}, fmt.Errorf("%w: nonce does not match", ErrInvalidIDToken)
+	}
+
+	return claims, nil
+}
+
+// discover fetches the discovery document once. A failed fetch is retried on
+// the next call.
+func (p *Provider) discover(ctx context.Context) (*Discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.config.Issuer, "/")+discoveryPath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var discovery Discovery
+	status, err := p.doJSON(req, &discovery)
+	if err != nil {
+		return nil, err
+	}
+
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("discovery returned %d", status)
+	}
+
+	// The issuer must match the one configured, so that a document served
+	// elsewhere cannot vouch for tokens
+	if discovery.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("discovery issuer %q does not match %q", discovery.Issuer, p.config.Issuer)
+	}
+
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery document is missing endpoints")
+	}
+
+	p.discovery = &discovery
+
+	return p.discovery, nil
+}
+
+func (p *Provider) keySet(discovery *Discovery) *keySet {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.keys == nil {
+		p.keys = newKeySet(p.client, discovery.JWKSURI)
+	}
+
+	return p.keys
+}
+
+func (p *Provider) doJSON(req *http.Request, v any) (int, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return 0, err
+	}
+
+	if err := json.Unmarshal(body, v); err != nil && resp.StatusCode == http.StatusOK {
+		return 0, err
+	}
+
+	return resp.StatusCode, nil
+}
+
+// Challenge derives the S256 PKCE code challenge from a code verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// audience is the aud claim, which is either a string or a list of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(raw []byte) error {
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return err
+	}
+
+	*a = list
+	return nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
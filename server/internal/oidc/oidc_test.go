@@ -0,0 +1,209 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID = "test-client"
+	testKeyID    = "test-key"
+	testNonce    = "test-nonce"
+)
+
+// testIssuer serves a discovery document and the public half of key, standing
+// in for an identity provider.
+func testIssuer(t *testing.T, key *rsa.PrivateKey) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Discovery{
+			Issuer:                server.URL,
+			AuthorizationEndpoint: server.URL + "/authorize",
+			TokenEndpoint:         server.URL + "/token",
+			JWKSURI:               server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []jsonWebKey{{
+				KeyID:     testKeyID,
+				KeyType:   "RSA",
+				Algorithm: "RS256",
+				Use:       "sig",
+				Modulus:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				Exponent:  base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+
+	return server
+}
+
+// signToken builds a compact JWT. RS256 tokens are signed with key; HS256
+// tokens are signed with the key's public modulus, as an attacker confusing
+// the two algorithms would.
+func signToken(t *testing.T, key *rsa.PrivateKey, header map[string]string, claims map[string]any) string {
+	t.Helper()
+
+	rawHeader, err := json.Marshal(header)
+	if err != nil {
+		t.Fatalf("failed to write header: %v", err)
+	}
+
+	rawClaims, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("failed to write claims: %v", err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(rawHeader) + "." + base64.RawURLEncoding.EncodeToString(rawClaims)
+
+	var signature []byte
+	switch header["alg"] {
+	case "RS256":
+		digest := sha256.Sum256([]byte(signed))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatalf("failed to sign token: %v", err)
+		}
+	case "HS256":
+		mac := hmac.New(sha256.New, key.N.Bytes())
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerify(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	issuer := testIssuer(t, key)
+	provider := NewProvider(Config{Issuer: issuer.URL, ClientID: testClientID})
+
+	validClaims := func() map[string]any {
+		return map[string]any{
+			"iss":                issuer.URL,
+			"sub":                "user-1",
+			"aud":                testClientID,
+			"iat":                time.Now().Unix(),
+			"exp":                time.Now().Add(time.Minute).Unix(),
+			"nonce":              testNonce,
+			"preferred_username": "ana",
+		}
+	}
+
+	tests := []struct {
+		name   string
+		key    *rsa.PrivateKey
+		header map[string]string
+		edit   func(claims map[string]any)
+		valid  bool
+	}{
+		{name: "valid", valid: true},
+		{name: "audience list with this client as authorized party", edit: func(c map[string]any) {
+			c["aud"] = []string{testClientID, "other-client"}
+			c["azp"] = testClientID
+		}, valid: true},
+		{name: "without key ID while a single key is published", header: map[string]string{"alg": "RS256"}, valid: true},
+		{name: "wrong audience", edit: func(c map[string]any) { c["aud"] = "other-client" }},
+		{name: "audience list without this client", edit: func(c map[string]any) { c["aud"] = []string{"other-client", "third-client"} }},
+		{name: "audience list for another authorized party", edit: func(c map[string]any) {
+			c["aud"] = []string{testClientID, "other-client"}
+			c["azp"] = "other-client"
+		}},
+		{name: "wrong issuer", edit: func(c map[string]any) { c["iss"] = "https://attacker.example" }},
+		{name: "wrong nonce", edit: func(c map[string]any) { c["nonce"] = "replayed-nonce" }},
+		{name: "missing nonce", edit: func(c map[string]any) { delete(c, "nonce") }},
+		{name: "missing subject", edit: func(c map[string]any) { delete(c, "sub") }},
+		{name: "expired", edit: func(c map[string]any) { c["exp"] = time.Now().Add(-time.Minute).Unix() }},
+		{name: "alg none", header: map[string]string{"alg": "none", "kid": testKeyID}},
+		{name: "alg HS256 keyed with the public key", header: map[string]string{"alg": "HS256", "kid": testKeyID}},
+		{name: "alg RS512", header: map[string]string{"alg": "RS512", "kid": testKeyID}},
+		{name: "signed with another key", key: otherKey},
+		{name: "unknown key ID", header: map[string]string{"alg": "RS256", "kid": "other-key"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signingKey := key
+			if tt.key != nil {
+				signingKey = tt.key
+			}
+
+			header := map[string]string{"alg": "RS256", "typ": "JWT", "kid": testKeyID}
+			if tt.header != nil {
+				header = tt.header
+			}
+
+			claims := validClaims()
+			if tt.edit != nil {
+				tt.edit(claims)
+			}
+
+			idToken, err := provider.Verify(context.Background(), signToken(t, signingKey, header, claims), testNonce)
+			if !tt.valid {
+				if !errors.Is(err, ErrInvalidIDToken) {
+					t.Fatalf("Verify() error = %v, want %v", err, ErrInvalidIDToken)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if idToken.Subject != "user-1" || idToken.PreferredUsername != "ana" {
+				t.Errorf("Verify() = %+v, want subject user-1 and username ana", idToken)
+			}
+		})
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	issuer := testIssuer(t, key)
+
+	// The document is served, but names an issuer other than the one
+	// configured
+	provider := NewProvider(Config{Issuer: issuer.URL + "/", ClientID: testClientID})
+
+	if _, err := provider.AuthCodeURL(context.Background(), "state", "nonce", Challenge("verifier")); err == nil {
+		t.Fatal("AuthCodeURL() succeeded with a discovery document for another issuer")
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	// Example from RFC 7636, appendix B
+	got := Challenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Errorf("Challenge() = %q, want %q", got, want)
+	}
+}
@@ -9,24 +9,41 @@ import (
 //go:embed templates/system.txt
 var systemPromptTemplate string
 
-func GetSystemPrompt(role string, topic string, language string) (string, error) {
-	t, err := template.New("prompt").Parse(systemPromptTemplate)
-	if err != nil {
-		return "", err
+//go:embed templates/scene.txt
+var scenePromptTemplate string
+
+// SystemPrompt holds the values rendered into the system prompt template.
+// When Characters is set the scene template is used instead, and Role is ignored.
+type SystemPrompt struct {
+	Role       string
+	Topic      string
+	Language   string
+	Objectives []string
+	Characters []PromptCharacter
+
+	// AutoLanguage lets the AI follow the user's language; Language is then
+	// only used until the user first speaks
+	AutoLanguage bool
+}
+
+type PromptCharacter struct {
+	Name string
+	Role string
+}
+
+func GetSystemPrompt(prompt SystemPrompt) (string, error) {
+	text := systemPromptTemplate
+	if len(prompt.Characters) > 0 {
+		text = scenePromptTemplate
 	}
 
-	data := struct {
-		Role     string
-		Topic    string
-		Language string
-	}{
-		Role:     role,
-		Topic:    topic,
-		Language: language,
+	t, err := template.New("prompt").Parse(text)
+	if err != nil {
+		return "", err
 	}
 
 	var buf bytes.Buffer
-	if err := t.Execute(&buf, data); err != nil {
+	if err := t.Execute(&buf, prompt); err != nil {
 		return "", err
 	}
 
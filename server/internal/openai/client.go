@@ -10,15 +10,17 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/url"
+	"unicode/utf8"
 )
 
 type Client interface {
 	IsKeyValid() (bool, error)
 	Status() (Status, error)
 	Chat([]ChatMessage) (string, error)
-	Transcribe(audio io.Reader, filename string, language string) (string, error)
+	Transcribe(audio io.Reader, filename string, language string) (TranscriptResponse, error)
 	Speech(text string, voice string, language string) (io.ReadCloser, error)
 	RandomVoice() string
+	RandomVoices(n int) []string
 
 	GetDefaultTranscriptLanguage() string
 }
@@ -30,6 +32,9 @@ type OpenAI struct {
 	transcriptModel    string
 	ttsModel           string
 	transcriptLanguage string
+
+	// record receives the usage of every successful call, when set
+	record func(Usage)
 }
 
 const (
@@ -67,10 +72,47 @@ func NewOpenAI(apiKey string) *OpenAI {
 	}
 }
 
+// WithUsage returns a copy of the client that reports the usage of every
+// successful call to record.
+func (c *OpenAI) WithUsage(record func(Usage)) *OpenAI {
+	client := *c
+	client.record = record
+
+	return &client
+}
+
+// Economy returns a copy of the client that chats with a cheaper model.
+func (c *OpenAI) Economy() *OpenAI {
+	client := *c
+	client.chatModel = economyChatModel
+
+	return &client
+}
+
+func (c *OpenAI) report(usage Usage) {
+	if c.record != nil {
+		c.record(usage)
+	}
+}
+
 func (c *OpenAI) RandomVoice() string {
 	return ttsVoices[rand.Intn(len(ttsVoices))]
 }
 
+// RandomVoices picks n distinct voices, or every available voice when n
+// exceeds the number of voices.
+func (c *OpenAI) RandomVoices(n int) []string {
+	if n > len(ttsVoices) {
+		n = len(ttsVoices)
+	}
+
+	voices := make([]string, 0, n)
+	for _, i := range rand.Perm(len(ttsVoices))[:n] {
+		voices = append(voices, ttsVoices[i])
+	}
+	return voices
+}
+
 func (c *OpenAI) IsKeyValid() (bool, error) {
 	url, err := url.JoinPath(c.baseURL, "/models")
 	if err != nil {
@@ -192,6 +234,13 @@ func (c *OpenAI) Chat(messages []ChatMessage) (string, error) {
 		return "", err
 	}
 
+	c.report(Usage{
+		Operation:    OperationChat,
+		Model:        c.chatModel,
+		InputTokens:  chatResp.Usage.PromptTokens,
+		OutputTokens: chatResp.Usage.CompletionTokens,
+	})
+
 	if len(chatResp.Choices) == 0 {
 		return "", fmt.Errorf("no valid response returned")
 	}
@@ -199,10 +248,12 @@ func (c *OpenAI) Chat(messages []ChatMessage) (string, error) {
 	return chatResp.Choices[0].Message.Content, nil
 }
 
-func (c *OpenAI) Transcribe(audio io.Reader, filename string, language string) (string, error) {
+// Transcribe converts speech to text. The verbose response format is requested
+// so that the audio duration and detected language come back with the text.
+func (c *OpenAI) Transcribe(audio io.Reader, filename string, language string) (TranscriptResponse, error) {
 	url, err := url.JoinPath(c.baseURL, "/audio/transcriptions")
 	if err != nil {
-		return "", err
+		return TranscriptResponse{}, err
 	}
 
 	var buf bytes.Buffer
@@ -210,30 +261,34 @@ func (c *OpenAI) Transcribe(audio io.Reader, filename string, language string) (
 
 	part, err := writer.CreateFormFile("file", filename)
 	if err != nil {
-		return "", err
+		return TranscriptResponse{}, err
 	}
 
 	if _, err := io.Copy(part, audio); err != nil {
-		return "", err
+		return TranscriptResponse{}, err
 	}
 
 	if err := writer.WriteField("model", c.transcriptModel); err != nil {
-		return "", err
+		return TranscriptResponse{}, err
+	}
+
+	if err := writer.WriteField("response_format", "verbose_json"); err != nil {
+		return TranscriptResponse{}, err
 	}
 
 	if language != "" {
 		if err := writer.WriteField("language", language); err != nil {
-			return "", err
+			return TranscriptResponse{}, err
 		}
 	}
 
 	if err := writer.Close(); err != nil {
-		return "", err
+		return TranscriptResponse{}, err
 	}
 
 	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, url, &buf)
 	if err != nil {
-		return "", err
+		return TranscriptResponse{}, err
 	}
 
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.apiKey))
@@ -241,16 +296,22 @@ func (c *OpenAI) Transcribe(audio io.Reader, filename string, language string) (
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return "", err
+		return TranscriptResponse{}, err
 	}
 
 	var transcriptResp TranscriptResponse
 	err = unmarshalJSONResponse(resp, &transcriptResp)
 	if err != nil {
-		return "", err
+		return TranscriptResponse{}, err
 	}
 
-	return transcriptResp.Text, nil
+	c.report(Usage{
+		Operation:    OperationTranscription,
+		Model:        c.transcriptModel,
+		AudioSeconds: transcriptResp.Duration,
+	})
+
+	return transcriptResp, nil
 }
 
 func (c *OpenAI) Speech(text string, voice string, language string) (io.ReadCloser, error) {
@@ -285,7 +346,18 @@ func (c *OpenAI) Speech(text string, voice string, language string) (io.ReadClos
 		return nil, err
 	}
 
-	return getResponseBody(resp)
+	audio, err := getResponseBody(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	c.report(Usage{
+		Operation:  OperationSpeech,
+		Model:      c.ttsModel,
+		Characters: utf8.RuneCountInString(text),
+	})
+
+	return audio, nil
 }
 
 func (c *OpenAI) GetDefaultTranscriptLanguage() string {
@@ -11,7 +11,13 @@ type ChatRequest struct {
 }
 
 type ChatResponse struct {
-	Choices []Choice `json:"choices"`
+	Choices []Choice   `json:"choices"`
+	Usage   TokenUsage `json:"usage"`
+}
+
+type TokenUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
 }
 
 type Choice struct {
@@ -23,6 +29,10 @@ type Choice struct {
 type ChatMessage struct {
 	Content string `json:"content"`
 	Role    Role   `json:"role"`
+
+	// Name tells apart participants sharing a role, such as the characters of
+	// a scene. The API only accepts letters, digits, underscores and hyphens.
+	Name string `json:"name,omitempty"`
 }
 
 type Role string
@@ -44,16 +54,32 @@ type SpeechRequest struct {
 
 // TranscriptResponse is the response from the transcription API.
 type TranscriptResponse struct {
-	Text string `json:"text"`
+	Text     string  `json:"text"`
+	Language string  `json:"language,omitempty"`
+	Duration float64 `json:"duration,omitempty"`
 }
 
 // AnswerChatResult is the JSON response from ChatGPT for all chat operations.
 type AnswerChatResult struct {
-	Transcript         string `json:"transcript,omitempty"`
-	TranscriptSubtitle string `json:"transcriptSubtitle,omitempty"`
-	Response           string `json:"response"`
-	ResponseSubtitle   string `json:"responseSubtitle,omitempty"`
-	IsLast             bool   `json:"isLast"`
+	Transcript                string `json:"transcript,omitempty"`
+	TranscriptSubtitle        string `json:"transcriptSubtitle,omitempty"`
+	TranscriptTransliteration string `json:"transcriptTransliteration,omitempty"`
+	Speaker                   string `json:"speaker,omitempty"`
+	Response                  string `json:"response"`
+	ResponseSubtitle          string `json:"responseSubtitle,omitempty"`
+	ResponseTransliteration   string `json:"responseTransliteration,omitempty"`
+	IsLast                    bool   `json:"isLast"`
+
+	CompletedObjectives []int `json:"completedObjectives,omitempty"`
+}
+
+// GlossaryToken explains a single word or particle of a sentence.
+type GlossaryToken struct {
+	Text         string `json:"text"`
+	Lemma        string `json:"lemma"`
+	PartOfSpeech string `json:"partOfSpeech"`
+	Translation  string `json:"translation"`
+	Note         string `json:"note,omitempty"`
 }
 
 type Status string
@@ -0,0 +1,52 @@
+package openai
+
+import "math"
+
+const (
+	OperationChat          = "chat"
+	OperationTranscription = "transcription"
+	OperationSpeech        = "speech"
+)
+
+// economyChatModel replaces chatModel once a spending cap is exceeded
+const economyChatModel = "gpt-4o-mini"
+
+// Usage is what a single call to the API consumed.
+type Usage struct {
+	Operation    string
+	Model        string
+	InputTokens  int
+	OutputTokens int
+	AudioSeconds float64
+	Characters   int
+}
+
+// price is a model's list price in millionths of a US dollar per unit.
+type price struct {
+	inputToken  float64
+	outputToken float64
+	audioSecond float64
+	character   float64
+}
+
+// prices are list prices at the time of writing, so costs are estimates
+// rather than the amount billed
+var prices = map[string]price{
+	"gpt-4o":          {inputToken: 2.5, outputToken: 10},
+	"gpt-4o-mini":     {inputToken: 0.15, outputToken: 0.6},
+	"whisper-1":       {audioSecond: 100},
+	"gpt-4o-mini-tts": {character: 15},
+}
+
+// Cost estimates the usage in millionths of a US dollar. Models without a
+// known price cost nothing.
+func (u Usage) Cost() int64 {
+	p := prices[u.Model]
+
+	cost := float64(u.InputTokens)*p.inputToken +
+		float64(u.OutputTokens)*p.outputToken +
+		u.AudioSeconds*p.audioSecond +
+		float64(u.Characters)*p.character
+
+	return int64(math.Ceil(cost))
+}
@@ -0,0 +1,113 @@
+// Package ratelimit implements token-bucket rate limits over a pluggable
+// store of bucket state.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// pruneInterval is how often the memory store drops buckets that have
+// refilled completely and so hold nothing worth keeping
+const pruneInterval = time.Minute
+
+// Limit is a token bucket holding up to Burst tokens, refilled with Requests
+// tokens every Per. Every request takes one token. A zero Requests or Per
+// means unlimited.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+	Burst    int
+}
+
+func (l Limit) Enabled() bool {
+	return l.Requests > 0 && l.Per > 0
+}
+
+// capacity is the size of the bucket, which always holds at least one token
+// so that a limit with no burst still lets requests through.
+func (l Limit) capacity() float64 {
+	return float64(max(l.Burst, 1))
+}
+
+// interval is how long the bucket takes to refill a single token.
+func (l Limit) interval() time.Duration {
+	return l.Per / time.Duration(l.Requests)
+}
+
+// Store keeps the state of the buckets. The memory store suits a single
+// server; servers sharing limits need a store backed by a shared database.
+type Store interface {
+	// Take removes a token from the bucket under key, creating a full bucket
+	// for an unknown key. It returns zero when a token was taken, or how long
+	// until the next one will be available.
+	Take(ctx context.Context, key string, limit Limit) (time.Duration, error)
+}
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	fullAt    time.Time
+}
+
+// MemoryStore keeps the buckets in memory, so limits are per process and
+// reset on restart.
+type MemoryStore struct {
+	mu       sync.Mutex
+	buckets  map[string]*bucket
+	prunedAt time.Time
+
+	// now is the clock, replaced in tests
+	now func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets:  make(map[string]*bucket),
+		prunedAt: time.Now(),
+		now:      time.Now,
+	}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit) (time.Duration, error) {
+	now := s.now()
+	capacity := limit.capacity()
+	interval := limit.interval()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.prunedAt) >= pruneInterval {
+		s.prune(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, updatedAt: now}
+		s.buckets[key] = b
+	}
+
+	elapsed := now.Sub(b.updatedAt)
+	b.tokens = min(capacity, b.tokens+float64(elapsed)/float64(interval))
+	b.updatedAt = now
+
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) * float64(interval)), nil
+	}
+
+	b.tokens--
+	b.fullAt = now.Add(time.Duration((capacity - b.tokens) * float64(interval)))
+
+	return 0, nil
+}
+
+func (s *MemoryStore) prune(now time.Time) {
+	for key, b := range s.buckets {
+		if !now.Before(b.fullAt) {
+			delete(s.buckets, key)
+		}
+	}
+
+	s.prunedAt = now
+}
@@ -0,0 +1,120 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// clock is a time source tests move forward by hand.
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func TestTake(t *testing.T) {
+	// One token every 10 seconds, up to 3 at once
+	limit := Limit{Requests: 6, Per: time.Minute, Burst: 3}
+
+	type step struct {
+		advance    time.Duration
+		retryAfter time.Duration
+	}
+
+	tests := []struct {
+		name  string
+		limit Limit
+		steps []step
+	}{
+		{
+			name:  "burst then empty",
+			limit: limit,
+			steps: []step{{}, {}, {}, {retryAfter: 10 * time.Second}},
+		},
+		{
+			name:  "retry after shrinks as the bucket refills",
+			limit: limit,
+			steps: []step{{}, {}, {}, {advance: 4 * time.Second, retryAfter: 6 * time.Second}},
+		},
+		{
+			name:  "refills a token per interval",
+			limit: limit,
+			steps: []step{{}, {}, {}, {advance: 10 * time.Second}, {retryAfter: 10 * time.Second}},
+		},
+		{
+			name:  "refills no more than the burst",
+			limit: limit,
+			steps: []step{{}, {advance: time.Hour}, {}, {}, {retryAfter: 10 * time.Second}},
+		},
+		{
+			name:  "rejected requests take no token",
+			limit: limit,
+			steps: []step{{}, {}, {}, {retryAfter: 10 * time.Second}, {advance: 5 * time.Second, retryAfter: 5 * time.Second}, {advance: 5 * time.Second}},
+		},
+		{
+			name:  "no burst still lets a request through",
+			limit: Limit{Requests: 1, Per: time.Second},
+			steps: []step{{}, {retryAfter: time.Second}, {advance: time.Second}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &clock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+			store := NewMemoryStore()
+			store.now = c.Now
+
+			for i, s := range tt.steps {
+				c.Advance(s.advance)
+
+				retryAfter, err := store.Take(context.Background(), "key", tt.limit)
+				if err != nil {
+					t.Fatalf("step %d: Take() error = %v", i, err)
+				}
+				if retryAfter != s.retryAfter {
+					t.Fatalf("step %d: Take() = %v, want %v", i, retryAfter, s.retryAfter)
+				}
+			}
+		})
+	}
+}
+
+func TestTakeSeparateKeys(t *testing.T) {
+	store := NewMemoryStore()
+	limit := Limit{Requests: 1, Per: time.Minute}
+
+	if retryAfter, _ := store.Take(context.Background(), "a", limit); retryAfter != 0 {
+		t.Fatalf("Take(a) = %v, want 0", retryAfter)
+	}
+	if retryAfter, _ := store.Take(context.Background(), "b", limit); retryAfter != 0 {
+		t.Errorf("Take(b) = %v after emptying a, want 0", retryAfter)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	c := &clock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store := NewMemoryStore()
+	store.now = c.Now
+	store.prunedAt = c.now
+
+	limit := Limit{Requests: 6, Per: time.Minute, Burst: 3}
+	store.Take(context.Background(), "idle", limit)
+
+	// A minute on the idle bucket is full again, and dropped on the next call
+	c.Advance(pruneInterval)
+	store.Take(context.Background(), "busy", limit)
+
+	if _, ok := store.buckets["idle"]; ok {
+		t.Error("full bucket was not pruned")
+	}
+	if _, ok := store.buckets["busy"]; !ok {
+		t.Error("bucket in use was pruned")
+	}
+}
@@ -0,0 +1,42 @@
+package util
+
+import (
+	"github.com/madeindra/mock-conversation/server/internal/data"
+	"github.com/madeindra/mock-conversation/server/internal/model"
+)
+
+func ConvertToAccount(account *data.Account) model.AccountResponse {
+	return model.AccountResponse{
+		ID:        account.ID,
+		Username:  account.Username,
+		CreatedAt: account.CreatedAt,
+	}
+}
+
+func ConvertToAPIKey(key *data.APIKey) model.APIKeyResponse {
+	response := model.APIKeyResponse{
+		ID:        key.ID,
+		Name:      key.Name,
+		Prefix:    key.Prefix,
+		Scopes:    key.Scopes,
+		CreatedAt: key.CreatedAt,
+	}
+
+	if !key.LastUsedAt.IsZero() {
+		response.LastUsedAt = Pointer(key.LastUsedAt)
+	}
+
+	if !key.RevokedAt.IsZero() {
+		response.RevokedAt = Pointer(key.RevokedAt)
+	}
+
+	return response
+}
+
+func ConvertToProviderKey(key *data.ProviderKey) model.ProviderKeyResponse {
+	return model.ProviderKeyResponse{
+		Hint:      key.Hint,
+		CreatedAt: key.CreatedAt,
+		UpdatedAt: key.UpdatedAt,
+	}
+}
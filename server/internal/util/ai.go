@@ -5,25 +5,63 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"strings"
 
+	"github.com/madeindra/mock-conversation/server/internal/data"
 	"github.com/madeindra/mock-conversation/server/internal/openai"
 )
 
-func GenerateStartChat(ai openai.Client, role, topic, language, subtitleLanguage string) (string, openai.AnswerChatResult, error) {
+// ChatOptions carries the per-conversation settings that shape the JSON
+// instruction sent along with every chat completion.
+type ChatOptions struct {
+	SubtitleLanguage string
+	Transliteration  string
+	Objectives       []data.Objective
+	Characters       []data.Character
+	Closing          Closing
+
+	// ReplyLanguage is set when the conversation follows the user's language
+	ReplyLanguage string
+
+	// LanguageMismatch names the language the user answered in when it is
+	// not the conversation language
+	LanguageMismatch string
+}
+
+func GenerateStartChat(ai openai.Client, prompt openai.SystemPrompt, opts ChatOptions) (string, openai.AnswerChatResult, error) {
 	if ai == nil {
 		return "", openai.AnswerChatResult{}, fmt.Errorf("unsupported client")
 	}
 
-	systemPrompt, err := openai.GetSystemPrompt(role, topic, language)
+	systemPrompt, err := openai.GetSystemPrompt(prompt)
 	if err != nil {
 		return "", openai.AnswerChatResult{}, err
 	}
 
-	jsonInstruction := `Respond in JSON with: {"response": "your greeting"}`
-	if subtitleLanguage != "" {
-		jsonInstruction = fmt.Sprintf(`Respond in JSON with: {"response": "your greeting", "responseSubtitle": "complete and accurate translation of your entire greeting in %s"}`, subtitleLanguage)
+	result, err := GenerateGreeting(ai, systemPrompt, opts)
+	if err != nil {
+		return "", openai.AnswerChatResult{}, err
+	}
+
+	return systemPrompt, result, nil
+}
+
+// GenerateGreeting produces the opening line of a conversation from its system prompt.
+func GenerateGreeting(ai openai.Client, systemPrompt string, opts ChatOptions) (openai.AnswerChatResult, error) {
+	if ai == nil {
+		return openai.AnswerChatResult{}, fmt.Errorf("unsupported client")
+	}
+
+	fields := append(speakerFields(opts), `"response": "your greeting"`)
+	if opts.SubtitleLanguage != "" {
+		fields = append(fields, fmt.Sprintf(`"responseSubtitle": "complete and accurate translation of your entire greeting in %s"`, opts.SubtitleLanguage))
+	}
+	if opts.Transliteration != "" {
+		fields = append(fields, fmt.Sprintf(`"responseTransliteration": "your entire greeting written in %s"`, opts.Transliteration))
 	}
 
+	jsonInstruction := "Respond in JSON with: " + jsonObject(fields)
+
 	messages := []openai.ChatMessage{
 		{
 			Role:    openai.ROLE_SYSTEM,
@@ -37,37 +75,75 @@ func GenerateStartChat(ai openai.Client, role, topic, language, subtitleLanguage
 
 	rawJSON, err := ai.Chat(messages)
 	if err != nil {
-		return "", openai.AnswerChatResult{}, err
+		return openai.AnswerChatResult{}, err
 	}
 
 	var result openai.AnswerChatResult
 	if err := json.Unmarshal([]byte(rawJSON), &result); err != nil {
-		return "", openai.AnswerChatResult{}, fmt.Errorf("failed to parse initial chat JSON: %w, raw: %s", err, rawJSON)
+		return openai.AnswerChatResult{}, fmt.Errorf("failed to parse initial chat JSON: %w, raw: %s", err, rawJSON)
 	}
 
 	if result.Response == "" {
-		return "", openai.AnswerChatResult{}, fmt.Errorf("empty initial chat response")
+		return openai.AnswerChatResult{}, fmt.Errorf("empty initial chat response")
 	}
 
-	return systemPrompt, result, nil
+	return result, nil
 }
 
-func TranscribeSpeech(ai openai.Client, audio io.Reader, filename string, language string) (string, error) {
+func TranscribeSpeech(ai openai.Client, audio io.Reader, filename string, language string) (openai.TranscriptResponse, error) {
 	if ai == nil {
-		return "", fmt.Errorf("unsupported client")
+		return openai.TranscriptResponse{}, fmt.Errorf("unsupported client")
 	}
 
 	return ai.Transcribe(audio, filename, language)
 }
 
-func GenerateAnswerChat(ai openai.Client, history []openai.ChatMessage, transcript string, subtitleLanguage string) (openai.AnswerChatResult, error) {
+func GenerateAnswerChat(ai openai.Client, history []openai.ChatMessage, transcript string, opts ChatOptions) (openai.AnswerChatResult, error) {
 	if ai == nil {
 		return openai.AnswerChatResult{}, fmt.Errorf("unsupported client")
 	}
 
-	jsonInstruction := `You MUST respond in JSON with: {"response": "your reply", "isLast": false}. Set isLast to true only when the conversation is ending (user says goodbye or you decide to end it). When isLast is true, respond with a natural farewell.`
-	if subtitleLanguage != "" {
-		jsonInstruction = fmt.Sprintf(`You MUST respond in JSON with: {"response": "your reply", "responseSubtitle": "complete and accurate translation of your entire reply in %s", "transcriptSubtitle": "complete and accurate translation of the user's entire message in %s", "isLast": false}. Set isLast to true only when the conversation is ending (user says goodbye or you decide to end it). When isLast is true, respond with a natural farewell.`, subtitleLanguage, subtitleLanguage)
+	fields := append(speakerFields(opts), `"response": "your reply"`)
+	if opts.SubtitleLanguage != "" {
+		fields = append(fields,
+			fmt.Sprintf(`"responseSubtitle": "complete and accurate translation of your entire reply in %s"`, opts.SubtitleLanguage),
+			fmt.Sprintf(`"transcriptSubtitle": "complete and accurate translation of the user's entire message in %s"`, opts.SubtitleLanguage),
+		)
+	}
+	if opts.Transliteration != "" {
+		fields = append(fields,
+			fmt.Sprintf(`"responseTransliteration": "your entire reply written in %s"`, opts.Transliteration),
+			fmt.Sprintf(`"transcriptTransliteration": "the user's entire message written in %s"`, opts.Transliteration),
+		)
+	}
+
+	pending := pendingObjectives(opts.Objectives)
+	if len(pending) > 0 {
+		fields = append(fields, `"completedObjectives": [numbers of the goals the user accomplished with this message]`)
+	}
+
+	fields = append(fields, `"isLast": false`)
+
+	jsonInstruction := "You MUST respond in JSON with: " + jsonObject(fields) + ". Set isLast to true only when the conversation is ending (user says goodbye or you decide to end it). When isLast is true, respond with a natural farewell."
+	if len(pending) > 0 {
+		jsonInstruction += " The goals the user has not accomplished yet are: " + numberedObjectives(pending) + ". Only list a goal in completedObjectives when the user's message clearly accomplishes it. If the user's message accomplishes every remaining goal, set isLast to true and respond with a natural farewell."
+	}
+
+	jsonInstruction += speakerNote(opts)
+
+	if opts.ReplyLanguage != "" {
+		jsonInstruction += fmt.Sprintf(" The user is speaking %s, so reply in %s.", opts.ReplyLanguage, opts.ReplyLanguage)
+	}
+
+	if opts.LanguageMismatch != "" {
+		jsonInstruction += fmt.Sprintf(" The user answered in %s instead of the language of this conversation. Stay in character, keep replying in the conversation's language, and gently encourage the user to answer in it.", opts.LanguageMismatch)
+	}
+
+	switch opts.Closing {
+	case ClosingSoon:
+		jsonInstruction += " The conversation is almost out of time, so start steering it toward a natural close."
+	case ClosingNow:
+		jsonInstruction += " The conversation has reached its limit: this is your last reply, so respond to the user and close with a natural farewell, and set isLast to true."
 	}
 
 	// Copy history and inject JSON instruction into system prompt
@@ -104,14 +180,24 @@ func GenerateAnswerChat(ai openai.Client, history []openai.ChatMessage, transcri
 	return result, nil
 }
 
-func GenerateEndChat(ai openai.Client, history []openai.ChatMessage, subtitleLanguage string) (openai.AnswerChatResult, error) {
+func GenerateEndChat(ai openai.Client, history []openai.ChatMessage, opts ChatOptions) (openai.AnswerChatResult, error) {
 	if ai == nil {
 		return openai.AnswerChatResult{}, fmt.Errorf("unsupported client")
 	}
 
-	jsonInstruction := `The user has decided to end the conversation. You MUST respond in JSON with: {"response": "your farewell", "isLast": true}. Provide a natural farewell message.`
-	if subtitleLanguage != "" {
-		jsonInstruction = fmt.Sprintf(`The user has decided to end the conversation. You MUST respond in JSON with: {"response": "your farewell", "responseSubtitle": "complete and accurate translation of your entire farewell in %s", "isLast": true}. Provide a natural farewell message.`, subtitleLanguage)
+	fields := append(speakerFields(opts), `"response": "your farewell"`)
+	if opts.SubtitleLanguage != "" {
+		fields = append(fields, fmt.Sprintf(`"responseSubtitle": "complete and accurate translation of your entire farewell in %s"`, opts.SubtitleLanguage))
+	}
+	if opts.Transliteration != "" {
+		fields = append(fields, fmt.Sprintf(`"responseTransliteration": "your entire farewell written in %s"`, opts.Transliteration))
+	}
+	fields = append(fields, `"isLast": true`)
+
+	jsonInstruction := "The user has decided to end the conversation. You MUST respond in JSON with: " + jsonObject(fields) + ". Provide a natural farewell message."
+	jsonInstruction += speakerNote(opts)
+	if opts.ReplyLanguage != "" {
+		jsonInstruction += fmt.Sprintf(" Say it in %s.", opts.ReplyLanguage)
 	}
 
 	messages := make([]openai.ChatMessage, len(history))
@@ -140,6 +226,131 @@ func GenerateEndChat(ai openai.Client, history []openai.ChatMessage, subtitleLan
 	return result, nil
 }
 
+// GenerateSummary folds the given messages into the running summary of the
+// conversation and returns the updated summary.
+func GenerateSummary(ai openai.Client, summary string, messages []openai.ChatMessage) (string, error) {
+	if ai == nil {
+		return "", fmt.Errorf("unsupported client")
+	}
+
+	var transcript strings.Builder
+	for _, msg := range messages {
+		speaker := string(msg.Role)
+		if msg.Name != "" {
+			speaker += " (" + msg.Name + ")"
+		}
+		transcript.WriteString(speaker + ": " + msg.Content + "\n")
+	}
+
+	instruction := `You condense role-play conversations for a language learning app. Write a concise summary, in English, of everything that happened in the conversation so far: the facts established, what each side said or agreed to, and any open questions. Write it in the third person and keep it under 200 words. Respond in JSON with: {"summary": "the updated summary"}`
+
+	content := "Conversation:\n" + transcript.String()
+	if summary != "" {
+		content = "Summary of the earlier part of the conversation: " + summary + "\n\n" + content
+	}
+
+	rawJSON, err := ai.Chat([]openai.ChatMessage{
+		{
+			Role:    openai.ROLE_SYSTEM,
+			Content: instruction,
+		},
+		{
+			Role:    openai.ROLE_USER,
+			Content: content,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		Summary string `json:"summary"`
+	}
+	if err := json.Unmarshal([]byte(rawJSON), &result); err != nil {
+		return "", fmt.Errorf("failed to parse summary JSON: %w, raw: %s", err, rawJSON)
+	}
+
+	if result.Summary == "" {
+		return "", fmt.Errorf("empty summary")
+	}
+
+	return result.Summary, nil
+}
+
+// GenerateTranslation translates a single line of the conversation into the
+// named language.
+func GenerateTranslation(ai openai.Client, text, language string) (string, error) {
+	if ai == nil {
+		return "", fmt.Errorf("unsupported client")
+	}
+
+	instruction := fmt.Sprintf(`You translate lines of role-play conversations for a language learning app. Translate the user's message into %s, keeping its meaning and tone. Respond in JSON with: {"translation": "the translated text"}`, language)
+
+	rawJSON, err := ai.Chat([]openai.ChatMessage{
+		{
+			Role:    openai.ROLE_SYSTEM,
+			Content: instruction,
+		},
+		{
+			Role:    openai.ROLE_USER,
+			Content: text,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		Translation string `json:"translation"`
+	}
+	if err := json.Unmarshal([]byte(rawJSON), &result); err != nil {
+		return "", fmt.Errorf("failed to parse translation JSON: %w, raw: %s", err, rawJSON)
+	}
+
+	if result.Translation == "" {
+		return "", fmt.Errorf("empty translation")
+	}
+
+	return result.Translation, nil
+}
+
+// GenerateGlossary breaks a sentence written in language down word by word,
+// explaining every word in the learner's language.
+func GenerateGlossary(ai openai.Client, text, language, learnerLanguage string) ([]openai.GlossaryToken, error) {
+	if ai == nil {
+		return nil, fmt.Errorf("unsupported client")
+	}
+
+	instruction := fmt.Sprintf(`You explain %s sentences word by word for a language learning app. Split the user's message into its words and particles, in order, leaving out punctuation. For each one give its dictionary form, its part of speech, its meaning in this sentence translated into %s, and a short usage note in %s when it helps a learner. Respond in JSON with: {"tokens": [{"text": "the word as written", "lemma": "dictionary form", "partOfSpeech": "part of speech", "translation": "meaning", "note": "short usage note or empty"}]}`, language, learnerLanguage, learnerLanguage)
+
+	rawJSON, err := ai.Chat([]openai.ChatMessage{
+		{
+			Role:    openai.ROLE_SYSTEM,
+			Content: instruction,
+		},
+		{
+			Role:    openai.ROLE_USER,
+			Content: text,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Tokens []openai.GlossaryToken `json:"tokens"`
+	}
+	if err := json.Unmarshal([]byte(rawJSON), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse glossary JSON: %w, raw: %s", err, rawJSON)
+	}
+
+	if len(result.Tokens) == 0 {
+		return nil, fmt.Errorf("empty glossary")
+	}
+
+	return result.Tokens, nil
+}
+
 func GenerateSpeech(ai openai.Client, text, voice, language string) (string, error) {
 	if ai == nil {
 		return "", nil
@@ -160,3 +371,78 @@ func GenerateSpeech(ai openai.Client, text, voice, language string) (string, err
 
 	return base64.StdEncoding.EncodeToString(speechByte), nil
 }
+
+// CompletedObjectiveIDs maps the goal numbers returned by the chat model back
+// to the IDs of the pending objectives they refer to. Unknown numbers are ignored.
+func CompletedObjectiveIDs(objectives []data.Objective, numbers []int) []string {
+	pending := pendingObjectives(objectives)
+
+	var ids []string
+	seen := make(map[int]bool)
+	for _, number := range numbers {
+		if number < 1 || number > len(pending) || seen[number] {
+			continue
+		}
+		seen[number] = true
+		ids = append(ids, pending[number-1].ID)
+	}
+	return ids
+}
+
+func speakerFields(opts ChatOptions) []string {
+	if len(opts.Characters) == 0 {
+		return nil
+	}
+
+	names := make([]string, len(opts.Characters))
+	for i, character := range opts.Characters {
+		names[i] = character.Name
+	}
+
+	return []string{fmt.Sprintf(`"speaker": "name of the character speaking, exactly one of: %s"`, strings.Join(names, ", "))}
+}
+
+// speakerNote explains the message names that mark which character spoke
+// each earlier reply, mapping back the names that had to change to be used as
+// one.
+func speakerNote(opts ChatOptions) string {
+	if len(opts.Characters) == 0 {
+		return ""
+	}
+
+	var renamed []string
+	for i, tag := range SpeakerTags(opts.Characters) {
+		if name := opts.Characters[i].Name; tag != name {
+			renamed = append(renamed, fmt.Sprintf("%s is %s", tag, name))
+		}
+	}
+
+	note := " Earlier replies carry the character who spoke them in the message name; never write a name into the response itself."
+	if len(renamed) > 0 {
+		note += " In those message names, " + strings.Join(renamed, ", ") + "."
+	}
+
+	return note
+}
+
+func pendingObjectives(objectives []data.Objective) []data.Objective {
+	var pending []data.Objective
+	for _, objective := range objectives {
+		if !objective.IsCompleted() {
+			pending = append(pending, objective)
+		}
+	}
+	return pending
+}
+
+func numberedObjectives(objectives []data.Objective) string {
+	items := make([]string, len(objectives))
+	for i, objective := range objectives {
+		items[i] = fmt.Sprintf("%d. %s", i+1, objective.Description)
+	}
+	return strings.Join(items, "; ")
+}
+
+func jsonObject(fields []string) string {
+	return "{" + strings.Join(fields, ", ") + "}"
+}
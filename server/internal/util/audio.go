@@ -0,0 +1,40 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// WAVDuration reads the duration in seconds from the header of a PCM WAV file,
+// which is what the client uploads. It reports false for anything else, in
+// which case the duration is only known after transcription.
+func WAVDuration(audio []byte) (float64, bool) {
+	if len(audio) < 12 || !bytes.Equal(audio[0:4], []byte("RIFF")) || !bytes.Equal(audio[8:12], []byte("WAVE")) {
+		return 0, false
+	}
+
+	var byteRate uint32
+	for offset := 12; offset+8 <= len(audio); {
+		chunkID := string(audio[offset : offset+4])
+		chunkSize := binary.LittleEndian.Uint32(audio[offset+4 : offset+8])
+		body := offset + 8
+
+		switch chunkID {
+		case "fmt ":
+			if body+12 > len(audio) {
+				return 0, false
+			}
+			byteRate = binary.LittleEndian.Uint32(audio[body+8 : body+12])
+		case "data":
+			if byteRate == 0 {
+				return 0, false
+			}
+			return float64(chunkSize) / float64(byteRate), true
+		}
+
+		// Chunks are padded to an even size
+		offset = body + int(chunkSize) + int(chunkSize%2)
+	}
+
+	return 0, false
+}
@@ -0,0 +1,144 @@
+package util
+
+import (
+	"time"
+
+	"github.com/madeindra/mock-conversation/server/internal/config"
+	"github.com/madeindra/mock-conversation/server/internal/data"
+	"github.com/madeindra/mock-conversation/server/internal/model"
+)
+
+type BudgetLevel string
+
+const (
+	BudgetOK       BudgetLevel = "ok"
+	BudgetWarning  BudgetLevel = "warning"
+	BudgetExceeded BudgetLevel = "exceeded"
+)
+
+// BudgetState is a budget that applies to a request, with the usage counted
+// against it.
+type BudgetState struct {
+	Subject data.BudgetSubject
+	ID      string
+	Name    string
+	Limits  config.Budget
+	Usage   data.UsageTotals
+}
+
+// BudgetPeriod is the daily or monthly part of a budget.
+type BudgetPeriod struct {
+	Name      string
+	Spent     int64
+	CostLimit int64
+	Turns     int
+	TurnLimit int
+	ResetsAt  time.Time
+}
+
+// Periods splits the budget into its daily and monthly parts. Days and months
+// start at midnight UTC.
+func (b BudgetState) Periods(now time.Time) []BudgetPeriod {
+	day, month := BudgetPeriodStarts(now)
+
+	return []BudgetPeriod{
+		{
+			Name:      "daily",
+			Spent:     b.Usage.DailyCost,
+			CostLimit: b.Limits.DailyCost,
+			Turns:     b.Usage.DailyTurns,
+			TurnLimit: b.Limits.DailyTurns,
+			ResetsAt:  day.AddDate(0, 0, 1),
+		},
+		{
+			Name:      "monthly",
+			Spent:     b.Usage.MonthlyCost,
+			CostLimit: b.Limits.MonthlyCost,
+			Turns:     b.Usage.MonthlyTurns,
+			TurnLimit: b.Limits.MonthlyTurns,
+			ResetsAt:  month.AddDate(0, 1, 0),
+		},
+	}
+}
+
+// BudgetPeriodStarts returns the start of the day and of the month now falls
+// in, in UTC.
+func BudgetPeriodStarts(now time.Time) (time.Time, time.Time) {
+	now = now.UTC()
+
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	return day, month
+}
+
+func (p BudgetPeriod) CostLevel(warnPercent int) BudgetLevel {
+	return budgetLevel(p.Spent, p.CostLimit, warnPercent)
+}
+
+func (p BudgetPeriod) TurnLevel(warnPercent int) BudgetLevel {
+	return budgetLevel(int64(p.Turns), int64(p.TurnLimit), warnPercent)
+}
+
+// Level is the worse of the spending and turn levels.
+func (p BudgetPeriod) Level(warnPercent int) BudgetLevel {
+	cost, turns := p.CostLevel(warnPercent), p.TurnLevel(warnPercent)
+	if cost == BudgetExceeded || turns == BudgetExceeded {
+		return BudgetExceeded
+	}
+	if cost == BudgetWarning || turns == BudgetWarning {
+		return BudgetWarning
+	}
+	return BudgetOK
+}
+
+func budgetLevel(used, limit int64, warnPercent int) BudgetLevel {
+	switch {
+	case limit <= 0:
+		return BudgetOK
+	case used >= limit:
+		return BudgetExceeded
+	case used*100 >= limit*int64(warnPercent):
+		return BudgetWarning
+	default:
+		return BudgetOK
+	}
+}
+
+// Dollars converts millionths of a US dollar to dollars.
+func Dollars(micros int64) float64 {
+	return float64(micros) / 1e6
+}
+
+func ConvertToBudget(budget BudgetState, now time.Time, warnPercent int) model.BudgetResponse {
+	periods := budget.Periods(now)
+
+	return model.BudgetResponse{
+		Subject: string(budget.Subject),
+		ID:      budget.ID,
+		Name:    budget.Name,
+		Daily:   convertToBudgetPeriod(periods[0], warnPercent),
+		Monthly: convertToBudgetPeriod(periods[1], warnPercent),
+	}
+}
+
+func convertToBudgetPeriod(period BudgetPeriod, warnPercent int) model.BudgetPeriodResponse {
+	response := model.BudgetPeriodResponse{
+		Spent:    Dollars(period.Spent),
+		Turns:    period.Turns,
+		Status:   string(period.Level(warnPercent)),
+		ResetsAt: period.ResetsAt,
+	}
+
+	if period.CostLimit > 0 {
+		response.CostLimit = Pointer(Dollars(period.CostLimit))
+		response.CostRemaining = Pointer(Dollars(max(period.CostLimit-period.Spent, 0)))
+	}
+
+	if period.TurnLimit > 0 {
+		response.TurnLimit = Pointer(period.TurnLimit)
+		response.TurnsRemaining = Pointer(max(period.TurnLimit-period.Turns, 0))
+	}
+
+	return response
+}
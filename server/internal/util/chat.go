@@ -1,17 +1,247 @@
 package util
 
 import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/madeindra/mock-conversation/server/internal/config"
 	"github.com/madeindra/mock-conversation/server/internal/data"
+	"github.com/madeindra/mock-conversation/server/internal/model"
 	"github.com/madeindra/mock-conversation/server/internal/openai"
 )
 
-func ConvertToChatMessage(entries []data.Entry) []openai.ChatMessage {
+// maxSpeakerTagLength is the longest chat message name the API accepts
+const maxSpeakerTagLength = 64
+
+// ConvertToChatMessage turns stored entries into chat history. In scenes with
+// several characters, assistant lines carry the speaker in the message name
+// rather than in the text, so that the model can tell the characters apart
+// without learning to write names into its replies.
+func ConvertToChatMessage(entries []data.Entry, characters []data.Character) []openai.ChatMessage {
+	tags := make(map[string]string, len(characters))
+	for i, tag := range SpeakerTags(characters) {
+		tags[characters[i].ID] = tag
+	}
+
 	var messages []openai.ChatMessage
 	for _, entry := range entries {
 		messages = append(messages, openai.ChatMessage{
 			Role:    openai.Role(entry.Role),
 			Content: entry.Text,
+			Name:    tags[entry.CharacterID],
 		})
 	}
 	return messages
 }
+
+// SpeakerTags returns the chat message name of every character, in order.
+// Characters whose names come out the same, such as "José" and "Jos", are
+// told apart by a numeric suffix, so that no reply is put in the mouth of
+// another character.
+func SpeakerTags(characters []data.Character) []string {
+	tags := make([]string, len(characters))
+	taken := make(map[string]bool, len(characters))
+	for i, character := range characters {
+		base := speakerTag(character)
+
+		tag := base
+		for n := 2; taken[strings.ToLower(tag)]; n++ {
+			suffix := fmt.Sprintf("_%d", n)
+			tag = base[:min(len(base), maxSpeakerTagLength-len(suffix))] + suffix
+		}
+
+		taken[strings.ToLower(tag)] = true
+		tags[i] = tag
+	}
+	return tags
+}
+
+// speakerTag is the character's name as a chat message name: letters, digits,
+// underscores and hyphens only. Names with none of those, such as names in
+// other scripts, are tagged by the character's position instead.
+func speakerTag(character data.Character) string {
+	var tag strings.Builder
+	separate := false
+	for _, r := range character.Name {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_') {
+			if separate && tag.Len() > 0 {
+				tag.WriteByte('_')
+			}
+			tag.WriteRune(r)
+			separate = false
+		} else {
+			separate = true
+		}
+	}
+
+	if tag.Len() == 0 {
+		return fmt.Sprintf("character_%d", character.Position)
+	}
+
+	if tag.Len() > maxSpeakerTagLength {
+		return tag.String()[:maxSpeakerTagLength]
+	}
+
+	return tag.String()
+}
+
+func ConvertToObjectives(objectives []data.Objective) []model.Objective {
+	var result []model.Objective
+	for _, objective := range objectives {
+		result = append(result, model.Objective{
+			Description: objective.Description,
+			Completed:   objective.IsCompleted(),
+		})
+	}
+	return result
+}
+
+func ConvertToCharacters(characters []data.Character) []model.Character {
+	var result []model.Character
+	for _, character := range characters {
+		result = append(result, model.Character{
+			Name: character.Name,
+			Role: character.Role,
+		})
+	}
+	return result
+}
+
+func ConvertToLanguages(languages []config.LanguageInfo) []model.Language {
+	result := make([]model.Language, 0, len(languages))
+	for _, language := range languages {
+		result = append(result, model.Language{
+			Code:            language.Tag,
+			Name:            language.Name,
+			NativeName:      language.NativeName,
+			Script:          language.Script,
+			Direction:       language.Direction,
+			Transliteration: language.Transliteration != "",
+		})
+	}
+	return result
+}
+
+func ConvertToGlossary(tokens []openai.GlossaryToken) []model.GlossaryToken {
+	result := make([]model.GlossaryToken, 0, len(tokens))
+	for _, token := range tokens {
+		result = append(result, model.GlossaryToken{
+			Text:         token.Text,
+			Lemma:        token.Lemma,
+			PartOfSpeech: token.PartOfSpeech,
+			Translation:  token.Translation,
+			Note:         token.Note,
+		})
+	}
+	return result
+}
+
+// AllObjectivesCompleted reports whether the conversation has objectives and
+// every one of them has been completed.
+func AllObjectivesCompleted(objectives []data.Objective) bool {
+	if len(objectives) == 0 {
+		return false
+	}
+
+	return len(pendingObjectives(objectives)) == 0
+}
+
+// FindSpeaker resolves the speaker named by the chat model, by name or by
+// the message name its replies carry. Unknown names fall back to the first
+// character, and nil is returned when there are no characters.
+func FindSpeaker(characters []data.Character, name string) *data.Character {
+	if len(characters) == 0 {
+		return nil
+	}
+
+	name = strings.TrimSpace(name)
+	for i, character := range characters {
+		if strings.EqualFold(name, character.Name) {
+			return &characters[i]
+		}
+	}
+
+	for i, tag := range SpeakerTags(characters) {
+		if strings.EqualFold(name, tag) {
+			return &characters[i]
+		}
+	}
+
+	return &characters[0]
+}
+
+func CharacterID(character *data.Character) string {
+	if character == nil {
+		return ""
+	}
+	return character.ID
+}
+
+func CharacterName(character *data.Character) string {
+	if character == nil {
+		return ""
+	}
+	return character.Name
+}
+
+// MarkObjectivesCompleted returns a copy of the objectives where the ones with
+// the given IDs are completed by the given entry.
+func MarkObjectivesCompleted(objectives []data.Objective, ids []string, entryID string) []data.Objective {
+	completed := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		completed[id] = true
+	}
+
+	marked := make([]data.Objective, len(objectives))
+	copy(marked, objectives)
+
+	now := time.Now().UTC()
+	for i, objective := range marked {
+		if completed[objective.ID] && !objective.IsCompleted() {
+			marked[i].CompletedBy = entryID
+			marked[i].CompletedAt = &now
+		}
+	}
+	return marked
+}
+
+// ReopenedObjectives returns a copy of the objectives where the ones completed
+// by the given entry are pending again.
+func ReopenedObjectives(objectives []data.Objective, entryID string) []data.Objective {
+	reopened := make([]data.Objective, len(objectives))
+	copy(reopened, objectives)
+
+	for i, objective := range reopened {
+		if objective.CompletedBy == entryID {
+			reopened[i].CompletedBy = ""
+			reopened[i].CompletedAt = nil
+		}
+	}
+	return reopened
+}
+
+// CharacterByID returns the character with the given ID, or nil when the entry
+// was not spoken by a character.
+func CharacterByID(characters []data.Character, id string) *data.Character {
+	for i, character := range characters {
+		if character.ID == id {
+			return &characters[i]
+		}
+	}
+	return nil
+}
+
+// ConvertToLimits returns the conversation's limits, or nil when it has none.
+func ConvertToLimits(user *data.ChatUser) *model.Limits {
+	if user.MaxTurns <= 0 && user.MaxMinutes <= 0 && user.MaxAudioSeconds <= 0 {
+		return nil
+	}
+
+	return &model.Limits{
+		MaxTurns:        user.MaxTurns,
+		MaxMinutes:      user.MaxMinutes,
+		MaxAudioSeconds: user.MaxAudioSeconds,
+	}
+}
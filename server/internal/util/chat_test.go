@@ -0,0 +1,64 @@
+package util
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/madeindra/mock-conversation/server/internal/data"
+)
+
+func TestSpeakerTags(t *testing.T) {
+	long := strings.Repeat("a", maxSpeakerTagLength+10)
+
+	tests := []struct {
+		name  string
+		names []string
+		want  []string
+	}{
+		{name: "distinct names", names: []string{"Ana", "Bob"}, want: []string{"Ana", "Bob"}},
+		{name: "spaces", names: []string{"Ana María"}, want: []string{"Ana_Mar_a"}},
+		{name: "accent dropped onto another name", names: []string{"Jos", "José"}, want: []string{"Jos", "Jos_2"}},
+		{name: "spaces onto an underscored name", names: []string{"Ana María", "Ana_Mar_a"}, want: []string{"Ana_Mar_a", "Ana_Mar_a_2"}},
+		{name: "several collisions", names: []string{"Jo", "Jo!", "Jo?"}, want: []string{"Jo", "Jo_2", "Jo_3"}},
+		{name: "collision in another case", names: []string{"ana", "Ana!"}, want: []string{"ana", "Ana_2"}},
+		{name: "other script", names: []string{"さくら"}, want: []string{"character_0"}},
+		{name: "long names", names: []string{long, long + "!"}, want: []string{long[:maxSpeakerTagLength], long[:maxSpeakerTagLength-2] + "_2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			characters := make([]data.Character, len(tt.names))
+			for i, name := range tt.names {
+				characters[i] = data.Character{Name: name, Position: i}
+			}
+
+			if got := SpeakerTags(characters); !slices.Equal(got, tt.want) {
+				t.Errorf("SpeakerTags() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindSpeaker(t *testing.T) {
+	characters := []data.Character{{ID: "1", Name: "Jos"}, {ID: "2", Name: "José"}}
+
+	tests := []struct {
+		name    string
+		speaker string
+		want    string
+	}{
+		{name: "by name", speaker: "José", want: "2"},
+		{name: "by name in another case", speaker: " jos ", want: "1"},
+		{name: "by message name", speaker: "Jos_2", want: "2"},
+		{name: "unknown", speaker: "Maria", want: "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FindSpeaker(characters, tt.speaker); got.ID != tt.want {
+				t.Errorf("FindSpeaker(%q) = %s, want %s", tt.speaker, got.ID, tt.want)
+			}
+		})
+	}
+}
@@ -1,23 +1,65 @@
 package util
 
 import (
-	"math/rand"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
+// GenerateRandom returns a random alphanumeric string read from crypto/rand.
 func GenerateRandom() string {
 	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	const length = 10
+	const length = 32
 
-	random := make([]byte, length)
-	for i := range random {
-		random[i] = charset[rand.Intn(len(charset))]
+	// Bytes at or above the largest multiple of the charset size are skipped
+	// so that every character is equally likely
+	const limit = 256 - 256%len(charset)
+
+	random := make([]byte, 0, length)
+	buf := make([]byte, length)
+	for len(random) < length {
+		mustReadRandom(buf)
+
+		for _, b := range buf {
+			if int(b) < limit && len(random) < length {
+				random = append(random, charset[int(b)%len(charset)])
+			}
+		}
 	}
 
 	return string(random)
 }
 
+// GenerateToken returns size random bytes from crypto/rand, base64url encoded.
+func GenerateToken(size int) string {
+	token := make([]byte, size)
+	mustReadRandom(token)
+
+	return base64.RawURLEncoding.EncodeToString(token)
+}
+
+// HashToken hashes a high-entropy token for storage. Unlike passwords such
+// tokens need no slow hash, so they can be checked on every request.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CompareToken reports whether token matches the stored hash, in constant time.
+func CompareToken(token, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashToken(token)), []byte(hash)) == 1
+}
+
+func mustReadRandom(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		panic("failed to read random bytes: " + err.Error())
+	}
+}
+
 func CreateHash(plain string) (string, error) {
 	hash, err := bcrypt.GenerateFromPassword([]byte(plain), bcrypt.DefaultCost)
 	if err != nil {
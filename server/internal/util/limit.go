@@ -0,0 +1,81 @@
+package util
+
+import (
+	"time"
+
+	"github.com/madeindra/mock-conversation/server/internal/data"
+	"github.com/madeindra/mock-conversation/server/internal/openai"
+)
+
+// Closing tells how close a conversation is to its turn or time limit.
+type Closing int
+
+const (
+	ClosingNone Closing = iota
+	// ClosingSoon asks the AI to start steering toward a natural close
+	ClosingSoon
+	// ClosingNow makes the reply the conversation's farewell
+	ClosingNow
+)
+
+// How close to a limit a conversation must be before the AI starts wrapping up.
+const (
+	closingTurns   = 2
+	closingMinutes = 2 * time.Minute
+)
+
+// CountTurns returns the number of user messages in the entries.
+func CountTurns(entries []data.Entry) int {
+	turns := 0
+	for _, entry := range entries {
+		if entry.Role == string(openai.ROLE_USER) {
+			turns++
+		}
+	}
+	return turns
+}
+
+// ConversationClosing works out whether the reply to the given turn (counting
+// from 1) should carry on, start wrapping up, or close the conversation.
+func ConversationClosing(user *data.ChatUser, turn int, now time.Time) Closing {
+	closing := ClosingNone
+
+	if user.MaxTurns > 0 {
+		switch remaining := user.MaxTurns - turn; {
+		case remaining <= 0:
+			return ClosingNow
+		case remaining <= closingTurns:
+			closing = ClosingSoon
+		}
+	}
+
+	if user.MaxMinutes > 0 && !user.CreatedAt.IsZero() {
+		switch remaining := user.CreatedAt.Add(time.Duration(user.MaxMinutes) * time.Minute).Sub(now); {
+		case remaining <= 0:
+			return ClosingNow
+		case remaining <= closingMinutes:
+			closing = ClosingSoon
+		}
+	}
+
+	return closing
+}
+
+// TimeLimitReached tells whether the conversation has run past its time limit.
+func TimeLimitReached(user *data.ChatUser, now time.Time) bool {
+	if user.MaxMinutes <= 0 || user.CreatedAt.IsZero() {
+		return false
+	}
+
+	return !now.Before(user.CreatedAt.Add(time.Duration(user.MaxMinutes) * time.Minute))
+}
+
+// TurnsRemaining returns how many more turns the user may take, or nil when
+// the conversation has no turn limit.
+func TurnsRemaining(user *data.ChatUser, turns int) *int {
+	if user.MaxTurns <= 0 {
+		return nil
+	}
+
+	return Pointer(max(user.MaxTurns-turns, 0))
+}
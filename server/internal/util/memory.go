@@ -0,0 +1,80 @@
+package util
+
+import (
+	"unicode/utf8"
+
+	"github.com/madeindra/mock-conversation/server/internal/config"
+	"github.com/madeindra/mock-conversation/server/internal/data"
+	"github.com/madeindra/mock-conversation/server/internal/openai"
+)
+
+// BuildHistory turns the stored entries into the history sent to the chat
+// model: the system prompt with the running summary appended, followed by the
+// entries the summary does not cover yet.
+func BuildHistory(user *data.ChatUser, entries []data.Entry, characters []data.Character) []openai.ChatMessage {
+	var kept []data.Entry
+	for _, entry := range entries {
+		if entry.Role == string(openai.ROLE_SYSTEM) || entry.Position > user.SummaryPosition {
+			kept = append(kept, entry)
+		}
+	}
+
+	messages := ConvertToChatMessage(kept, characters)
+	if user.Summary == "" {
+		return messages
+	}
+
+	for i, msg := range messages {
+		if msg.Role == openai.ROLE_SYSTEM {
+			messages[i].Content = msg.Content + "\n\nSummary of the conversation so far: " + user.Summary
+			break
+		}
+	}
+	return messages
+}
+
+// EntriesToFold returns the oldest unsummarized entries to fold into the
+// summary when the history exceeds the token budget, always keeping the most
+// recent turns verbatim. It returns nil when nothing needs folding.
+func EntriesToFold(user *data.ChatUser, entries []data.Entry, memory config.Memory) []data.Entry {
+	if memory.TokenBudget <= 0 {
+		return nil
+	}
+
+	tokens := EstimateTokens(user.Summary)
+	var unsummarized []data.Entry
+	for _, entry := range entries {
+		switch {
+		case entry.Role == string(openai.ROLE_SYSTEM):
+			tokens += EstimateTokens(entry.Text)
+		case entry.Position > user.SummaryPosition:
+			tokens += EstimateTokens(entry.Text)
+			unsummarized = append(unsummarized, entry)
+		}
+	}
+
+	if tokens <= memory.TokenBudget {
+		return nil
+	}
+
+	// Walk back over the recent turns, each starting with a user message
+	cut := len(unsummarized)
+	for turns := 0; cut > 0 && turns < memory.RecentTurns; {
+		cut--
+		if unsummarized[cut].Role == string(openai.ROLE_USER) {
+			turns++
+		}
+	}
+
+	if cut == 0 {
+		return nil
+	}
+
+	return unsummarized[:cut]
+}
+
+// EstimateTokens approximates the token count of a text at four characters
+// per token, which is close enough for budgeting.
+func EstimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
@@ -0,0 +1,72 @@
+package util
+
+import (
+	"github.com/madeindra/mock-conversation/server/internal/data"
+	"github.com/madeindra/mock-conversation/server/internal/model"
+)
+
+// ConvertToOrganization converts an organization as read for the given
+// account.
+func ConvertToOrganization(organization *data.Organization, accountID string) model.OrganizationResponse {
+	return model.OrganizationResponse{
+		ID:        organization.ID,
+		Name:      organization.Name,
+		Role:      string(organization.Role),
+		Owner:     organization.OwnerID == accountID,
+		CreatedAt: organization.CreatedAt,
+	}
+}
+
+func ConvertToInvites(invites []data.Invite) []model.InviteResponse {
+	response := make([]model.InviteResponse, len(invites))
+	for i, invite := range invites {
+		response[i] = model.InviteResponse{
+			OrganizationID:   invite.OrganizationID,
+			OrganizationName: invite.OrganizationName,
+			Role:             string(invite.Role),
+			InvitedBy:        invite.InvitedBy,
+			CreatedAt:        invite.CreatedAt,
+		}
+	}
+	return response
+}
+
+func ConvertToClassroom(classroom *data.Classroom) model.ClassroomResponse {
+	return model.ClassroomResponse{
+		ID:             classroom.ID,
+		OrganizationID: classroom.OrganizationID,
+		Name:           classroom.Name,
+		CreatedAt:      classroom.CreatedAt,
+	}
+}
+
+func ConvertToMembers(members []data.Member) []model.MemberResponse {
+	response := make([]model.MemberResponse, len(members))
+	for i, member := range members {
+		response[i] = model.MemberResponse{
+			AccountID: member.AccountID,
+			Username:  member.Username,
+			Role:      string(member.Role),
+			JoinedAt:  member.CreatedAt,
+		}
+	}
+	return response
+}
+
+func ConvertToStudentProgress(progress []data.StudentProgress) []model.StudentProgressResponse {
+	response := make([]model.StudentProgressResponse, len(progress))
+	for i, student := range progress {
+		response[i] = model.StudentProgressResponse{
+			AccountID:           student.AccountID,
+			Username:            student.Username,
+			Conversations:       student.Conversations,
+			Ended:               student.Ended,
+			ObjectivesCompleted: student.ObjectivesCompleted,
+			ObjectivesTotal:     student.ObjectivesTotal,
+		}
+		if !student.LastActiveAt.IsZero() {
+			response[i].LastActiveAt = Pointer(student.LastActiveAt)
+		}
+	}
+	return response
+}
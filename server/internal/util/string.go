@@ -1,8 +1,12 @@
 package util
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
+
+	"github.com/madeindra/mock-conversation/server/internal/data"
+	"github.com/madeindra/mock-conversation/server/internal/model"
 )
 
 func SanitizeString(text string) string {
@@ -23,3 +27,39 @@ func SanitizeString(text string) string {
 	return text
 }
 
+// CleanObjectives trims every objective and drops the empty ones.
+func CleanObjectives(objectives []string) []string {
+	var cleaned []string
+	for _, objective := range objectives {
+		if objective = strings.TrimSpace(objective); objective != "" {
+			cleaned = append(cleaned, objective)
+		}
+	}
+	return cleaned
+}
+
+// CleanCharacters trims character names and roles, and rejects scenes with
+// too many characters, missing fields or duplicate names.
+func CleanCharacters(characters []model.Character, max int) ([]data.Character, error) {
+	if len(characters) > max {
+		return nil, fmt.Errorf("a scene can have at most %d characters", max)
+	}
+
+	seen := make(map[string]bool)
+	cleaned := make([]data.Character, 0, len(characters))
+	for _, character := range characters {
+		name := strings.TrimSpace(character.Name)
+		role := strings.TrimSpace(character.Role)
+		if name == "" || role == "" {
+			return nil, fmt.Errorf("every character needs a name and a role")
+		}
+
+		if seen[strings.ToLower(name)] {
+			return nil, fmt.Errorf("character names must be unique")
+		}
+		seen[strings.ToLower(name)] = true
+
+		cleaned = append(cleaned, data.Character{Name: name, Role: role})
+	}
+	return cleaned, nil
+}
@@ -1,12 +1,19 @@
 package main
 
 import (
+	"encoding/base64"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/madeindra/mock-conversation/server/internal/config"
 	"github.com/madeindra/mock-conversation/server/internal/handler"
+	"github.com/madeindra/mock-conversation/server/internal/keyring"
+	"github.com/madeindra/mock-conversation/server/internal/ratelimit"
+	"github.com/madeindra/mock-conversation/server/internal/util"
 )
 
 const (
@@ -14,17 +21,90 @@ const (
 	envAPIKey = "OPENAI_API_KEY"
 	envDBPath = "DB_PATH"
 
+	envLanguagesPath = "LANGUAGES_PATH"
+
+	envAuthTokenSecret        = "AUTH_TOKEN_SECRET"
+	envAuthAccessTokenMinutes = "AUTH_ACCESS_TOKEN_MINUTES"
+	envAuthRefreshTokenDays   = "AUTH_REFRESH_TOKEN_DAYS"
+	envAdminAccountIDs        = "ADMIN_ACCOUNT_IDS"
+
+	envOIDCIssuer            = "OIDC_ISSUER"
+	envOIDCClientID          = "OIDC_CLIENT_ID"
+	envOIDCClientSecret      = "OIDC_CLIENT_SECRET"
+	envOIDCRedirectURL       = "OIDC_REDIRECT_URL"
+	envOIDCClientRedirectURL = "OIDC_CLIENT_REDIRECT_URL"
+
+	envAbandonAfterMinutes  = "CHAT_ABANDON_AFTER_MINUTES"
+	envEndingTimeoutMinutes = "CHAT_ENDING_TIMEOUT_MINUTES"
+	envMaxTurns             = "CHAT_MAX_TURNS"
+	envMaxMinutes           = "CHAT_MAX_MINUTES"
+	envMaxAudioSeconds      = "CHAT_MAX_AUDIO_SECONDS"
+	envMemoryTokenBudget    = "CHAT_MEMORY_TOKEN_BUDGET"
+	envMemoryRecentTurns    = "CHAT_MEMORY_RECENT_TURNS"
+
+	envRateLimitIPPerMinute           = "RATE_LIMIT_IP_PER_MINUTE"
+	envRateLimitIPBurst               = "RATE_LIMIT_IP_BURST"
+	envRateLimitAccountPerMinute      = "RATE_LIMIT_ACCOUNT_PER_MINUTE"
+	envRateLimitAccountBurst          = "RATE_LIMIT_ACCOUNT_BURST"
+	envRateLimitConversationPerMinute = "RATE_LIMIT_CONVERSATION_PER_MINUTE"
+	envRateLimitConversationBurst     = "RATE_LIMIT_CONVERSATION_BURST"
+	envRateLimitOIDCLoginPerMinute    = "RATE_LIMIT_OIDC_LOGIN_PER_MINUTE"
+	envRateLimitOIDCLoginBurst        = "RATE_LIMIT_OIDC_LOGIN_BURST"
+	envRateLimitStartChatPerMinute    = "RATE_LIMIT_START_CHAT_PER_MINUTE"
+	envRateLimitStartChatBurst        = "RATE_LIMIT_START_CHAT_BURST"
+	envRateLimitTrustProxy            = "RATE_LIMIT_TRUST_PROXY"
+	envMaxUpstreamCalls               = "MAX_UPSTREAM_CALLS"
+
+	envBudgetAccountDailyUSD          = "BUDGET_ACCOUNT_DAILY_USD"
+	envBudgetAccountMonthlyUSD        = "BUDGET_ACCOUNT_MONTHLY_USD"
+	envBudgetAccountDailyTurns        = "BUDGET_ACCOUNT_DAILY_TURNS"
+	envBudgetAccountMonthlyTurns      = "BUDGET_ACCOUNT_MONTHLY_TURNS"
+	envBudgetOrganizationDailyUSD     = "BUDGET_ORGANIZATION_DAILY_USD"
+	envBudgetOrganizationMonthlyUSD   = "BUDGET_ORGANIZATION_MONTHLY_USD"
+	envBudgetOrganizationDailyTurns   = "BUDGET_ORGANIZATION_DAILY_TURNS"
+	envBudgetOrganizationMonthlyTurns = "BUDGET_ORGANIZATION_MONTHLY_TURNS"
+	envBudgetWarnPercent              = "BUDGET_WARN_PERCENT"
+	envBudgetExceededAction           = "BUDGET_EXCEEDED_ACTION"
+
+	envProviderKeyMasterKeys = "PROVIDER_KEY_MASTER_KEYS"
+	envProviderKeyFallback   = "PROVIDER_KEY_FALLBACK"
+
 	envCORSOrigins = "CORS_ALLOWED_ORIGINS"
 	envCORSMethods = "CORS_ALLOWED_METHODS"
 	envCORSHeaders = "CORS_ALLOWED_HEADERS"
 
 	defaultPort = "8080"
+
+	defaultAbandonAfterMinutes  = 0
+	defaultEndingTimeoutMinutes = 5
+	defaultMemoryTokenBudget    = 4000
+	defaultMemoryRecentTurns    = 4
+
+	defaultAccessTokenMinutes = 15
+	defaultRefreshTokenDays   = 30
+
+	defaultRateLimitIPPerMinute           = 120
+	defaultRateLimitIPBurst               = 40
+	defaultRateLimitAccountPerMinute      = 60
+	defaultRateLimitAccountBurst          = 20
+	defaultRateLimitConversationPerMinute = 20
+	defaultRateLimitConversationBurst     = 10
+	defaultRateLimitOIDCLoginPerMinute    = 10
+	defaultRateLimitOIDCLoginBurst        = 5
+	defaultRateLimitStartChatPerMinute    = 5
+	defaultRateLimitStartChatBurst        = 3
+	defaultMaxUpstreamCalls               = 32
+
+	defaultBudgetWarnPercent = 80
+
+	budgetActionBlock     = "block"
+	budgetActionDowngrade = "downgrade"
 )
 
 var (
 	defaultCORSOrigin  = []string{"*"}
 	defaultCORSMethods = []string{"GET", "POST"}
-	defaultCORSHeaders = []string{"Accept", "Authorization", "Content-Type"}
+	defaultCORSHeaders = []string{"Accept", "Authorization", "Content-Type", "X-API-Key", "X-Conversation-ID"}
 )
 
 func main() {
@@ -48,17 +128,128 @@ func main() {
 
 func initConfig() (config.AppConfig, error) {
 	cfg := config.AppConfig{
-		Port:   config.GetString(envPort, defaultPort),
-		APIKey: config.GetString(envAPIKey, ""),
-		DBPath: config.GetString(envDBPath, "./app.db"),
+		Port:        config.GetString(envPort, defaultPort),
+		APIKey:      config.GetString(envAPIKey, ""),
+		DBPath:      config.GetString(envDBPath, "./app.db"),
 		CORSOrigins: config.GetStrings(envCORSOrigins, defaultCORSOrigin),
 		CORSMethods: config.GetStrings(envCORSMethods, defaultCORSMethods),
 		CORSHeaders: config.GetStrings(envCORSHeaders, defaultCORSHeaders),
+
+		AdminAccountIDs: config.GetStrings(envAdminAccountIDs, nil),
+		OIDC: config.OIDC{
+			Issuer:            config.GetString(envOIDCIssuer, ""),
+			ClientID:          config.GetString(envOIDCClientID, ""),
+			ClientSecret:      config.GetString(envOIDCClientSecret, ""),
+			RedirectURL:       config.GetString(envOIDCRedirectURL, ""),
+			ClientRedirectURL: config.GetString(envOIDCClientRedirectURL, ""),
+		},
+
+		AbandonAfter:  time.Duration(config.GetInt(envAbandonAfterMinutes, defaultAbandonAfterMinutes)) * time.Minute,
+		EndingTimeout: time.Duration(config.GetInt(envEndingTimeoutMinutes, defaultEndingTimeoutMinutes)) * time.Minute,
+		Limits: config.Limits{
+			MaxTurns:        config.GetInt(envMaxTurns, 0),
+			MaxMinutes:      config.GetInt(envMaxMinutes, 0),
+			MaxAudioSeconds: config.GetInt(envMaxAudioSeconds, 0),
+		},
+		Memory: config.Memory{
+			TokenBudget: config.GetInt(envMemoryTokenBudget, defaultMemoryTokenBudget),
+			RecentTurns: max(config.GetInt(envMemoryRecentTurns, defaultMemoryRecentTurns), 1),
+		},
+		RateLimit: config.RateLimit{
+			IP:               perMinute(envRateLimitIPPerMinute, defaultRateLimitIPPerMinute, envRateLimitIPBurst, defaultRateLimitIPBurst),
+			Account:          perMinute(envRateLimitAccountPerMinute, defaultRateLimitAccountPerMinute, envRateLimitAccountBurst, defaultRateLimitAccountBurst),
+			Conversation:     perMinute(envRateLimitConversationPerMinute, defaultRateLimitConversationPerMinute, envRateLimitConversationBurst, defaultRateLimitConversationBurst),
+			OIDCLogin:        perMinute(envRateLimitOIDCLoginPerMinute, defaultRateLimitOIDCLoginPerMinute, envRateLimitOIDCLoginBurst, defaultRateLimitOIDCLoginBurst),
+			StartChat:        perMinute(envRateLimitStartChatPerMinute, defaultRateLimitStartChatPerMinute, envRateLimitStartChatBurst, defaultRateLimitStartChatBurst),
+			MaxUpstreamCalls: config.GetInt(envMaxUpstreamCalls, defaultMaxUpstreamCalls),
+			TrustProxy:       config.GetBool(envRateLimitTrustProxy, false),
+		},
+		Budgets: config.Budgets{
+			Account: config.Budget{
+				DailyCost:    dollars(envBudgetAccountDailyUSD),
+				MonthlyCost:  dollars(envBudgetAccountMonthlyUSD),
+				DailyTurns:   config.GetInt(envBudgetAccountDailyTurns, 0),
+				MonthlyTurns: config.GetInt(envBudgetAccountMonthlyTurns, 0),
+			},
+			Organization: config.Budget{
+				DailyCost:    dollars(envBudgetOrganizationDailyUSD),
+				MonthlyCost:  dollars(envBudgetOrganizationMonthlyUSD),
+				DailyTurns:   config.GetInt(envBudgetOrganizationDailyTurns, 0),
+				MonthlyTurns: config.GetInt(envBudgetOrganizationMonthlyTurns, 0),
+			},
+			WarnPercent: config.GetInt(envBudgetWarnPercent, defaultBudgetWarnPercent),
+			Downgrade:   config.GetString(envBudgetExceededAction, budgetActionBlock) == budgetActionDowngrade,
+		},
+		ProviderKeys: config.ProviderKeys{
+			Fallback: config.GetBool(envProviderKeyFallback, true),
+		},
+		Auth: config.Auth{
+			TokenSecret:     []byte(config.GetString(envAuthTokenSecret, "")),
+			AccessTokenTTL:  time.Duration(max(config.GetInt(envAuthAccessTokenMinutes, defaultAccessTokenMinutes), 1)) * time.Minute,
+			RefreshTokenTTL: time.Duration(max(config.GetInt(envAuthRefreshTokenDays, defaultRefreshTokenDays), 1)) * 24 * time.Hour,
+		},
+	}
+
+	// Without a configured secret, tokens only survive until the next restart
+	if len(cfg.Auth.TokenSecret) == 0 {
+		log.Printf("%s is not set, access tokens will be invalidated on restart", envAuthTokenSecret)
+		cfg.Auth.TokenSecret = []byte(util.GenerateToken(32))
+	}
+
+	if path := config.GetString(envLanguagesPath, ""); path != "" {
+		if err := config.LoadLanguages(path); err != nil {
+			return config.AppConfig{}, err
+		}
+	}
+
+	if cfg.OIDC.Enabled() && (cfg.OIDC.ClientID == "" || cfg.OIDC.RedirectURL == "") {
+		return config.AppConfig{}, fmt.Errorf("%s and %s are needed for single sign-on", envOIDCClientID, envOIDCRedirectURL)
+	}
+
+	if action := config.GetString(envBudgetExceededAction, budgetActionBlock); action != budgetActionBlock && action != budgetActionDowngrade {
+		return config.AppConfig{}, fmt.Errorf("%s must be %s or %s", envBudgetExceededAction, budgetActionBlock, budgetActionDowngrade)
 	}
 
+	masterKeys, err := masterKeys(envProviderKeyMasterKeys)
+	if err != nil {
+		return config.AppConfig{}, err
+	}
+	cfg.ProviderKeys.MasterKeys = masterKeys
+
 	if cfg.APIKey == "" {
 		return config.AppConfig{}, fmt.Errorf("API Key is needed")
 	}
 
 	return cfg, nil
 }
+
+// perMinute reads a rate limit given in requests per minute, where zero turns
+// the limit off.
+func perMinute(rateEnv string, defaultRate int, burstEnv string, defaultBurst int) ratelimit.Limit {
+	return ratelimit.Limit{
+		Requests: config.GetInt(rateEnv, defaultRate),
+		Per:      time.Minute,
+		Burst:    config.GetInt(burstEnv, defaultBurst),
+	}
+}
+
+// dollars reads an amount in US dollars as millionths of a dollar, where zero
+// means unlimited.
+func dollars(env string) int64 {
+	return int64(math.Round(config.GetFloat(env, 0) * 1e6))
+}
+
+// masterKeys reads comma-separated, base64-encoded master keys, the current
+// one first.
+func masterKeys(env string) ([][]byte, error) {
+	var keys [][]byte
+	for i, value := range config.GetStrings(env, nil) {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil || len(key) != keyring.KeySize {
+			return nil, fmt.Errorf("key %d of %s must be %d bytes encoded in base64", i+1, env, keyring.KeySize)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}